@@ -0,0 +1,21 @@
+package main
+
+import (
+	"github.com/gonuts/commander"
+	"github.com/gonuts/flag"
+)
+
+func hwaf_make_cmd_alias() *commander.Command {
+	cmd := &commander.Command{
+		UsageLine: "alias [options]",
+		Short:     "list or export the runtime aliases of the local project",
+		Subcommands: []*commander.Command{
+			hwaf_make_cmd_alias_dump(),
+			hwaf_make_cmd_alias_ls(),
+		},
+		Flag: *flag.NewFlagSet("hwaf-alias", flag.ExitOnError),
+	}
+	return cmd
+}
+
+// EOF
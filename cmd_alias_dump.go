@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/gonuts/commander"
+	"github.com/gonuts/flag"
+)
+
+func hwaf_make_cmd_alias_dump() *commander.Command {
+	cmd := &commander.Command{
+		Run:       hwaf_run_cmd_alias_dump,
+		UsageLine: "dump [options]",
+		Short:     "print the runtime aliases as shell alias definitions",
+		Long: `
+dump prints the runtime aliases declared by the local project as alias
+definitions for the given shell (sh, bash, zsh, csh, tcsh or fish).
+The default shell is taken from $SHELL.
+
+ex:
+ $ hwaf alias dump -shell=bash > aliases.sh
+ $ hwaf alias dump -shell=csh > aliases.csh
+ $ eval "$(hwaf alias dump)"
+`,
+		Flag: *flag.NewFlagSet("hwaf-alias-dump", flag.ExitOnError),
+	}
+	cmd.Flag.String("shell", "", "type of shell to print the aliases for (default=$SHELL)")
+	return cmd
+}
+
+func hwaf_run_cmd_alias_dump(cmd *commander.Command, args []string) error {
+	var err error
+	n := "hwaf-" + cmd.Name()
+
+	if len(args) != 0 {
+		return fmt.Errorf("%s: does not take any argument", n)
+	}
+
+	shell := cmd.Flag.Lookup("shell").Value.Get().(string)
+	if shell == "" {
+		shell = filepath.Base(os.Getenv("SHELL"))
+		if _, err := alias_writer_for(shell); err != nil {
+			shell = "sh"
+		}
+	}
+
+	write, err := alias_writer_for(shell)
+	if err != nil {
+		return err
+	}
+
+	aliases, err := runtime_aliases()
+	if err != nil {
+		return err
+	}
+
+	return write(os.Stdout, aliases)
+}
+
+// EOF
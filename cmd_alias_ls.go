@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/gonuts/commander"
+	"github.com/gonuts/flag"
+)
+
+func hwaf_make_cmd_alias_ls() *commander.Command {
+	cmd := &commander.Command{
+		Run:       hwaf_run_cmd_alias_ls,
+		UsageLine: "ls [options] [<alias-name> [<alias-name> [...]]]",
+		Short:     "list the runtime aliases of the local project",
+		Long: `
+ls lists the runtime aliases declared by the local project (or the given
+ones), in alphabetical order.
+Runtime aliases can be run with 'hwaf run <alias-name> [args...]'.
+
+ex:
+ $ hwaf alias ls
+ athena  athena.py
+ ll      ls -l
+
+ $ hwaf alias ls athena
+`,
+		Flag: *flag.NewFlagSet("hwaf-alias-ls", flag.ExitOnError),
+	}
+	return cmd
+}
+
+func hwaf_run_cmd_alias_ls(cmd *commander.Command, args []string) error {
+	var err error
+	n := "hwaf-" + cmd.Name()
+
+	aliases, err := runtime_aliases()
+	if err != nil {
+		return err
+	}
+
+	cmds := make(map[string]string, len(aliases))
+	for _, alias := range aliases {
+		cmds[alias[0]] = alias[1]
+	}
+
+	names := args
+	if len(names) == 0 {
+		names = make([]string, 0, len(cmds))
+		for k := range cmds {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+	}
+
+	width := 0
+	for _, k := range names {
+		if len(k) > width {
+			width = len(k)
+		}
+	}
+
+	for _, k := range names {
+		src, ok := cmds[k]
+		if !ok {
+			g_ctx.Errorf("no such alias %q\n", k)
+			err = fmt.Errorf("%s: unknown alias(es)", n)
+			continue
+		}
+		fmt.Printf("%-*s  %s\n", width, k, src)
+	}
+	return err
+}
+
+// EOF
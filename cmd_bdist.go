@@ -3,8 +3,8 @@ package main
 import (
 	"fmt"
 	"os"
-	// "os/exec"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/gonuts/commander"
@@ -19,12 +19,63 @@ func hwaf_make_cmd_waf_bdist() *commander.Command {
 		Long: `
 bdist creates a binary distribution from the project or packages.
 
+The tarball is written by streaming the content of the install area under a
+<name>-<version> top directory: the install area itself is left untouched.
+Entries are stored in lexical order, owned by root and with the same
+modification time ($SOURCE_DATE_EPOCH or the most recent one of the install
+area), so that the same install area always yields the same tarball.
+
+Every binary distribution embeds a manifest,
+share/hwaf/manifests/<name>-<version>.json, describing the project (name,
+version, variant, upstream projects, packages and their VCS revisions, build
+host, hwaf version) and holding the SHA-256 of every file.
+'hwaf bdist verify' checks a distribution against it.
+The setup scripts (setup.sh, setup.csh, setup.fish) are generated from the
+current runtime environment and added to the distribution: the install area
+itself is left untouched.
+
+The manifest also records the installation prefix the project was built
+for. When the distribution is installed elsewhere (eg: 'hwaf pmgr get'),
+the files referring to it are relocated to the installation directory:
+ELF RPATH/RUNPATH entries (in place, so only if the new path is not longer
+than the old one), shebang lines and text files (setup scripts,
+project.info, ...). Files which could not be relocated are reported.
+
+With -split, the install area is split into several binary distributions
+(sharing the same <name>-<version> top directory):
+ - <name>-<version>-<variant>.tar.gz:       runtime files (libraries,
+                                             binaries, python modules, data),
+ - <name>-devel-<version>-<variant>.tar.gz: development files (headers,
+                                             static libraries, pkg-config and
+                                             cmake files),
+ - <name>-debug-<version>-<variant>.tar.gz: separated debug information.
+Each one embeds its own manifest. Empty devel and debug distributions are
+not created. The path rules may be configured per project in the
+[hwaf-bdist-split] section of the configuration, with space separated
+patterns ("dir/" matches a directory and its content, "*.h" the base name
+of a file, "lib/*.so" its path). Files matching an explicit runtime rule
+stay in the runtime distribution, then the debug and devel rules apply:
+ [hwaf-bdist-split]
+ runtime = include/mana/data/
+ devel   = include/ *.h *.a *.pc lib/cmake/
+ debug   = lib/debug/ *.debug
+
+With -sign, a detached ed25519 signature of the tarball is written next to
+it (<tarball>.sig) with the given key (see 'hwaf keys'). Installation
+commands refuse binary distributions which are not signed by a trusted key.
+
 ex:
  $ hwaf bdist
  $ hwaf bdist -name=mana
  $ hwaf bdist -name=mana -version=20121218
  $ hwaf bdist -name=mana -version -variant=x86_64-linux-gcc-opt
+ $ hwaf bdist -compression=xz
+ $ hwaf bdist -sign=my-site
+ $ hwaf bdist -split
 `,
+		Subcommands: []*commander.Command{
+			hwaf_make_cmd_waf_bdist_verify(),
+		},
 		Flag: *flag.NewFlagSet("hwaf-bdist", flag.ExitOnError),
 		//CustomFlags: true,
 	}
@@ -32,6 +83,9 @@ ex:
 	cmd.Flag.String("name", "", "name of the binary distribution (default: project name)")
 	cmd.Flag.String("version", "", "version of the binary distribution (default: project version)")
 	cmd.Flag.String("variant", "", "HWAF_VARIANT quadruplet for the binary distribution (default: project VARIANT)")
+	cmd.Flag.String("sign", "", "name of (or path to) the key to sign the tarball with")
+	cmd.Flag.Bool("split", false, "split the distribution into runtime, devel and debug tarballs")
+	cmd.Flag.String("compression", "gz", "compression of the tarball ("+strings.Join(tar_formats(), "|")+")")
 	return cmd
 }
 
@@ -48,6 +102,16 @@ func hwaf_run_cmd_waf_bdist(cmd *commander.Command, args []string) error {
 	bdist_name := cmd.Flag.Lookup("name").Value.Get().(string)
 	bdist_vers := cmd.Flag.Lookup("version").Value.Get().(string)
 	bdist_variant := cmd.Flag.Lookup("variant").Value.Get().(string)
+	bdist_compress := cmd.Flag.Lookup("compression").Value.Get().(string)
+	bdist_sign := cmd.Flag.Lookup("sign").Value.Get().(string)
+	bdist_split := cmd.Flag.Lookup("split").Value.Get().(bool)
+
+	suffix, ok := tar_compressors[bdist_compress]
+	if !ok {
+		return fmt.Errorf("%s: unknown compression [%s] (expected one of %s)",
+			n, bdist_compress, strings.Join(tar_formats(), ", "),
+		)
+	}
 
 	workdir, err := g_ctx.Workarea()
 	if err != nil {
@@ -76,11 +140,10 @@ func hwaf_run_cmd_waf_bdist(cmd *commander.Command, args []string) error {
 			return err
 		}
 	}
-	fname := bdist_name + "-" + bdist_vers + "-" + bdist_variant + ".tar.gz"
-
-	//fmt.Printf(">> fname=[%s]\n", fname)
-	fname = filepath.Join(workdir, fname)
-
+	_, err = g_ctx.ParseVariant(bdist_variant)
+	if err != nil {
+		return err
+	}
 	// first try destdir
 	install_area, err := pinfos.Get("DESTDIR")
 	if err != nil {
@@ -100,33 +163,105 @@ func hwaf_run_cmd_waf_bdist(cmd *commander.Command, args []string) error {
 	}
 	// the prefix to prepend inside the tar-ball
 	prefix := bdist_name + "-" + bdist_vers //+ "-" + bdist_variant
-	// create a temporary install-area with the correct structure:
-	//  install-area/<pkgname>-<pkgvers>/...
-	bdist_dir := filepath.Join(workdir, ".hwaf-bdist-install-area-"+bdist_variant)
-	_ = os.RemoveAll(bdist_dir)
-	err = os.MkdirAll(bdist_dir, 0700)
-	if err != nil {
-		return err
+
+	var key key_t
+	if bdist_sign != "" {
+		// fail early on a missing key
+		key, err = key_load(bdist_sign)
+		if err != nil {
+			return err
+		}
 	}
 
-	// move the install-area...
-	err = os.Rename(install_area, filepath.Join(bdist_dir, prefix))
-	if err != nil {
-		return err
+	// up-to-date relocatable setup scripts
+	scripts := bdist_setup_scripts(install_area)
+
+	components := []string{"runtime"}
+	var split *bdist_split_t
+	if bdist_split {
+		split, err = bdist_new_split()
+		if err != nil {
+			return err
+		}
+		components = bdist_components
 	}
-	defer func() error {
-		err = os.Rename(filepath.Join(bdist_dir, prefix), install_area)
+
+	for _, comp := range components {
+		name := bdist_component_name(bdist_name, comp, "tar")
+		var keep func(name string) bool
+		if split != nil {
+			keep = split.keep(comp)
+		}
+
+		// the manifest of the distribution
+		manifest, err := bdist_new_manifest(install_area, name, bdist_vers, bdist_variant, keep)
+		if err != nil {
+			return err
+		}
+		base := filepath.Join(workdir, name+"-"+bdist_vers+"-"+bdist_variant)
+		fname := base + suffix
+		if comp != "runtime" && len(manifest.Files) == 0 {
+			g_ctx.Infof("no %s files: skipping [%s]\n", comp, filepath.Base(fname))
+			// do not leave the tarballs of a previous split behind
+			for _, format := range tar_formats() {
+				os.Remove(base + tar_compressors[format])
+				os.Remove(sig_fname(base + tar_compressors[format]))
+			}
+			continue
+		}
+		entries := []tar_entry_t{}
+		for _, script := range scripts {
+			if keep == nil || keep(script.name) {
+				entries = append(entries, script)
+			}
+		}
+		manifest.add_entries(entries)
+		data, err := manifest.data()
 		if err != nil {
 			return err
 		}
-		return os.RemoveAll(bdist_dir)
-	}()
 
-	err = _tar_gz(fname, bdist_dir)
-	if err != nil {
-		return err
+		entries = append(entries, tar_entry_t{name: bdist_manifest_name(name, bdist_vers), data: data})
+		err = tar_create(fname, install_area, prefix, bdist_compress, keep, entries...)
+		if err != nil {
+			return err
+		}
+
+		// a stale signature would not match the new tarball
+		err = os.Remove(sig_fname(fname))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if bdist_sign != "" {
+			err = sig_sign(fname, key)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// bdist_tarball returns the name of the tarball created by 'hwaf bdist' for
+// the distribution base (<name>-<version>-<variant>), whatever its
+// compression. The most recent one wins.
+func bdist_tarball(base string) (string, error) {
+	fname := ""
+	var mtime time.Time
+	for _, format := range tar_formats() {
+		fi, err := os.Stat(base + tar_compressors[format])
+		if err != nil {
+			continue
+		}
+		if fname == "" || fi.ModTime().After(mtime) {
+			fname = base + tar_compressors[format]
+			mtime = fi.ModTime()
+		}
+	}
+	if fname == "" {
+		return "", fmt.Errorf("no such file [%s%s]. did you run \"hwaf bdist\" ?", base, tar_compressors["gz"])
 	}
-	return err
+	return filepath.Abs(fname)
 }
 
 // EOF
@@ -1,13 +1,12 @@
 package main
 
 import (
+	"archive/tar"
+	"bytes"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"runtime"
 	"strings"
 	"text/template"
 	"time"
@@ -24,10 +23,25 @@ func hwaf_make_cmd_waf_bdist_deb() *commander.Command {
 		Long: `
 bdist-deb creates a DEB from the local project/packages.
 
+The DEB is written by hwaf itself from the tarball created by 'hwaf bdist'
+(Debian tools are not needed). The control file is generated with the
+md5sums of the files, their installed size and dependencies on the upstream
+projects listed in local.conf, unless a control file is given with -spec.
+xz compression of the data archive needs the xz command.
+The DEB only depends on the tarball: the modification time of the entries
+it adds is the one of the newest file of the tarball, or $SOURCE_DATE_EPOCH.
+
+With -split, a DEB is created for each of the tarballs of 'hwaf bdist -split':
+<name> (runtime files), <name>-dev (development files) and <name>-dbg (debug
+information). The -dev and -dbg packages depend on the exact version of the
+runtime one. -split can not be used with -spec.
+
 ex:
  $ hwaf bdist-deb
  $ hwaf bdist-deb -name=mana
  $ hwaf bdist-deb -name=mana -version=20130101
+ $ hwaf bdist-deb -compression=xz
+ $ hwaf bdist-deb -split
 `,
 		Flag: *flag.NewFlagSet("hwaf-bdist-deb", flag.ExitOnError),
 	}
@@ -38,6 +52,8 @@ ex:
 	cmd.Flag.String("variant", "", "HWAF_VARIANT quadruplet for the binary distribution (default: project variant)")
 	cmd.Flag.String("spec", "", "DEB control file for the binary distribution")
 	cmd.Flag.String("url", "", "URL for the DEB binary distribution")
+	cmd.Flag.String("compression", "gz", "compression of the DEB data archive (gz|xz)")
+	cmd.Flag.Bool("split", false, "create runtime, -dev and -dbg DEBs from the tarballs of 'hwaf bdist -split'")
 	return cmd
 }
 
@@ -48,7 +64,7 @@ func hwaf_run_cmd_waf_bdist_deb(cmd *commander.Command, args []string) error {
 	switch len(args) {
 	case 0:
 	default:
-		return fmt.Errorf("%s: too many arguments (%s)", n, len(args))
+		return fmt.Errorf("%s: too many arguments (%d)", n, len(args))
 	}
 
 	verbose := cmd.Flag.Lookup("v").Value.Get().(bool)
@@ -58,6 +74,11 @@ func hwaf_run_cmd_waf_bdist_deb(cmd *commander.Command, args []string) error {
 	bdist_release := cmd.Flag.Lookup("release").Value.Get().(string)
 	bdist_variant := cmd.Flag.Lookup("variant").Value.Get().(string)
 	bdist_spec := cmd.Flag.Lookup("spec").Value.Get().(string)
+	bdist_compress := cmd.Flag.Lookup("compression").Value.Get().(string)
+	bdist_split := cmd.Flag.Lookup("split").Value.Get().(bool)
+	if bdist_split && bdist_spec != "" {
+		return fmt.Errorf("%s: -split and -spec are mutually exclusive", n)
+	}
 
 	bdist_url := cmd.Flag.Lookup("url").Value.Get().(string)
 	if bdist_url == "" {
@@ -65,13 +86,15 @@ func hwaf_run_cmd_waf_bdist_deb(cmd *commander.Command, args []string) error {
 	}
 
 	type DebInfo struct {
-		Name      string // DEB package name
-		Vers      string // DEB package version
-		Release   string // DEB package release
-		Variant   string // DEB VARIANT quadruplet
-		BuildRoot string // DEB build directory
-		Url       string // URL home page
-		Arch      string // DEB architecture (32b/64b)
+		Name          string // DEB package name
+		Vers          string // DEB package version
+		Release       string // DEB package release
+		Variant       string // DEB VARIANT quadruplet
+		Url           string // URL home page
+		Arch          string // DEB architecture (32b/64b)
+		Section       string // DEB section
+		Depends       string // DEB dependencies
+		InstalledSize int64  // DEB installed size (KiB)
 	}
 
 	workdir, err := g_ctx.Workarea()
@@ -100,186 +123,193 @@ func hwaf_run_cmd_waf_bdist_deb(cmd *commander.Command, args []string) error {
 			return err
 		}
 	}
-	fname := bdist_name + "-" + bdist_vers + "-" + bdist_variant
-	debtopdir, err := ioutil.TempDir("", "hwaf-deb-buildroot-")
-	if err != nil {
-		return err
-	}
-	defer os.RemoveAll(debtopdir)
-
-	debbldroot := filepath.Join(debtopdir, "debian")
-	err = os.MkdirAll(debbldroot, 0755)
+	variant, err := g_ctx.ParseVariant(bdist_variant)
 	if err != nil {
 		return err
 	}
-
-	for _, dir := range []string{
-		"DEBIAN",
-	} {
-		err = os.MkdirAll(filepath.Join(debbldroot, dir), 0755)
-		if err != nil {
-			return err
-		}
-	}
-
-	specfile, err := os.Create(filepath.Join(debbldroot, "DEBIAN", "control"))
+	debtopdir, err := ioutil.TempDir("", "hwaf-deb-buildroot-")
 	if err != nil {
 		return err
 	}
+	defer os.RemoveAll(debtopdir)
 
 	debarch := ""
-	switch runtime.GOARCH {
-	case "amd64":
+	switch variant.Arch {
+	case "x86_64":
 		debarch = "amd64"
-	case "386":
+	case "i686":
 		debarch = "i386"
+	case "aarch64":
+		debarch = "arm64"
+	case "ppc64le":
+		debarch = "ppc64el"
 	default:
-		return fmt.Errorf("unhandled GOARCH [%s]", runtime.GOARCH)
+		return fmt.Errorf("unhandled architecture [%s]", variant.Arch)
 	}
 
-	debinfos := DebInfo{
-		Name:      bdist_name,
-		Vers:      bdist_vers,
-		Release:   bdist_release,
-		Variant:   bdist_variant,
-		BuildRoot: debbldroot,
-		Url:       bdist_url,
-		Arch:      debarch,
+	if _, ok := deb_compressors[bdist_compress]; !ok {
+		return fmt.Errorf("%s: unknown compression [%s] (expected gz or xz)", n, bdist_compress)
 	}
 
-	// get tarball from 'hwaf bdist'...
-	bdist_fname := strings.Replace(fname, ".deb", "", 1) + ".tar.gz"
-	if !path_exists(bdist_fname) {
-		err = fmt.Errorf("no such file [%s]. did you run \"hwaf bdist\" ?", bdist_fname)
-		if err != nil {
-			return err
-		}
-	}
-	bdist_fname, err = filepath.Abs(bdist_fname)
+	depends, err := deb_depends()
 	if err != nil {
 		return err
 	}
-	{
-		untar := exec.Command("tar", "-zxf", bdist_fname, "--strip", "1")
-		untar.Dir = debbldroot
-		untar.Stdin = os.Stdin
-		untar.Stdout = os.Stdout
-		untar.Stderr = os.Stderr
-		err = untar.Run()
+
+	runtime := deb_pkg_name(bdist_name)
+
+	// build creates the DEB of the sub-package comp from the tarball bdist_fname
+	build := func(comp, bdist_fname string) error {
+		debinfos := DebInfo{
+			Name:    deb_pkg_name(bdist_component_name(bdist_name, comp, "deb")),
+			Vers:    bdist_vers,
+			Release: bdist_release,
+			Variant: bdist_variant,
+			Url:     bdist_url,
+			Arch:    debarch,
+			Section: "devel",
+			Depends: strings.Join(append([]string{"coreutils"}, depends...), ", "),
+		}
+		if comp != "runtime" {
+			debinfos.Depends = fmt.Sprintf("%s (= %s-%s)", runtime, bdist_vers, bdist_release)
+		}
+		if comp == "debug" {
+			debinfos.Section = "debug"
+		}
+		fname := bdist_component_name(bdist_name, comp, "deb") + "-" + bdist_vers + "-" + bdist_variant
+
+		// the DEB only depends on the tarball (and $SOURCE_DATE_EPOCH)
+		mtime, err := deb_build_time(bdist_fname)
 		if err != nil {
 			return err
 		}
-	}
 
-	if bdist_spec != "" {
-		bdist_spec = os.ExpandEnv(bdist_spec)
-		bdist_spec, err = filepath.Abs(bdist_spec)
+		// rewrite the content of the tarball into the data archive
+		data, err := os.Create(filepath.Join(debtopdir, comp+"-data"+deb_compressors[bdist_compress]))
 		if err != nil {
 			return err
 		}
-
-		if !path_exists(bdist_spec) {
-			err = fmt.Errorf("no such file [%s]", bdist_spec)
+		defer data.Close()
+		var ddata *deb_data_t
+		{
+			src, err := tar_open(bdist_fname)
+			if err != nil {
+				return err
+			}
+			defer src.Close()
+			zw, err := deb_compress(data, bdist_compress)
+			if err != nil {
+				return err
+			}
+			ddata, err = deb_write_data(zw, tar.NewReader(src), 1, "/", mtime)
+			if err != nil {
+				zw.Close()
+				return err
+			}
+			err = zw.Close()
 			if err != nil {
 				return err
 			}
 		}
-		user_spec, err := os.Open(bdist_spec)
-		if err != nil {
-			return err
-		}
-		defer user_spec.Close()
+		debinfos.InstalledSize = ddata.size
 
-		_, err = io.Copy(specfile, user_spec)
-		if err != nil {
-			return err
-		}
-	} else {
-		bdist_spec = specfile.Name()
+		control := new(bytes.Buffer)
+		if bdist_spec != "" {
+			bdist_spec = os.ExpandEnv(bdist_spec)
+			bdist_spec, err = filepath.Abs(bdist_spec)
+			if err != nil {
+				return err
+			}
 
-		var spec_tmpl *template.Template
-		spec_tmpl, err = template.New("SPEC").Parse(`
-Package: {{.Name}}
+			if !path_exists(bdist_spec) {
+				err = fmt.Errorf("no such file [%s]", bdist_spec)
+				if err != nil {
+					return err
+				}
+			}
+			user_spec, err := ioutil.ReadFile(bdist_spec)
+			if err != nil {
+				return err
+			}
+			control.Write(bytes.TrimLeft(user_spec, "\n"))
+			if !bytes.HasSuffix(user_spec, []byte("\n")) {
+				control.WriteString("\n")
+			}
+			if !bytes.Contains(user_spec, []byte("Installed-Size:")) {
+				fmt.Fprintf(control, "Installed-Size: %d\n", ddata.size)
+			}
+		} else {
+			var spec_tmpl *template.Template
+			spec_tmpl, err = template.New("SPEC").Parse(`Package: {{.Name}}
 Version: {{.Vers}}-{{.Release}}
-Section: devel
+Section: {{.Section}}
 Priority: optional
 Architecture: {{.Arch}}
-Depends: coreutils
+Depends: {{.Depends}}
+Installed-Size: {{.InstalledSize}}
 Maintainer: hwaf
+Homepage: {{.Url}}
 Description: hwaf generated DEB for {{.Name}}
 `) // */ for emacs...
+			if err != nil {
+				return err
+			}
+
+			err = spec_tmpl.Execute(control, debinfos)
+			if err != nil {
+				return err
+			}
+		}
+
+		if !strings.HasSuffix(fname, ".deb") {
+			fname = fname + ".deb"
+		}
+
+		if verbose {
+			fmt.Printf("%s: building DEB [%s]...\n", n, fname)
+		}
+
+		dst, err := os.Create(fname)
 		if err != nil {
 			return err
 		}
+		defer dst.Close()
 
-		err = spec_tmpl.Execute(specfile, debinfos)
+		err = deb_write(dst, control.Bytes(), ddata, data, bdist_compress, mtime)
+		if err != nil {
+			return err
+		}
+		err = dst.Sync()
 		if err != nil {
 			return err
 		}
-	}
-
-	err = specfile.Sync()
-	if err != nil {
-		return err
-	}
-	err = specfile.Close()
-	if err != nil {
-		return err
-	}
-
-	if !strings.HasSuffix(fname, ".deb") {
-		fname = fname + ".deb"
-	}
-
-	if verbose {
-		fmt.Printf("%s: building DEB [%s]...\n", n, fname)
-	}
-
-	debbld, err := exec.LookPath("dpkg-deb")
-	if err != nil {
-		return err
-	}
-	//dpkg-deb --build debian
-	deb := exec.Command(debbld,
-		"--build",
-		"debian",
-	)
-	deb.Dir = debtopdir
-	if verbose {
-		deb.Stdin = os.Stdin
-		deb.Stdout = os.Stdout
-		deb.Stderr = os.Stderr
-	}
-	err = deb.Run()
-	if err != nil {
-		return err
-	}
 
-	dst, err := os.Create(fname)
-	if err != nil {
-		return err
+		if verbose {
+			fmt.Printf("%s: building DEB [%s]...[ok]\n", n, fname)
+		}
+		return nil
 	}
-	defer dst.Close()
 
-	src, err := os.Open(filepath.Join(debtopdir, "debian.deb"))
-	if err != nil {
-		return err
+	components := []string{"runtime"}
+	if bdist_split {
+		components = bdist_components
 	}
-	defer src.Close()
-
-	_, err = io.Copy(dst, src)
-	if err != nil {
-		return err
-	}
-	err = dst.Sync()
-	if err != nil {
-		return err
-	}
-
-	if verbose {
-		fmt.Printf("%s: building DEB [%s]...[ok]\n", n, fname)
+	for _, comp := range components {
+		// get tarball from 'hwaf bdist'...
+		base := bdist_component_name(bdist_name, comp, "tar") + "-" + bdist_vers + "-" + bdist_variant
+		bdist_fname, err := bdist_tarball(base)
+		if err != nil {
+			if comp != "runtime" {
+				// 'hwaf bdist -split' skips empty sub-packages
+				continue
+			}
+			return err
+		}
+		err = build(comp, bdist_fname)
+		if err != nil {
+			return err
+		}
 	}
-
 	return nil
 }
 
@@ -40,7 +40,7 @@ func hwaf_run_cmd_waf_bdist_dmg(cmd *commander.Command, args []string) error {
 	switch len(args) {
 	case 0:
 	default:
-		return fmt.Errorf("%s: too many arguments (%s)", n, len(args))
+		return fmt.Errorf("%s: too many arguments (%d)", n, len(args))
 	}
 
 	verbose := cmd.Flag.Lookup("v").Value.Get().(bool)
@@ -76,6 +76,10 @@ func hwaf_run_cmd_waf_bdist_dmg(cmd *commander.Command, args []string) error {
 			return err
 		}
 	}
+	_, err = g_ctx.ParseVariant(bdist_variant)
+	if err != nil {
+		return err
+	}
 
 	bdist_fname := bdist_name + "-" + bdist_vers + "-" + bdist_variant + ".dmg"
 
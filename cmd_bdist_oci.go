@@ -0,0 +1,314 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gonuts/commander"
+	"github.com/gonuts/flag"
+)
+
+func hwaf_make_cmd_waf_bdist_oci() *commander.Command {
+	cmd := &commander.Command{
+		Run:       hwaf_run_cmd_waf_bdist_oci,
+		UsageLine: "bdist-oci [options]",
+		Short:     "create an OCI image from the local project/packages",
+		Long: `
+bdist-oci creates an OCI image layout (as a tarball) from the local
+project/packages, without any container daemon.
+
+The install area is stored as a single layer, under the -prefix directory of
+the image (default: the installation prefix of the project), on top of the
+layers of an optional base image read from an OCI image layout directory
+(-base), eg. as created by 'skopeo copy docker://centos:7 oci:centos7'.
+The setup scripts of the layer are generated from the current runtime
+environment: the install area itself is left untouched.
+
+The configuration of the image holds:
+ - the environment of the base image, modified by the runtime environment of
+   the project (the one of its setup scripts),
+ - the runtime aliases of the project, as org.hwaf.alias.<alias> labels,
+ - the name, version and variant of the project, as labels.
+
+The image is named -tag (default: <version>) in the index of the layout.
+The output only depends on the installed files, the VCS revisions of the
+packages and the version of hwaf (recorded in the manifest of the binary
+distribution, without the build host): the creation time is the one of the
+newest file, or $SOURCE_DATE_EPOCH.
+
+ex:
+ $ hwaf bdist-oci
+ $ hwaf bdist-oci -name=mana -version=20130101
+ $ hwaf bdist-oci -base=./centos7 -prefix=/opt/mana
+ $ hwaf bdist-oci -base=./images -base-ref=centos7 -tag=latest
+ $ skopeo copy oci-archive:mana-20130101-x86_64-slc6-gcc47-opt.oci.tar docker-daemon:mana:20130101
+`,
+		Flag: *flag.NewFlagSet("hwaf-bdist-oci", flag.ExitOnError),
+	}
+	cmd.Flag.Bool("v", false, "enable verbose output")
+	cmd.Flag.String("name", "", "name of the binary distribution (default: project name)")
+	cmd.Flag.String("version", "", "version of the binary distribution (default: project version)")
+	cmd.Flag.String("variant", "", "HWAF_VARIANT quadruplet for the binary distribution (default: project variant)")
+	cmd.Flag.String("prefix", "", "directory of the image where to install the project (default: project prefix)")
+	cmd.Flag.String("base", "", "OCI image layout directory holding the base image")
+	cmd.Flag.String("base-ref", "", "name of the base image in the -base layout (default: its only image)")
+	cmd.Flag.String("tag", "", "name of the image in the OCI image layout (default: version)")
+	return cmd
+}
+
+func hwaf_run_cmd_waf_bdist_oci(cmd *commander.Command, args []string) error {
+	var err error
+	n := "hwaf-" + cmd.Name()
+
+	switch len(args) {
+	case 0:
+	default:
+		return fmt.Errorf("%s: too many arguments (%d)", n, len(args))
+	}
+
+	verbose := cmd.Flag.Lookup("v").Value.Get().(bool)
+
+	bdist_name := cmd.Flag.Lookup("name").Value.Get().(string)
+	bdist_vers := cmd.Flag.Lookup("version").Value.Get().(string)
+	bdist_variant := cmd.Flag.Lookup("variant").Value.Get().(string)
+	bdist_prefix := cmd.Flag.Lookup("prefix").Value.Get().(string)
+	bdist_base := cmd.Flag.Lookup("base").Value.Get().(string)
+	bdist_base_ref := cmd.Flag.Lookup("base-ref").Value.Get().(string)
+	bdist_tag := cmd.Flag.Lookup("tag").Value.Get().(string)
+
+	workdir, err := g_ctx.Workarea()
+	if err != nil {
+		// not a git repo... assume we are at the root, then...
+		workdir, err = os.Getwd()
+	}
+	if err != nil {
+		return err
+	}
+
+	pinfos, err := g_ctx.ProjectInfos()
+	if err != nil {
+		return err
+	}
+
+	if bdist_name == "" {
+		bdist_name = workdir
+		bdist_name = filepath.Base(bdist_name)
+	}
+	if bdist_vers == "" {
+		bdist_vers = time.Now().Format("20060102")
+	}
+	if bdist_variant == "" {
+		bdist_variant, err = pinfos.Get("HWAF_VARIANT")
+		if err != nil {
+			return err
+		}
+	}
+	variant, err := g_ctx.ParseVariant(bdist_variant)
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(variant.Os, "darwin") || strings.HasPrefix(variant.Os, "mac") {
+		return fmt.Errorf("%s: OCI images need a linux variant (got [%s])", n, bdist_variant)
+	}
+	arch, err := oci_arch(variant.Arch)
+	if err != nil {
+		return err
+	}
+	if bdist_tag == "" {
+		bdist_tag = bdist_vers
+	}
+	fname := filepath.Join(workdir, bdist_name+"-"+bdist_vers+"-"+bdist_variant+".oci.tar")
+
+	install_area, err := project_install_area(pinfos)
+	if err != nil {
+		return err
+	}
+	if !path_exists(install_area) {
+		return fmt.Errorf(
+			"no such directory [%s]. did you run \"hwaf install\" ?",
+			install_area,
+		)
+	}
+	if bdist_prefix == "" {
+		bdist_prefix, err = pinfos.Get("PREFIX")
+		if err != nil {
+			return err
+		}
+	}
+	bdist_prefix = path.Clean("/" + filepath.ToSlash(bdist_prefix))
+	if bdist_prefix == "/" {
+		return fmt.Errorf("%s: invalid prefix [%s]", n, bdist_prefix)
+	}
+
+	// the runtime environment of the image
+	ops, err := hwaf_runtime_env()
+	if err != nil {
+		return err
+	}
+	ops = setup_env_ops(pinfos, ops, install_area)
+	for i, op := range ops {
+		// relocate the install area under the prefix of the image
+		ops[i].Value = env_quote_root(op.Value, op.Root, func(s string) string { return s }, bdist_prefix)
+		ops[i].Root = ""
+	}
+
+	aliases, err := runtime_aliases()
+	if err != nil {
+		return err
+	}
+
+	image := oci_image_t{}
+	layers := []oci_descriptor_t{}
+	blobs := []oci_blob_t{}
+	if bdist_base != "" {
+		bdist_base = os.ExpandEnv(bdist_base)
+		base, err := oci_read_base(bdist_base, bdist_base_ref, arch, "linux")
+		if err != nil {
+			return err
+		}
+		image = base.config
+		for _, layer := range base.manifest.Layers {
+			layer.MediaType = oci_layer_media_type(layer.MediaType)
+			layers = append(layers, layer)
+			blob := oci_blob_t{desc: layer}
+			blob.fname, err = oci_blob_fname(bdist_base, layer.Digest)
+			if err != nil {
+				return err
+			}
+			blobs = append(blobs, blob)
+		}
+		if verbose {
+			fmt.Printf("%s: base image [%s] (%d layers)\n", n, bdist_base, len(layers))
+		}
+	}
+
+	mtime, err := tar_mtime(install_area)
+	if err != nil {
+		return err
+	}
+	created := mtime.UTC().Format(time.RFC3339)
+
+	// the environment of the image
+	env := make(map[string]string)
+	for _, kv := range image.Config.Env {
+		toks := strings.SplitN(kv, "=", 2)
+		if len(toks) == 2 {
+			env[toks[0]] = toks[1]
+		}
+	}
+	if _, ok := env["PATH"]; !ok {
+		env["PATH"] = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+	}
+	env_apply(env, ops)
+	image.Config.Env = environ_list(env)
+
+	labels := make(map[string]string, len(image.Config.Labels)+len(aliases)+3)
+	for k, v := range image.Config.Labels {
+		labels[k] = v
+	}
+	labels["org.opencontainers.image.title"] = bdist_name
+	labels["org.opencontainers.image.version"] = bdist_vers
+	labels["org.hwaf.variant"] = bdist_variant
+	for _, alias := range aliases {
+		labels["org.hwaf.alias."+alias[0]] = alias[1]
+	}
+	image.Config.Labels = labels
+
+	// the layer holding the install area
+	tmpdir, err := ioutil.TempDir("", "hwaf-bdist-oci-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpdir)
+
+	manifest, err := bdist_new_manifest(install_area, bdist_name, bdist_vers, bdist_variant, nil)
+	if err != nil {
+		return err
+	}
+	// the same install area yields the same image on any host
+	manifest.Host = ""
+	// up-to-date relocatable setup scripts
+	entries := bdist_setup_scripts(install_area)
+	manifest.add_entries(entries)
+	data, err := manifest.data()
+	if err != nil {
+		return err
+	}
+	if verbose {
+		fmt.Printf("%s: creating layer from [%s]...\n", n, install_area)
+	}
+	layer := oci_blob_t{fname: filepath.Join(tmpdir, "layer.tar.gz")}
+	var diff_id string
+	entries = append(entries, tar_entry_t{name: bdist_manifest_name(bdist_name, bdist_vers), data: data})
+	layer.desc, diff_id, err = oci_layer(layer.fname, install_area, strings.TrimPrefix(bdist_prefix, "/"), entries...)
+	if err != nil {
+		return err
+	}
+	layers = append(layers, layer.desc)
+	blobs = append(blobs, layer)
+
+	image.Created = created
+	image.Architecture = arch
+	image.OS = "linux"
+	image.RootFS.Type = "layers"
+	image.RootFS.DiffIDs = append(image.RootFS.DiffIDs, diff_id)
+	image.History = append(image.History, oci_history_t{
+		Created:   created,
+		CreatedBy: "hwaf bdist-oci (hwaf-" + g_ctx.Version() + ")",
+		Comment:   bdist_name + "-" + bdist_vers + " (" + bdist_variant + ") installed under " + bdist_prefix,
+	})
+
+	config, err := oci_new_blob(oci_media_config, image)
+	if err != nil {
+		return err
+	}
+	blobs = append(blobs, config)
+
+	imgmanifest, err := oci_new_blob(oci_media_manifest, oci_manifest_t{
+		SchemaVersion: 2,
+		MediaType:     oci_media_manifest,
+		Config:        config.desc,
+		Layers:        layers,
+		Annotations: map[string]string{
+			"org.opencontainers.image.created": created,
+			"org.opencontainers.image.title":   bdist_name,
+			"org.opencontainers.image.version": bdist_vers,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	blobs = append(blobs, imgmanifest)
+
+	desc := imgmanifest.desc
+	desc.Annotations = map[string]string{oci_ref_name: bdist_tag}
+	desc.Platform = &oci_platform_t{Architecture: arch, OS: "linux"}
+
+	if verbose {
+		keys := make([]string, 0, len(labels))
+		for k := range labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Printf("%s: label %s=%q\n", n, k, labels[k])
+		}
+		fmt.Printf("%s: building OCI image [%s]...\n", n, fname)
+	}
+	err = oci_write_layout(fname, desc, blobs, mtime)
+	if err != nil {
+		return err
+	}
+	if verbose {
+		fmt.Printf("%s: building OCI image [%s]...[ok]\n", n, fname)
+	}
+	return nil
+}
+
+// EOF
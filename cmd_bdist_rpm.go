@@ -1,13 +1,13 @@
 package main
 
 import (
+	"crypto/md5"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"runtime"
 	"strings"
 	"text/template"
 	"time"
@@ -24,10 +24,25 @@ func hwaf_make_cmd_waf_bdist_rpm() *commander.Command {
 		Long: `
 bdist-rpm creates a RPM from the local project/packages.
 
+By default, the RPM is built by rpmbuild from the tarball created by
+'hwaf bdist'. With -native, hwaf writes the RPM itself directly from the
+install area, on any host: Provides and Requires are derived from the
+upstream projects listed in local.conf and from the sonames of the shared
+libraries. The output only depends on the installed files (the build time
+is the one of the newest file, or $SOURCE_DATE_EPOCH).
+
+With -split (which needs -native), the install area is split into several
+RPMs following the path rules of 'hwaf bdist -split': <name> (runtime files),
+<name>-devel (development files) and <name>-debuginfo (debug information).
+The -devel and -debuginfo RPMs require the exact version of the runtime one;
+empty ones are not created.
+
 ex:
  $ hwaf bdist-rpm
  $ hwaf bdist-rpm -name=mana
  $ hwaf bdist-rpm -name=mana -version=20130101
+ $ hwaf bdist-rpm -native
+ $ hwaf bdist-rpm -native -split
 `,
 		Flag: *flag.NewFlagSet("hwaf-bdist-rpm", flag.ExitOnError),
 	}
@@ -38,6 +53,8 @@ ex:
 	cmd.Flag.String("variant", "", "HWAF_VARIANT quadruplet for the binary distribution (default: project variant)")
 	cmd.Flag.String("spec", "", "RPM SPEC file for the binary distribution")
 	cmd.Flag.String("url", "", "URL for the RPM binary distribution")
+	cmd.Flag.Bool("native", false, "write the RPM with hwaf itself, from the install area (rpmbuild is not needed)")
+	cmd.Flag.Bool("split", false, "create runtime, -devel and -debuginfo RPMs (needs -native)")
 	return cmd
 }
 
@@ -48,7 +65,7 @@ func hwaf_run_cmd_waf_bdist_rpm(cmd *commander.Command, args []string) error {
 	switch len(args) {
 	case 0:
 	default:
-		return fmt.Errorf("%s: too many arguments (%s)", n, len(args))
+		return fmt.Errorf("%s: too many arguments (%d)", n, len(args))
 	}
 
 	verbose := cmd.Flag.Lookup("v").Value.Get().(bool)
@@ -58,6 +75,11 @@ func hwaf_run_cmd_waf_bdist_rpm(cmd *commander.Command, args []string) error {
 	bdist_release := cmd.Flag.Lookup("release").Value.Get().(string)
 	bdist_variant := cmd.Flag.Lookup("variant").Value.Get().(string)
 	bdist_spec := cmd.Flag.Lookup("spec").Value.Get().(string)
+	native := cmd.Flag.Lookup("native").Value.Get().(bool)
+	bdist_split := cmd.Flag.Lookup("split").Value.Get().(bool)
+	if bdist_split && !native {
+		return fmt.Errorf("%s: -split needs -native", n)
+	}
 
 	bdist_url := cmd.Flag.Lookup("url").Value.Get().(string)
 	if bdist_url == "" {
@@ -71,6 +93,7 @@ func hwaf_run_cmd_waf_bdist_rpm(cmd *commander.Command, args []string) error {
 		Variant   string // RPM VARIANT quadruplet
 		BuildRoot string // RPM build directory
 		Url       string // URL home page
+		Source    string // name of the tarball from 'hwaf bdist'
 	}
 
 	workdir, err := g_ctx.Workarea()
@@ -99,7 +122,77 @@ func hwaf_run_cmd_waf_bdist_rpm(cmd *commander.Command, args []string) error {
 			return err
 		}
 	}
+	variant, err := g_ctx.ParseVariant(bdist_variant)
+	if err != nil {
+		return err
+	}
 	fname := bdist_name + "-" + bdist_vers + "-" + bdist_variant
+
+	rpmarch := ""
+	switch variant.Arch {
+	case "x86_64":
+		rpmarch = "x86_64"
+	case "i686":
+		rpmarch = "i386"
+	case "aarch64", "ppc64le":
+		rpmarch = variant.Arch
+	default:
+		return fmt.Errorf("unhandled architecture [%s]", variant.Arch)
+	}
+
+	if native {
+		if bdist_spec != "" {
+			return fmt.Errorf("%s: -spec can not be used with -native", n)
+		}
+		components := []string{"runtime"}
+		var split *bdist_split_t
+		if bdist_split {
+			split, err = bdist_new_split()
+			if err != nil {
+				return err
+			}
+			components = bdist_components
+		}
+		for _, comp := range components {
+			name := bdist_component_name(bdist_name, comp, "rpm")
+			fname := name + "-" + bdist_vers + "-" + bdist_variant + ".rpm"
+			var keep func(name string) bool
+			if split != nil {
+				keep = split.keep(comp)
+			}
+			if verbose {
+				fmt.Printf("%s: building RPM [%s]...\n", n, fname)
+			}
+			infos := rpm_infos_t{
+				Name:    name,
+				Vers:    bdist_vers,
+				Release: bdist_release,
+				Arch:    rpmarch,
+				Url:     bdist_url,
+				Summary: "hwaf generated RPM for " + name,
+			}
+			if comp != "runtime" {
+				infos.Requires = []rpm_dep_t{{
+					name:  bdist_name,
+					flags: rpmsense_equal,
+					vers:  bdist_vers + "-" + bdist_release,
+				}}
+			}
+			ok, err := hwaf_bdist_rpm_native(fname, infos, bdist_variant, comp, keep)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				g_ctx.Infof("no %s files: skipping [%s]\n", comp, fname)
+				continue
+			}
+			if verbose {
+				fmt.Printf("%s: building RPM [%s]...[ok]\n", n, fname)
+			}
+		}
+		return nil
+	}
+
 	rpmbldroot, err := ioutil.TempDir("", "hwaf-rpm-buildroot-")
 	if err != nil {
 		return err
@@ -129,14 +222,11 @@ func hwaf_run_cmd_waf_bdist_rpm(cmd *commander.Command, args []string) error {
 	}
 
 	// get tarball from 'hwaf bdist'...
-	bdist_fname := strings.Replace(fname, ".rpm", "", 1) + ".tar.gz"
-	if !path_exists(bdist_fname) {
-		return fmt.Errorf("no such file [%s]. did you run \"hwaf bdist\" ?", bdist_fname)
-	}
-	bdist_fname, err = filepath.Abs(bdist_fname)
+	bdist_fname, err := bdist_tarball(strings.Replace(fname, ".rpm", "", 1))
 	if err != nil {
 		return err
 	}
+	rpminfos.Source = filepath.Base(bdist_fname)
 	{
 		// first, massage the tar ball to something rpmbuild expects...
 
@@ -193,7 +283,7 @@ Version: {{.Vers}}
 Release: {{.Release}}
 License: Unknown
 Group: Development/Tools
-SOURCE0 : %{name}-%{version}-%{variant}.tar.gz
+SOURCE0 : {{.Source}}
 URL: {{.Url}}
 
 BuildRoot: %{_tmppath}/%{name}-%{version}-%{release}-root
@@ -276,15 +366,6 @@ rm -rf %{buildroot}
 	}
 	defer dst.Close()
 
-	rpmarch := ""
-	switch runtime.GOARCH {
-	case "amd64":
-		rpmarch = "x86_64"
-	case "386":
-		rpmarch = "i386"
-	default:
-		return fmt.Errorf("unhandled GOARCH [%s]", runtime.GOARCH)
-	}
 	srcname := fmt.Sprintf(
 		"%s-%s-%s.%s.rpm",
 		rpminfos.Name,
@@ -314,4 +395,102 @@ rm -rf %{buildroot}
 	return nil
 }
 
+// hwaf_bdist_rpm_native writes the RPM fname from the install area of the
+// local project, together with the manifest of the distribution.
+// With a keep filter, only the files of the sub-package comp (see
+// bdist_split_t) are written, and hwaf_bdist_rpm_native returns false
+// without writing anything if there are none (except for the runtime one).
+func hwaf_bdist_rpm_native(fname string, infos rpm_infos_t, variant, comp string, keep func(name string) bool) (bool, error) {
+	pinfos, err := g_ctx.ProjectInfos()
+	if err != nil {
+		return false, err
+	}
+	install_area, err := project_install_area(pinfos)
+	if err != nil {
+		return false, err
+	}
+	if !path_exists(install_area) {
+		return false, fmt.Errorf(
+			"no such directory [%s]. did you run \"hwaf install\" ?",
+			install_area,
+		)
+	}
+
+	all, err := rpm_collect_files(install_area, "/")
+	if err != nil {
+		return false, err
+	}
+	files := all[:0]
+	for _, file := range all {
+		if keep == nil || keep(strings.TrimPrefix(file.name, "/")) {
+			files = append(files, file)
+		}
+	}
+	if len(files) == 0 && comp != "runtime" {
+		return false, nil
+	}
+
+	manifest, err := bdist_new_manifest(install_area, infos.Name, infos.Vers, variant, keep)
+	if err != nil {
+		return false, err
+	}
+	data, err := manifest.data()
+	if err != nil {
+		return false, err
+	}
+	mname := "/" + bdist_manifest_name(infos.Name, infos.Vers)
+	for i, file := range files {
+		if file.name == mname {
+			// stale manifest in the install area
+			files = append(files[:i], files[i+1:]...)
+			break
+		}
+	}
+	files = append(files, rpm_file_t{
+		name:  mname,
+		mode:  0644,
+		size:  int64(len(data)),
+		mtime: rpm_build_time(files),
+		md5:   fmt.Sprintf("%x", md5.Sum(data)),
+		data:  data,
+	})
+	files = rpm_add_dirs(files, "/")
+
+	// devel and debuginfo sub-packages only depend on the runtime one
+	if comp == "runtime" {
+		projs, err := upstream_projects()
+		if err != nil {
+			return false, err
+		}
+		for _, proj := range projs {
+			dep := rpm_dep_t{name: proj[0]}
+			if proj[1] != "" {
+				dep.flags = rpmsense_greater | rpmsense_equal
+				dep.vers = proj[1]
+			}
+			infos.Requires = append(infos.Requires, dep)
+		}
+
+		provides, requires := rpm_elf_deps(files)
+		for _, soname := range provides {
+			infos.Provides = append(infos.Provides, rpm_dep_t{name: soname})
+		}
+		for _, soname := range requires {
+			infos.Requires = append(infos.Requires, rpm_dep_t{name: soname})
+		}
+	}
+
+	f, err := os.Create(fname)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	err = rpm_write(f, infos, files)
+	if err != nil {
+		return false, err
+	}
+	return true, f.Close()
+}
+
 // EOF
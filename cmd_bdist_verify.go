@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gonuts/commander"
+	"github.com/gonuts/flag"
+)
+
+func hwaf_make_cmd_waf_bdist_verify() *commander.Command {
+	cmd := &commander.Command{
+		Run:       hwaf_run_cmd_waf_bdist_verify,
+		UsageLine: "verify [options] <file|dir>",
+		Short:     "check a binary distribution against its manifest",
+		Long: `
+verify checks a binary distribution against the manifest embedded by
+'hwaf bdist', 'hwaf bdist-rpm' or 'hwaf bdist-deb'.
+
+The binary distribution may be an archive (tarball, RPM or DEB) or an
+installed tree. verify reports:
+ - missing files:    files of the manifest which are not in the distribution,
+ - modified files:   files whose SHA-256 (or symlink target) differs from the
+                     one recorded in the manifest,
+ - unexpected files: files of an archive which are not in the manifest.
+                     (for installed trees, they are only listed with -v, as
+                     several distributions may share an install area.)
+
+The detached signature of an archive (<file>.sig), if any, is checked
+against the trusted keys (see 'hwaf keys').
+
+verify exits with a non-zero status if any problem was found.
+
+ex:
+ $ hwaf bdist verify mana-20130101-x86_64-linux-gcc-opt.tar.gz
+ $ hwaf bdist verify mana-20130101-1.x86_64.rpm
+ $ hwaf bdist verify -v /opt/sw/mana/20130101
+`,
+		Flag: *flag.NewFlagSet("hwaf-bdist-verify", flag.ExitOnError),
+	}
+	cmd.Flag.Bool("v", false, "enable verbose output")
+	return cmd
+}
+
+func hwaf_run_cmd_waf_bdist_verify(cmd *commander.Command, args []string) error {
+	var err error
+	n := "hwaf-" + cmd.Name()
+
+	switch len(args) {
+	case 1:
+	default:
+		return fmt.Errorf("%s: you need to give a binary distribution file or directory", n)
+	}
+
+	verbose := cmd.Flag.Lookup("v").Value.Get().(bool)
+
+	target := os.ExpandEnv(args[0])
+	fi, err := os.Stat(target)
+	if err != nil {
+		return err
+	}
+
+	var problems []string
+	if fi.IsDir() {
+		problems, err = bdist_verify_dir(target, verbose)
+	} else {
+		problems, err = bdist_verify_archive(target, verbose)
+	}
+	if err != nil {
+		return err
+	}
+
+	if !fi.IsDir() {
+		k, err := sig_verify(target)
+		switch {
+		case err == nil:
+			fmt.Printf("%s: [%s] signed by [%s] (%s)\n", n, target, k.Name, k.Id)
+		case err == err_unsigned:
+			if verbose {
+				fmt.Printf("%s: [%s] is not signed\n", n, target)
+			}
+		default:
+			problems = append(problems, fmt.Sprintf("signature:  %v", err))
+		}
+	}
+
+	for _, msg := range problems {
+		fmt.Printf("%s\n", msg)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s: %d problem(s) found in [%s]", n, len(problems), target)
+	}
+	fmt.Printf("%s: [%s] is consistent with its manifest\n", n, target)
+	return err
+}
+
+// bdist_print_manifest prints a summary of the manifest name
+func bdist_print_manifest(name string, m *bdist_manifest_t) {
+	fmt.Printf("manifest [%s]:\n", name)
+	fmt.Printf("  project:  %s-%s (%s)\n", m.Name, m.Version, m.Variant)
+	fmt.Printf("  built on: %s (hwaf %s, %s)\n", m.Host, m.HwafVersion, m.HwafRevision)
+	if m.Prefix != "" {
+		fmt.Printf("  prefix:   %s\n", m.Prefix)
+	}
+	for _, proj := range m.Projects {
+		fmt.Printf("  upstream: %s-%s\n", proj.Name, proj.Version)
+	}
+	for _, pkg := range m.Packages {
+		rev := pkg.Revision
+		if rev == "" {
+			rev = "n/a"
+		}
+		fmt.Printf("  package:  %s (%s, %s)\n", pkg.Path, pkg.Type, rev)
+	}
+	fmt.Printf("  files:    %d\n", len(m.Files))
+}
+
+// bdist_verify_archive checks the archive fname against the manifests it holds
+func bdist_verify_archive(fname string, verbose bool) ([]string, error) {
+	ar, err := bdist_read_archive(fname)
+	if err != nil {
+		return nil, err
+	}
+	if len(ar.manifests) == 0 {
+		return nil, fmt.Errorf("hwaf: no manifest in [%s]", fname)
+	}
+
+	names := make([]string, 0, len(ar.manifests))
+	for name := range ar.manifests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	problems := []string{}
+	roots := []string{}
+	listed := make(map[string]bool)
+	for _, name := range names {
+		m, err := bdist_load_manifest(name, ar.manifests[name])
+		if err != nil {
+			return nil, err
+		}
+		if verbose {
+			bdist_print_manifest(name, m)
+		}
+		root := bdist_manifest_root(name)
+		roots = append(roots, root)
+		problems = append(problems, bdist_verify(m, func(name string) (bdist_file_t, error) {
+			file, ok := ar.files[path.Join(root, name)]
+			if !ok {
+				return file, fmt.Errorf("no such file [%s]", name)
+			}
+			return file, nil
+		})...)
+		for _, file := range m.Files {
+			listed[path.Join(root, file.Name)] = true
+		}
+	}
+
+	files := make([]string, 0, len(ar.files))
+	for name := range ar.files {
+		files = append(files, name)
+	}
+	sort.Strings(files)
+	for _, name := range files {
+		if listed[name] {
+			continue
+		}
+		for _, root := range roots {
+			if root == "" || strings.HasPrefix(name, root+"/") {
+				problems = append(problems, fmt.Sprintf("unexpected: %s", name))
+				break
+			}
+		}
+	}
+	return problems, nil
+}
+
+// bdist_verify_dir checks the installed tree dir against the manifests it
+// holds (or the ones of its sub-directories, for an unpacked tarball).
+func bdist_verify_dir(dir string, verbose bool) ([]string, error) {
+	manifests, err := filepath.Glob(filepath.Join(dir, filepath.FromSlash(bdist_manifest_dir), "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(manifests) == 0 {
+		manifests, err = filepath.Glob(filepath.Join(dir, "*", filepath.FromSlash(bdist_manifest_dir), "*.json"))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(manifests) == 0 {
+		return nil, fmt.Errorf("hwaf: no manifest under [%s]", dir)
+	}
+
+	problems := []string{}
+	listed := make(map[string]map[string]bool)
+	for _, fname := range manifests {
+		data, err := ioutil.ReadFile(fname)
+		if err != nil {
+			return nil, err
+		}
+		m, err := bdist_load_manifest(fname, data)
+		if err != nil {
+			return nil, err
+		}
+		if verbose {
+			bdist_print_manifest(fname, m)
+		}
+		root := bdist_manifest_top(fname)
+		if root == "" {
+			root = string(filepath.Separator)
+		}
+		problems = append(problems, bdist_verify(m, func(name string) (bdist_file_t, error) {
+			return bdist_stat_file(name, filepath.Join(root, filepath.FromSlash(name)))
+		})...)
+		if listed[root] == nil {
+			listed[root] = make(map[string]bool)
+		}
+		for _, file := range m.Files {
+			listed[root][file.Name] = true
+		}
+	}
+
+	if !verbose {
+		return problems, nil
+	}
+
+	// files of the install area not listed in any manifest
+	roots := make([]string, 0, len(listed))
+	for root := range listed {
+		roots = append(roots, root)
+	}
+	sort.Strings(roots)
+	for _, root := range roots {
+		files, err := bdist_tree_files(root, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			if !listed[root][file.Name] {
+				fmt.Printf("unexpected: %s\n", filepath.Join(root, filepath.FromSlash(file.Name)))
+			}
+		}
+	}
+	return problems, nil
+}
+
+// EOF
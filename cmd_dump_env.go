@@ -20,16 +20,41 @@ func hwaf_make_cmd_dump_env() *commander.Command {
 		Long: `
 dump-env prints the environment on STDOUT.
 
+Variables are printed in alphabetical order, in one of the following formats:
+ - sh:         POSIX shells (aliases: bash, ksh, zsh)
+ - csh:        C shells (alias: tcsh)
+ - fish:       the fish shell
+ - json:       a JSON object
+ - dotenv:     a .env file (alias: env). unset variables are only
+               reported as comments.
+ - modulefile: an Environment Modules Tcl modulefile (aliases: tcl, modules)
+ - lua:        an Lmod Lua modulefile (alias: lmod)
+
+With -diff, only the variables added, modified or removed with respect to
+the invoking environment are printed. Path-like variables (PATH,
+LD_LIBRARY_PATH, ...) are then expressed as prepend/append operations on
+their current value, so the user's own settings are preserved.
+With -unsetup, the script restoring the invoking environment is printed
+instead.
+
 ex:
  $ hwaf dump-env
  $ hwaf dump-env -shell=sh
  $ hwaf dump-env -shell=csh
+ $ hwaf dump-env -shell=fish
+ $ hwaf dump-env -format=json
+ $ hwaf dump-env -format=modulefile > modulefiles/myproject/1.0
+ $ hwaf dump-env -diff > setup.sh
+ $ hwaf dump-env -unsetup > unsetup.sh
 `,
 		Flag: *flag.NewFlagSet("hwaf-dump-env", flag.ExitOnError),
 		//CustomFlags: true,
 	}
 	cmd.Flag.Bool("v", false, "enable verbose output")
 	cmd.Flag.String("shell", "", "type of shell to print the environment for (default=sh)")
+	cmd.Flag.String("format", "", "output format (sh|csh|fish|json|dotenv|modulefile|lua)")
+	cmd.Flag.Bool("diff", false, "only print the changes with respect to the current environment")
+	cmd.Flag.Bool("unsetup", false, "print the changes restoring the current environment")
 	return cmd
 }
 
@@ -44,62 +69,83 @@ func hwaf_run_cmd_dump_env(cmd *commander.Command, args []string) error {
 		return fmt.Errorf("%s: does not take any argument\n", n)
 	}
 
-	var export_var func(k string) string
 	shell := cmd.Flag.Lookup("shell").Value.Get().(string)
+	format := cmd.Flag.Lookup("format").Value.Get().(string)
+	diff := cmd.Flag.Lookup("diff").Value.Get().(bool)
+	unsetup := cmd.Flag.Lookup("unsetup").Value.Get().(bool)
 	//verbose := cmd.Flag.Lookup("v").Value.Get().(bool)
 
-	if shell == "" {
-		shell = "sh"
+	switch {
+	case shell != "" && format != "" && shell != format:
+		return fmt.Errorf("%s: -shell=%s and -format=%s are mutually exclusive", n, shell, format)
+	case format == "":
+		format = shell
+	}
+	if format == "" {
+		format = "sh"
 	}
 
-	switch shell {
-	default:
-		return fmt.Errorf("%s: shell of type [%s] is unknown", n, shell)
+	write_env, err := env_writer_for(format)
+	if err != nil {
+		return fmt.Errorf("%s: %v", n, err)
+	}
 
-	case "sh":
-		export_var = func(k string) string { return fmt.Sprintf("export %s=", k) }
+	env, err := waf_dump_env()
+	if err != nil {
+		return err
+	}
 
-	case "csh":
-		export_var = func(k string) string { return fmt.Sprintf("setenv %s ", k) }
+	if !diff && !unsetup {
+		return write_env(os.Stdout, env_set_ops(env))
 	}
 
-	if export_var == nil {
-		err = fmt.Errorf("%s: could find a suitable shell", n)
+	// waf runs with the environment modified by hwaf: only keep the
+	// modifications made by the project, and undo them with the values of
+	// the environment hwaf was invoked with.
+	cur := environ_map(g_ctx.Environ())
+	for _, k := range []string{"_", "PS1"} {
+		delete(cur, k)
+	}
+	ops := env_diff(cur, env)
+	if unsetup {
+		ops = env_undo(environ_map(g_ctx.InitialEnviron()), ops)
 	}
+	return write_env(os.Stdout, ops)
+}
 
+// waf_dump_env runs 'hwaf waf dump-env' and returns the runtime environment
+// of the current project.
+func waf_dump_env() (map[string]string, error) {
 	bin, err := exec.LookPath(os.Args[0])
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	buf := new(bytes.Buffer)
 	waf := exec.Command(
 		bin, "waf", "dump-env",
 	)
+	// the child hwaf modifies its environment as this one did
+	waf.Env = g_ctx.InitialEnviron()
 	waf.Stdin = os.Stdin
 	waf.Stdout = buf
 	waf.Stderr = os.Stderr
 
 	err = waf.Run()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	env := make(map[string]string)
 	err = json.Unmarshal(buf.Bytes(), &env)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	//fmt.Printf("%v\n", env)
-	for k, v := range env {
-		if k == "_" || k == "PS1" {
-			continue
-		}
-		fmt.Printf("%s%q\n", export_var(k), v)
+	for _, k := range []string{"_", "PS1"} {
+		delete(env, k)
 	}
-
-	return nil
+	return env, nil
 }
 
 // EOF
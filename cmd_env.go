@@ -0,0 +1,20 @@
+package main
+
+import (
+	"github.com/gonuts/commander"
+	"github.com/gonuts/flag"
+)
+
+func hwaf_make_cmd_env() *commander.Command {
+	cmd := &commander.Command{
+		UsageLine: "env [options]",
+		Short:     "inspect the runtime environment of the local project",
+		Subcommands: []*commander.Command{
+			hwaf_make_cmd_env_verify(),
+		},
+		Flag: *flag.NewFlagSet("hwaf-env", flag.ExitOnError),
+	}
+	return cmd
+}
+
+// EOF
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gonuts/commander"
+	"github.com/gonuts/flag"
+	"github.com/hwaf/hwaf/hwaflib"
+)
+
+func hwaf_make_cmd_env_verify() *commander.Command {
+	cmd := &commander.Command{
+		Run:       hwaf_run_cmd_env_verify,
+		UsageLine: "verify [options]",
+		Short:     "check the current environment against the runtime environment of the project",
+		Long: `
+verify compares the current environment with the runtime environment of the
+local project (as used by 'hwaf run' and 'hwaf shell') and reports:
+ - missing entries:     variables or path entries of the runtime environment
+                        which are not set in the current environment,
+ - stale entries:       variables set to a different value,
+ - conflicting entries: path entries pointing at another release of the
+                        local project or of one of the projects listed in
+                        local.conf (e.g. an LD_LIBRARY_PATH set up for
+                        another release).
+
+verify exits with a non-zero status if any problem was found.
+
+ex:
+ $ hwaf env verify
+ $ hwaf env verify -v
+ $ hwaf env verify -q || echo "please run: eval \$(hwaf dump-env -diff)"
+`,
+		Flag: *flag.NewFlagSet("hwaf-env-verify", flag.ExitOnError),
+	}
+	cmd.Flag.Bool("v", false, "enable verbose output")
+	cmd.Flag.Bool("q", false, "only report problems through the exit status")
+	return cmd
+}
+
+func hwaf_run_cmd_env_verify(cmd *commander.Command, args []string) error {
+	var err error
+	n := "hwaf-" + cmd.Name()
+
+	if len(args) != 0 {
+		return fmt.Errorf("%s: does not take any argument", n)
+	}
+
+	verbose := cmd.Flag.Lookup("v").Value.Get().(bool)
+	quiet := cmd.Flag.Lookup("q").Value.Get().(bool)
+
+	pinfos, err := g_ctx.ProjectInfos()
+	if err != nil {
+		return err
+	}
+
+	ops, err := hwaf_runtime_env()
+	if err != nil {
+		return err
+	}
+
+	keys, err := pinfos.GetList("HWAF_RUNTIME_ENVVARS")
+	if err != nil {
+		g_ctx.Debugf("hwaf: no runtime variables (%v). checking the whole environment\n", err)
+	}
+	runtime := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		runtime[k] = true
+	}
+
+	// the environment hwaf was invoked with, not the one of the hwaf context
+	env := environ_map(g_ctx.InitialEnviron())
+
+	problems := []string{}
+	report := func(format string, args ...interface{}) {
+		msg := fmt.Sprintf(format, args...)
+		problems = append(problems, msg)
+		if !quiet {
+			fmt.Printf("%s\n", msg)
+		}
+	}
+
+	checked := make(map[string]bool, len(ops))
+	for _, op := range ops {
+		k := op.Key
+		if op.Action == env_unset || env_session_vars[k] {
+			continue
+		}
+		if len(runtime) > 0 && !runtime[k] {
+			continue
+		}
+		checked[k] = true
+
+		cur, ok := env[k]
+		if !ok {
+			report("missing:     %s (expected %q)", k, op.Value)
+			continue
+		}
+
+		if op.Action == env_set && !env_is_pathlike(k) {
+			if cur != op.Value {
+				report("stale:       %s=%q (expected %q)", k, cur, op.Value)
+			}
+			continue
+		}
+
+		entries := make(map[string]bool)
+		for _, v := range strings.Split(cur, env_pathsep) {
+			entries[filepath.Clean(v)] = true
+		}
+		for _, v := range strings.Split(op.Value, env_pathsep) {
+			if v == "" || entries[filepath.Clean(v)] {
+				continue
+			}
+			report("missing:     %s entry %q", k, v)
+		}
+	}
+
+	releases, err := env_releases(pinfos)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(env))
+	for k := range env {
+		if env_is_pathlike(k) {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		for _, v := range strings.Split(env[k], env_pathsep) {
+			if v == "" {
+				continue
+			}
+			v = filepath.Clean(v)
+			for _, rel := range releases {
+				other := rel.other(v)
+				if other == "" {
+					continue
+				}
+				report("conflicting: %s entry %q belongs to %s-%s (expected %s-%s from [%s])",
+					k, v, rel.name, other, rel.name, rel.version, rel.root,
+				)
+			}
+		}
+	}
+
+	if verbose && !quiet {
+		fmt.Printf("%s: checked %d runtime variable(s) against %d release(s)\n",
+			n, len(checked), len(releases),
+		)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%s: %d problem(s) found in the environment", n, len(problems))
+	}
+
+	if !quiet {
+		fmt.Printf("%s: environment is consistent with the runtime environment of the project\n", n)
+	}
+	return err
+}
+
+// env_release_t describes a release of a project the environment should
+// point at.
+type env_release_t struct {
+	name    string // project name
+	version string // project version
+	root    string // install area of this release
+	prefix  string // path to the directory holding all the releases of the project
+}
+
+// other returns the version of another release of the project the path
+// belongs to, or an empty string.
+func (rel env_release_t) other(path string) string {
+	if rel.prefix == "" || path == rel.root || strings.HasPrefix(path, rel.root+"/") {
+		return ""
+	}
+	if !strings.HasPrefix(path, rel.prefix+"/") {
+		return ""
+	}
+	vers := strings.SplitN(path[len(rel.prefix)+1:], "/", 2)[0]
+	if vers == rel.version {
+		return ""
+	}
+	return vers
+}
+
+// env_releases returns the releases of the local project and of the
+// projects it depends on (local.conf's projects).
+func env_releases(pinfos *hwaflib.ProjectInfos) ([]env_release_t, error) {
+	releases := make([]env_release_t, 0)
+
+	add := func(pinfos *hwaflib.ProjectInfos, root string) {
+		name, _ := pinfos.Get("HWAF_PROJECT_NAME")
+		vers, _ := pinfos.Get("HWAF_PROJECT_VERSION")
+		if name == "" || vers == "" {
+			return
+		}
+		rel := env_release_t{
+			name:    name,
+			version: vers,
+			root:    filepath.Clean(root),
+		}
+		// releases are usually laid out as .../<name>/<version>/...
+		dirs := strings.Split(rel.root, "/")
+		for i := len(dirs) - 1; i > 0; i-- {
+			if dirs[i] == vers {
+				rel.prefix = strings.Join(dirs[:i], "/")
+				break
+			}
+		}
+		if rel.prefix == "" {
+			g_ctx.Debugf("hwaf: no version directory in [%s]\n", rel.root)
+		}
+		releases = append(releases, rel)
+	}
+
+	if root, err := project_install_area(pinfos); err == nil {
+		add(pinfos, root)
+	}
+
+	projects := cfg_string("hwaf-cfg", "projects", "")
+	for _, projdir := range strings.Split(projects, env_pathsep) {
+		if projdir == "" {
+			continue
+		}
+		projdir = filepath.Clean(projdir)
+		pinfo, err := hwaflib.NewProjectInfos(filepath.Join(projdir, "project.info"))
+		if err != nil {
+			return nil, err
+		}
+		add(pinfo, projdir)
+	}
+	return releases, nil
+}
+
+// EOF
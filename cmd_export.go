@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/gonuts/commander"
+	"github.com/gonuts/flag"
+)
+
+func hwaf_make_cmd_export() *commander.Command {
+	cmd := &commander.Command{
+		UsageLine: "export [options]",
+		Short:     "generate recipes rebuilding the project with other package managers",
+		Subcommands: []*commander.Command{
+			hwaf_make_cmd_export_easybuild(),
+			hwaf_make_cmd_export_spack(),
+		},
+		Flag: *flag.NewFlagSet("hwaf-export", flag.ExitOnError),
+	}
+	return cmd
+}
+
+// export_write writes the recipe buf into the file fname ("-" for the
+// standard output)
+func export_write(fname string, buf *bytes.Buffer, verbose bool) error {
+	if fname == "-" {
+		_, err := os.Stdout.Write(buf.Bytes())
+		return err
+	}
+	err := ioutil.WriteFile(fname, buf.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+	if verbose {
+		fmt.Printf("hwaf-export: wrote [%s]\n", fname)
+	}
+	return nil
+}
+
+// EOF
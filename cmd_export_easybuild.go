@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/gonuts/commander"
+	"github.com/gonuts/flag"
+)
+
+func hwaf_make_cmd_export_easybuild() *commander.Command {
+	cmd := &commander.Command{
+		Run:       hwaf_run_cmd_export_easybuild,
+		UsageLine: "easybuild [options]",
+		Short:     "generate an EasyBuild easyconfig for the local project",
+		Long: `
+easybuild generates an EasyBuild easyconfig (<name>-<version>.eb) rebuilding
+the local project from its source distribution with hwaf.
+
+The easyconfig is derived from:
+ - project.info:          name and version of the project,
+ - local.conf's projects: the upstream projects (dependencies, given to
+                          'hwaf setup -p'),
+ - hscript.yml files:     the dependencies of the packages which are not
+                          packages of the project (dependencies, given to
+                          'hwaf configure --with-<dep>'),
+ - -source:               the location of the source distribution (default:
+                          <url>/<name>-<version>.tar.gz). Its SHA-256 is
+                          computed for local files, or given with -sha256.
+
+EasyBuild needs the version of every dependency: they are given (or the
+dependencies renamed, or dropped with an empty value) in the
+[hwaf-export-easybuild] section of the configuration. Dependencies without
+a version are left commented out.
+ [hwaf-export-easybuild]
+ External/AtlasROOT = ROOT 5.34.09
+ AtlasPolicy =
+
+ex:
+ $ hwaf export easybuild
+ $ hwaf export easybuild -o=- -source=./mana-core-20130101.tar.gz
+ $ hwaf export easybuild -url=http://cern.ch/mana-fwk -sha256=0123...cdef
+`,
+		Flag: *flag.NewFlagSet("hwaf-export-easybuild", flag.ExitOnError),
+	}
+	cmd.Flag.Bool("v", false, "enable verbose output")
+	cmd.Flag.String("o", "", "output file (default: <name>-<version>.eb, - for the standard output)")
+	cmd.Flag.String("url", "", "URL home page of the project")
+	cmd.Flag.String("source", "", "URL of (or path to) the source distribution")
+	cmd.Flag.String("sha256", "", "SHA-256 of the source distribution")
+	return cmd
+}
+
+func hwaf_run_cmd_export_easybuild(cmd *commander.Command, args []string) error {
+	var err error
+	n := "hwaf-" + cmd.Name()
+
+	switch len(args) {
+	case 0:
+	default:
+		return fmt.Errorf("%s: too many arguments (%d)", n, len(args))
+	}
+
+	verbose := cmd.Flag.Lookup("v").Value.Get().(bool)
+	fname := cmd.Flag.Lookup("o").Value.Get().(string)
+	url := cmd.Flag.Lookup("url").Value.Get().(string)
+	source := cmd.Flag.Lookup("source").Value.Get().(string)
+	sha256 := cmd.Flag.Lookup("sha256").Value.Get().(string)
+
+	proj, err := export_new_project("easybuild", url, source, sha256)
+	if err != nil {
+		return err
+	}
+	if fname == "" {
+		fname = proj.Name + "-" + proj.Version + ".eb"
+	}
+
+	projects := []string{}
+	for _, p := range proj.Projects {
+		projects = append(projects, "$"+easybuild_root_var(p.Name))
+	}
+	setup := "hwaf setup ."
+	if len(projects) > 0 {
+		setup = "hwaf setup -p=" + strings.Join(projects, ":") + " ."
+	}
+	configopts := []string{}
+	for _, dep := range proj.Deps {
+		if dep.Version == "" {
+			g_ctx.Warnf("no version for the dependency [%s] (see [hwaf-export-easybuild])\n", dep.Name)
+			continue
+		}
+		configopts = append(configopts, "--with-"+dep.Option+"=$"+easybuild_root_var(dep.Name))
+	}
+
+	tmpl, err := template.New("easybuild").Funcs(template.FuncMap{
+		"quote": py_quote,
+		"join": func(pkgs []string) string {
+			return "needed by " + strings.Join(pkgs, ", ")
+		},
+	}).Parse(`# easyconfig for {{.Name}}-{{.Version}}, generated by hwaf-{{.HwafVersion}}
+easyblock = 'ConfigureMake'
+
+name = {{quote .Name}}
+version = {{quote .Version}}
+
+homepage = {{quote .Url}}
+description = """hwaf project {{.Name}}"""
+
+toolchain = SYSTEM
+
+source_urls = [{{quote .SourceDir}}]
+sources = [{{quote .SourceFile}}]
+{{if .Sha256}}checksums = [{{quote .Sha256}}]
+{{else}}# FIXME: add the sha256 of the source distribution
+# checksums = ['']
+{{end}}
+builddependencies = [('hwaf', {{quote .HwafVersion}})]
+
+dependencies = [
+{{range .Projects}}    ({{quote .Name}}, {{quote .Version}}),  # upstream project
+{{end}}{{range .Deps}}{{if .Version}}    ({{quote .Name}}, {{quote .Version}}),  # {{join .Pkgs}}
+{{else}}    # FIXME: ({{quote .Name}}, ''),  # {{join .Pkgs}}
+{{end}}{{end}}]
+
+configure_cmd = {{quote .Setup}}
+prefix_opt = '--prefix='
+configopts = {{quote .ConfigOpts}}
+build_cmd = 'hwaf build'
+install_cmd = 'hwaf install'
+
+sanity_check_paths = {
+    'files': ['project.info'],
+    'dirs': [],
+}
+
+moduleclass = 'devel'
+`)
+	if err != nil {
+		return err
+	}
+
+	idx := strings.LastIndex(proj.Source, "/")
+	buf := new(bytes.Buffer)
+	err = tmpl.Execute(buf, struct {
+		*export_project_t
+		SourceDir  string
+		SourceFile string
+		Setup      string
+		ConfigOpts string
+	}{
+		export_project_t: proj,
+		SourceDir:        proj.Source[:idx],
+		SourceFile:       proj.Source[idx+1:],
+		Setup:            "hwaf init . && " + setup + " && hwaf configure",
+		ConfigOpts:       strings.Join(configopts, " "),
+	})
+	if err != nil {
+		return err
+	}
+	return export_write(fname, buf, verbose)
+}
+
+// EOF
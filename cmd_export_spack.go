@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/gonuts/commander"
+	"github.com/gonuts/flag"
+)
+
+func hwaf_make_cmd_export_spack() *commander.Command {
+	cmd := &commander.Command{
+		Run:       hwaf_run_cmd_export_spack,
+		UsageLine: "spack [options]",
+		Short:     "generate a spack package.py for the local project",
+		Long: `
+spack generates a spack recipe (package.py) rebuilding the local project from
+its source distribution with hwaf.
+
+The recipe is derived from:
+ - project.info:          name and version of the project,
+ - local.conf's projects: the upstream projects (spack dependencies, given
+                          to 'hwaf setup -p'),
+ - hscript.yml files:     the dependencies of the packages which are not
+                          packages of the project (spack dependencies, given
+                          to 'hwaf configure --with-<dep>'),
+ - -source:               the location of the source distribution (default:
+                          <url>/<name>-<version>.tar.gz). Its SHA-256 is
+                          computed for local files, or given with -sha256.
+
+Dependencies are renamed (or dropped, with an empty value) in the
+[hwaf-export-spack] section of the configuration:
+ [hwaf-export-spack]
+ External/AtlasROOT = root@5.34:
+ AtlasPolicy =
+
+ex:
+ $ hwaf export spack
+ $ hwaf export spack -o=- -source=./mana-core-20130101.tar.gz
+ $ hwaf export spack -url=http://cern.ch/mana-fwk -sha256=0123...cdef
+`,
+		Flag: *flag.NewFlagSet("hwaf-export-spack", flag.ExitOnError),
+	}
+	cmd.Flag.Bool("v", false, "enable verbose output")
+	cmd.Flag.String("o", "package.py", "output file (- for the standard output)")
+	cmd.Flag.String("url", "", "URL home page of the project")
+	cmd.Flag.String("source", "", "URL of (or path to) the source distribution")
+	cmd.Flag.String("sha256", "", "SHA-256 of the source distribution")
+	return cmd
+}
+
+func hwaf_run_cmd_export_spack(cmd *commander.Command, args []string) error {
+	var err error
+	n := "hwaf-" + cmd.Name()
+
+	switch len(args) {
+	case 0:
+	default:
+		return fmt.Errorf("%s: too many arguments (%d)", n, len(args))
+	}
+
+	verbose := cmd.Flag.Lookup("v").Value.Get().(bool)
+	fname := cmd.Flag.Lookup("o").Value.Get().(string)
+	url := cmd.Flag.Lookup("url").Value.Get().(string)
+	source := cmd.Flag.Lookup("source").Value.Get().(string)
+	sha256 := cmd.Flag.Lookup("sha256").Value.Get().(string)
+
+	proj, err := export_new_project("spack", url, source, sha256)
+	if err != nil {
+		return err
+	}
+
+	tmpl, err := template.New("spack").Funcs(template.FuncMap{
+		"class": spack_class_name,
+		"quote": py_quote,
+		"dep":   spack_depends_on,
+		"join": func(pkgs []string) string {
+			return "needed by " + strings.Join(pkgs, ", ")
+		},
+	}).Parse(`# spack recipe for {{.Name}}-{{.Version}}, generated by hwaf-{{.HwafVersion}}
+from spack import *
+
+
+class {{class .Name}}(Package):
+    """hwaf project {{.Name}}"""
+
+    homepage = {{quote .Url}}
+    url = {{quote .Source}}
+
+{{if .Sha256}}    version({{quote .Version}}, sha256={{quote .Sha256}})
+{{else}}    # FIXME: add the sha256 of the source distribution
+    version({{quote .Version}})
+{{end}}
+    depends_on('hwaf', type='build')
+{{range .Projects}}    {{dep . true}}  # upstream project
+{{end}}{{range .Deps}}    {{dep . false}}  # {{join .Pkgs}}
+{{end}}
+    def install(self, spec, prefix):
+        hwaf = which('hwaf')
+        hwaf('init', '.')
+{{if .Projects}}        projects = [{{range $i, $p := .Projects}}{{if $i}}, {{end}}spec[{{quote $p.Name}}].prefix{{end}}]
+        hwaf('setup', '-p=' + ':'.join(str(p) for p in projects), '.')
+{{else}}        hwaf('setup', '.')
+{{end}}        hwaf('configure', '--prefix=' + prefix{{range .Deps}},
+             '--with-{{.Option}}=' + spec[{{quote .Name}}].prefix{{end}})
+        hwaf('build')
+        hwaf('install')
+`)
+	if err != nil {
+		return err
+	}
+
+	buf := new(bytes.Buffer)
+	err = tmpl.Execute(buf, proj)
+	if err != nil {
+		return err
+	}
+	return export_write(fname, buf, verbose)
+}
+
+// spack_depends_on returns the depends_on directive of the dependency dep
+// (of an upstream project, if project is true)
+func spack_depends_on(dep export_dep_t, project bool) string {
+	spec := dep.Name
+	if dep.Version != "" {
+		spec += "@" + dep.Version
+		if project {
+			// the upstream project or a later version
+			spec += ":"
+		}
+	}
+	types := []string{"'build'"}
+	if dep.Link {
+		types = append(types, "'link'")
+	}
+	if dep.Runtime {
+		types = append(types, "'run'")
+	}
+	return fmt.Sprintf("depends_on(%s, type=(%s))", py_quote(spec), strings.Join(types, ", "))
+}
+
+// EOF
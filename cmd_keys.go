@@ -0,0 +1,24 @@
+package main
+
+import (
+	"github.com/gonuts/commander"
+	"github.com/gonuts/flag"
+)
+
+func hwaf_make_cmd_keys() *commander.Command {
+	cmd := &commander.Command{
+		UsageLine: "keys [options]",
+		Short:     "generate and manage the keys signing binary distributions",
+		Subcommands: []*commander.Command{
+			hwaf_make_cmd_keys_export(),
+			hwaf_make_cmd_keys_gen(),
+			hwaf_make_cmd_keys_ls(),
+			hwaf_make_cmd_keys_trust(),
+			hwaf_make_cmd_keys_untrust(),
+		},
+		Flag: *flag.NewFlagSet("hwaf-keys", flag.ExitOnError),
+	}
+	return cmd
+}
+
+// EOF
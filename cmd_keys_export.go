@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gonuts/commander"
+	"github.com/gonuts/flag"
+)
+
+func hwaf_make_cmd_keys_export() *commander.Command {
+	cmd := &commander.Command{
+		Run:       hwaf_run_cmd_keys_export,
+		UsageLine: "export [options] <key-name>",
+		Short:     "print the public key of a key",
+		Long: `
+export prints the public key of a key of the keys directory (or of a key
+file), to be trusted by the sites installing the binary distributions it
+signs.
+
+ex:
+ $ hwaf keys export my-site > my-site.pub
+ $ hwaf keys trust my-site.pub
+`,
+		Flag: *flag.NewFlagSet("hwaf-keys-export", flag.ExitOnError),
+	}
+	return cmd
+}
+
+func hwaf_run_cmd_keys_export(cmd *commander.Command, args []string) error {
+	var err error
+	n := "hwaf-" + cmd.Name()
+
+	switch len(args) {
+	case 1:
+	default:
+		return fmt.Errorf("%s: you need to give a key name", n)
+	}
+
+	k, err := key_load(args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n", k.PublicString())
+	return err
+}
+
+// EOF
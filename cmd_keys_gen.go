@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gonuts/commander"
+	"github.com/gonuts/flag"
+)
+
+func hwaf_make_cmd_keys_gen() *commander.Command {
+	cmd := &commander.Command{
+		Run:       hwaf_run_cmd_keys_gen,
+		UsageLine: "gen [options] <key-name>",
+		Short:     "generate a new key to sign binary distributions",
+		Long: `
+gen generates a new ed25519 key pair under the keys directory
+(${HOME}/.config/hwaf/keys by default, or [hwaf-keys] dir):
+ - <key-name>.key holds the private key, to sign binary distributions
+   with 'hwaf bdist -sign=<key-name>',
+ - <key-name>.pub holds the public key, to distribute to the sites which
+   install the binary distributions ('hwaf keys trust').
+
+With -trust, the new key is also added to the trusted keys.
+
+ex:
+ $ hwaf keys gen my-site
+ $ hwaf keys gen -trust my-site
+`,
+		Flag: *flag.NewFlagSet("hwaf-keys-gen", flag.ExitOnError),
+	}
+	cmd.Flag.Bool("v", false, "enable verbose output")
+	cmd.Flag.Bool("trust", false, "add the new key to the trusted keys")
+	return cmd
+}
+
+func hwaf_run_cmd_keys_gen(cmd *commander.Command, args []string) error {
+	var err error
+	n := "hwaf-" + cmd.Name()
+
+	name := ""
+	switch len(args) {
+	case 1:
+		name = args[0]
+	default:
+		return fmt.Errorf("%s: you need to give a key name", n)
+	}
+
+	verbose := cmd.Flag.Lookup("v").Value.Get().(bool)
+	trust := cmd.Flag.Lookup("trust").Value.Get().(bool)
+
+	k, err := key_generate(name)
+	if err != nil {
+		return err
+	}
+	err = key_save(k)
+	if err != nil {
+		return err
+	}
+	if verbose {
+		fmt.Printf("%s: generated key [%s] (%s) under [%s]\n", n, k.Name, k.Id, keys_dir())
+	}
+
+	if trust {
+		_, err = keys_trust([]key_t{k})
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("%s\n", k.PublicString())
+	return err
+}
+
+// EOF
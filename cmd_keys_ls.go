@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gonuts/commander"
+	"github.com/gonuts/flag"
+)
+
+func hwaf_make_cmd_keys_ls() *commander.Command {
+	cmd := &commander.Command{
+		Run:       hwaf_run_cmd_keys_ls,
+		UsageLine: "ls [options]",
+		Short:     "list the signing keys and the trusted keys",
+		Long: `
+ls lists the private keys of the keys directory, which can sign binary
+distributions, and the trusted public keys, which binary distributions
+must be signed with to be installed.
+
+The trusted keys are read from ${HOME}/.config/hwaf/trusted-keys and
+/etc/hwaf/trusted-keys, or from the files listed by the [hwaf-keys]
+trusted option.
+
+ex:
+ $ hwaf keys ls
+ $ hwaf keys ls -v
+`,
+		Flag: *flag.NewFlagSet("hwaf-keys-ls", flag.ExitOnError),
+	}
+	cmd.Flag.Bool("v", false, "enable verbose output")
+	return cmd
+}
+
+func hwaf_run_cmd_keys_ls(cmd *commander.Command, args []string) error {
+	var err error
+	n := "hwaf-" + cmd.Name()
+
+	if len(args) != 0 {
+		return fmt.Errorf("%s: does not take any argument", n)
+	}
+
+	verbose := cmd.Flag.Lookup("v").Value.Get().(bool)
+
+	keys, err := keys_private()
+	if err != nil {
+		return err
+	}
+	if verbose {
+		fmt.Printf("signing keys (%s):\n", keys_dir())
+	} else {
+		fmt.Printf("signing keys:\n")
+	}
+	for _, k := range keys {
+		fmt.Printf("  %s  %s\n", k.Id, k.Name)
+	}
+
+	fmt.Printf("trusted keys:\n")
+	for _, fname := range trusted_keys_files() {
+		keys, err := keys_read(fname)
+		if err != nil {
+			return err
+		}
+		if verbose {
+			fmt.Printf(" [%s]\n", fname)
+		}
+		for _, k := range keys {
+			fmt.Printf("  %s  %s\n", k.Id, k.Name)
+		}
+	}
+	return err
+}
+
+// EOF
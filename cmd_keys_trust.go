@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gonuts/commander"
+	"github.com/gonuts/flag"
+)
+
+func hwaf_make_cmd_keys_trust() *commander.Command {
+	cmd := &commander.Command{
+		Run:       hwaf_run_cmd_keys_trust,
+		UsageLine: "trust [options] <pubkey-file>|<pubkey>",
+		Short:     "add public keys to the trusted keys",
+		Long: `
+trust adds the public keys of a file (as written by 'hwaf keys gen' or
+'hwaf keys export') or the given public key to the trusted keys of the
+user (${HOME}/.config/hwaf/trusted-keys by default).
+Binary distributions signed by a trusted key can be installed.
+
+ex:
+ $ hwaf keys trust my-site.pub
+ $ hwaf keys trust hwaf-ed25519-pub 0123456789abcdef my-site AbCd...=
+`,
+		Flag: *flag.NewFlagSet("hwaf-keys-trust", flag.ExitOnError),
+	}
+	cmd.Flag.Bool("v", false, "enable verbose output")
+	return cmd
+}
+
+func hwaf_run_cmd_keys_trust(cmd *commander.Command, args []string) error {
+	var err error
+	n := "hwaf-" + cmd.Name()
+
+	verbose := cmd.Flag.Lookup("v").Value.Get().(bool)
+
+	var keys []key_t
+	switch len(args) {
+	case 0:
+		return fmt.Errorf("%s: you need to give a public key (or a file holding public keys)", n)
+	case 1:
+		keys, err = keys_read(args[0])
+		if err == nil && len(keys) == 0 {
+			err = fmt.Errorf("%s: no public key in [%s]", n, args[0])
+		}
+	default:
+		var k key_t
+		k, err = key_parse(strings.Join(args, " "))
+		keys = []key_t{k}
+	}
+	if err != nil {
+		return err
+	}
+
+	added, err := keys_trust(keys)
+	if err != nil {
+		return err
+	}
+	for _, k := range added {
+		fmt.Printf("%s: trusting key [%s] (%s)\n", n, k.Name, k.Id)
+	}
+	if verbose && len(added) < len(keys) {
+		fmt.Printf("%s: %d key(s) already trusted\n", n, len(keys)-len(added))
+	}
+	return err
+}
+
+// EOF
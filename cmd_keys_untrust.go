@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gonuts/commander"
+	"github.com/gonuts/flag"
+)
+
+func hwaf_make_cmd_keys_untrust() *commander.Command {
+	cmd := &commander.Command{
+		Run:       hwaf_run_cmd_keys_untrust,
+		UsageLine: "untrust [options] <key-name>|<key-id>",
+		Short:     "remove a key from the trusted keys",
+		Long: `
+untrust removes a key (by name or id) from the trusted keys of the user.
+Keys trusted site-wide (/etc/hwaf/trusted-keys) have to be removed by hand.
+
+ex:
+ $ hwaf keys untrust my-site
+ $ hwaf keys untrust 0123456789abcdef
+`,
+		Flag: *flag.NewFlagSet("hwaf-keys-untrust", flag.ExitOnError),
+	}
+	return cmd
+}
+
+func hwaf_run_cmd_keys_untrust(cmd *commander.Command, args []string) error {
+	var err error
+	n := "hwaf-" + cmd.Name()
+
+	switch len(args) {
+	case 1:
+	default:
+		return fmt.Errorf("%s: you need to give a key name or id", n)
+	}
+
+	removed, err := keys_untrust(args[0])
+	if err != nil {
+		return err
+	}
+	if len(removed) == 0 {
+		return fmt.Errorf("%s: no such trusted key [%s] in [%s]", n, args[0], trusted_keys_files()[0])
+	}
+	for _, k := range removed {
+		fmt.Printf("%s: removed key [%s] (%s)\n", n, k.Name, k.Id)
+	}
+	return err
+}
+
+// EOF
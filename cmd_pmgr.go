@@ -11,6 +11,12 @@ func hwaf_make_cmd_pmgr() *commander.Command {
 		Short: "query, download and install projects",
 		Subcommands: []*commander.Command{
 			hwaf_make_cmd_pmgr_get(),
+			hwaf_make_cmd_pmgr_index(),
+			hwaf_make_cmd_pmgr_install(),
+			hwaf_make_cmd_pmgr_list(),
+			hwaf_make_cmd_pmgr_remove(),
+			hwaf_make_cmd_pmgr_search(),
+			hwaf_make_cmd_pmgr_verify(),
 		},
 		Flag: *flag.NewFlagSet("hwaf-pmgr", flag.ExitOnError),
 	}
@@ -2,12 +2,12 @@ package main
 
 import (
 	"fmt"
-	// "io"
-	// "io/ioutil"
-	// "os"
-	// "os/exec"
-	// "path"
-	// "path/filepath"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
 	"strings"
 
 	"github.com/gonuts/commander"
@@ -17,19 +17,31 @@ import (
 func hwaf_make_cmd_pmgr_get() *commander.Command {
 	cmd := &commander.Command{
 		Run:       hwaf_run_cmd_pmgr_get,
-		UsageLine: "get [options] <pkguri>",
+		UsageLine: "get [options] <bdist-uri>",
 		Short:     "download and install a package/project",
 		Long: `
-get downloads and installs a package or project from a URI.
+get downloads and installs a binary distribution (a tarball created by
+'hwaf bdist') from a URI or a local file.
+
+The binary distribution must be signed ('hwaf bdist -sign') by one of the
+trusted keys (see 'hwaf keys'): its detached signature is downloaded from
+<bdist-uri>.sig. Unsigned or mis-signed distributions are refused, unless
+-insecure is given.
+
+Once unpacked, the files referring to the installation prefix the
+distribution was built for are relocated under the -o directory (see
+'hwaf bdist'). Files which could not be relocated are reported.
 
 ex:
- $ hwaf pmgr get cern.ch/mana-fwk/mana-latest
- $ hwaf pmgr get -o /opt cern.ch/mana-fwk/mana-latest
+ $ hwaf pmgr get http://cern.ch/mana-fwk/mana-20130101-x86_64-linux-gcc-opt.tar.gz
+ $ hwaf pmgr get -o /opt ./mana-20130101-x86_64-linux-gcc-opt.tar.gz
+ $ hwaf pmgr get -insecure ./mana-20130101-x86_64-linux-gcc-opt.tar.gz
 `,
 		Flag: *flag.NewFlagSet("hwaf-pmgr-get", flag.ExitOnError),
 	}
 	cmd.Flag.Bool("v", false, "enable verbose output")
-	cmd.Flag.String("o", "", "directory where to install the package")
+	cmd.Flag.String("o", ".", "directory where to install the package")
+	cmd.Flag.Bool("insecure", false, "install unsigned or mis-signed binary distributions")
 	return cmd
 }
 
@@ -37,6 +49,8 @@ func hwaf_run_cmd_pmgr_get(cmd *commander.Command, args []string) error {
 	var err error
 	n := "hwaf-pmgr-" + cmd.Name()
 	verbose := cmd.Flag.Lookup("v").Value.Get().(bool)
+	destdir := cmd.Flag.Lookup("o").Value.Get().(string)
+	insecure := cmd.Flag.Lookup("insecure").Value.Get().(bool)
 
 	pkguri := ""
 	switch len(args) {
@@ -46,20 +60,81 @@ func hwaf_run_cmd_pmgr_get(cmd *commander.Command, args []string) error {
 		return fmt.Errorf("%s: you need to give a package URI to install", n)
 	}
 
-	pkguri = strings.Replace(pkguri, "http://", "", 1)
-	pkguri = strings.Replace(pkguri, "https://", "", 1)
-
 	if verbose {
 		fmt.Printf("%s: get [%s]...\n", n, pkguri)
 	}
 
-	//manifest_url := path.Join(pkguri, "MANIFEST")
+	fname := os.ExpandEnv(pkguri)
+	if strings.HasPrefix(pkguri, "http://") || strings.HasPrefix(pkguri, "https://") {
+		tmpdir, err := ioutil.TempDir("", "hwaf-pmgr-get-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tmpdir)
+		fname, err = pmgr_download(pkguri, tmpdir)
+		if err != nil {
+			return err
+		}
+	}
+
+	destdir, err = filepath.Abs(os.ExpandEnv(destdir))
+	if err != nil {
+		return err
+	}
+	tops, err := bdist_install(fname, destdir, insecure)
+	if err != nil {
+		return err
+	}
 
 	if verbose {
+		for _, top := range tops {
+			fmt.Printf("%s: installed [%s]\n", n, top)
+		}
 		fmt.Printf("%s: get [%s]... [ok]\n", n, pkguri)
 	}
 
 	return err
 }
 
+// pmgr_download downloads the binary distribution at url and its detached
+// signature (if any) into the directory dir, and returns the name of the
+// local file.
+func pmgr_download(url, dir string) (string, error) {
+	fname := filepath.Join(dir, path.Base(url))
+	for _, file := range []struct {
+		url      string
+		fname    string
+		optional bool
+	}{
+		{url, fname, false},
+		{sig_fname(url), sig_fname(fname), true},
+	} {
+		resp, err := http.Get(file.url)
+		if err != nil {
+			return "", err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			if file.optional && resp.StatusCode == http.StatusNotFound {
+				continue
+			}
+			return "", fmt.Errorf("hwaf: could not download [%s] (reason: %q)", file.url, resp.Status)
+		}
+		f, err := os.Create(file.fname)
+		if err != nil {
+			resp.Body.Close()
+			return "", err
+		}
+		_, err = io.Copy(f, resp.Body)
+		resp.Body.Close()
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return fname, nil
+}
+
 // EOF
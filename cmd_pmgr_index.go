@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/gonuts/commander"
+	"github.com/gonuts/flag"
+)
+
+func hwaf_make_cmd_pmgr_index() *commander.Command {
+	cmd := &commander.Command{
+		Run:       hwaf_run_cmd_pmgr_index,
+		UsageLine: "index [options] <repository-dir>",
+		Short:     "create the index of a binary repository",
+		Long: `
+index creates the index (index.json) of a binary repository: a directory
+holding binary distributions created by 'hwaf bdist' (and their detached
+signatures, if any).
+
+The index lists the name, version, variant and upstream projects of every
+binary distribution (read from the manifest it embeds) with its checksum.
+The directory may then be used as a repository by the other 'hwaf pmgr'
+commands, as is or served over HTTP.
+
+ex:
+ $ hwaf bdist -sign=site && cp mana-*.tar.gz* /srv/hwaf-repo
+ $ hwaf pmgr index /srv/hwaf-repo
+ $ hwaf pmgr index -v /srv/hwaf-repo
+`,
+		Flag: *flag.NewFlagSet("hwaf-pmgr-index", flag.ExitOnError),
+	}
+	cmd.Flag.Bool("v", false, "enable verbose output")
+	return cmd
+}
+
+func hwaf_run_cmd_pmgr_index(cmd *commander.Command, args []string) error {
+	var err error
+	n := "hwaf-" + cmd.Name()
+
+	dir := ""
+	switch len(args) {
+	case 1:
+		dir = args[0]
+	default:
+		return fmt.Errorf("%s: you need to give the directory of the repository", n)
+	}
+
+	verbose := cmd.Flag.Lookup("v").Value.Get().(bool)
+
+	dir, err = filepath.Abs(os.ExpandEnv(dir))
+	if err != nil {
+		return err
+	}
+	if !path_exists(dir) {
+		return fmt.Errorf("%s: no such directory [%s]", n, dir)
+	}
+
+	idx, err := pmgr_index_dir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range idx.Packages {
+		if !e.Signed {
+			g_ctx.Warnf("[%s] is not signed\n", e.File)
+		}
+		if verbose {
+			fmt.Printf("%s: %s [%s]\n", n, e, e.File)
+		}
+	}
+
+	data, err := json.MarshalIndent(idx, "", "    ")
+	if err != nil {
+		return err
+	}
+	fname := filepath.Join(dir, pmgr_index_name)
+	err = ioutil.WriteFile(fname, data, 0644)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: indexed %d binary distribution(s) in [%s]\n", n, len(idx.Packages), fname)
+	return err
+}
+
+// EOF
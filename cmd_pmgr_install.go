@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/gonuts/commander"
+	"github.com/gonuts/flag"
+)
+
+func hwaf_make_cmd_pmgr_install() *commander.Command {
+	cmd := &commander.Command{
+		Run:       hwaf_run_cmd_pmgr_install,
+		UsageLine: "install [options] <project>[-<version>] [<project>[-<version>] ...]",
+		Short:     "install projects from the binary repositories",
+		Long: `
+install downloads and installs binary distributions from the repositories
+(see 'hwaf pmgr search'), under the -o directory (default: the sitedir,
+${HWAF_SITEDIR}), and records them in the database of installed projects.
+Each variant has its own directory: a project is installed into
+<dir>/<variant>/<project>-<version>.
+
+Without a version, the newest version for the -variant (default: the
+current HWAF_VARIANT) is installed. The upstream projects of a binary
+distribution are installed first, unless -nodeps is given.
+
+As with 'hwaf pmgr get', binary distributions must be signed by one of the
+trusted keys (unless -insecure is given) and they are relocated under the
+installation directory.
+
+ex:
+ $ hwaf pmgr install mana-core
+ $ hwaf pmgr install mana-core-20130101
+ $ hwaf pmgr install -variant=x86_64-linux-gcc-opt -o /opt/mana mana-core
+`,
+		Flag: *flag.NewFlagSet("hwaf-pmgr-install", flag.ExitOnError),
+	}
+	cmd.Flag.Bool("v", false, "enable verbose output")
+	cmd.Flag.String("repo", "", "binary repository (directory or URL)")
+	cmd.Flag.String("variant", "", "HWAF_VARIANT of the binary distributions to install (default: current variant)")
+	cmd.Flag.String("o", "", "directory where to install the projects (default: sitedir)")
+	cmd.Flag.Bool("nodeps", false, "do not install the upstream projects")
+	cmd.Flag.Bool("insecure", false, "install unsigned or mis-signed binary distributions")
+	return cmd
+}
+
+func hwaf_run_cmd_pmgr_install(cmd *commander.Command, args []string) error {
+	var err error
+	n := "hwaf-" + cmd.Name()
+
+	if len(args) == 0 {
+		return fmt.Errorf("%s: you need to give the projects to install", n)
+	}
+
+	verbose := cmd.Flag.Lookup("v").Value.Get().(bool)
+	repo := cmd.Flag.Lookup("repo").Value.Get().(string)
+	variant := cmd.Flag.Lookup("variant").Value.Get().(string)
+	destdir := cmd.Flag.Lookup("o").Value.Get().(string)
+	nodeps := cmd.Flag.Lookup("nodeps").Value.Get().(bool)
+	insecure := cmd.Flag.Lookup("insecure").Value.Get().(bool)
+
+	if variant == "" {
+		variant = g_ctx.Variant()
+	}
+	if destdir == "" {
+		destdir = g_ctx.Sitedir()
+	}
+	destdir, err = filepath.Abs(os.ExpandEnv(destdir))
+	if err != nil {
+		return err
+	}
+
+	repos, err := pmgr_repos(repo)
+	if err != nil {
+		return err
+	}
+	entries, err := pmgr_available(repos)
+	if err != nil {
+		return err
+	}
+	db, err := pmgr_load_db()
+	if err != nil {
+		return err
+	}
+
+	todo := []pmgr_entry_t{}
+	for _, name := range args {
+		e, ok := pmgr_find(entries, name, variant)
+		if !ok {
+			return fmt.Errorf("%s: no binary distribution [%s] for [%s]", n, name, variant)
+		}
+		todo = append(todo, e)
+	}
+
+	pending := make(map[string]bool)
+	for _, e := range todo {
+		err = pmgr_install(db, entries, e, destdir, insecure, nodeps, verbose, pending)
+		if err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// EOF
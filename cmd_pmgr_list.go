@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gonuts/commander"
+	"github.com/gonuts/flag"
+)
+
+func hwaf_make_cmd_pmgr_list() *commander.Command {
+	cmd := &commander.Command{
+		Run:       hwaf_run_cmd_pmgr_list,
+		UsageLine: "list [options]",
+		Short:     "list the installed and available projects",
+		Long: `
+list lists the projects installed by 'hwaf pmgr install' (recorded in
+${HWAF_SITEDIR}/.hwaf/pmgr.json) and the binary distributions of the
+repositories which are not installed.
+
+ex:
+ $ hwaf pmgr list
+ $ hwaf pmgr list -installed
+ $ hwaf pmgr list -v -repo=/srv/hwaf-repo
+`,
+		Flag: *flag.NewFlagSet("hwaf-pmgr-list", flag.ExitOnError),
+	}
+	cmd.Flag.Bool("v", false, "enable verbose output")
+	cmd.Flag.String("repo", "", "binary repository (directory or URL)")
+	cmd.Flag.String("variant", "", "only list the projects for this HWAF_VARIANT")
+	cmd.Flag.Bool("installed", false, "only list the installed projects")
+	return cmd
+}
+
+func hwaf_run_cmd_pmgr_list(cmd *commander.Command, args []string) error {
+	var err error
+	n := "hwaf-" + cmd.Name()
+
+	if len(args) != 0 {
+		return fmt.Errorf("%s: does not take any argument", n)
+	}
+
+	verbose := cmd.Flag.Lookup("v").Value.Get().(bool)
+	repo := cmd.Flag.Lookup("repo").Value.Get().(string)
+	variant := cmd.Flag.Lookup("variant").Value.Get().(string)
+	installed := cmd.Flag.Lookup("installed").Value.Get().(bool)
+
+	db, err := pmgr_load_db()
+	if err != nil {
+		return err
+	}
+
+	if verbose {
+		fmt.Printf("installed (%s):\n", db.fname)
+	} else {
+		fmt.Printf("installed:\n")
+	}
+	for _, p := range db.Projects {
+		if variant != "" && p.Variant != variant {
+			continue
+		}
+		fmt.Printf("  %s\n", p)
+		if verbose {
+			fmt.Printf("    dir:  %s\n", p.Dir)
+			fmt.Printf("    from: %s/%s (%s)\n", p.Repo, p.File, p.Date)
+		}
+	}
+
+	if installed {
+		return err
+	}
+
+	repos, err := pmgr_repos(repo)
+	if err != nil {
+		return err
+	}
+	entries, err := pmgr_available(repos)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("available:\n")
+	for _, e := range entries {
+		if variant != "" && e.Variant != variant {
+			continue
+		}
+		if db.get(e.Name, e.Version, e.Variant) >= 0 {
+			continue
+		}
+		fmt.Printf("  %s\n", e)
+		if verbose {
+			fmt.Printf("    from: %s/%s\n", e.Repo, e.File)
+		}
+	}
+	return err
+}
+
+// EOF
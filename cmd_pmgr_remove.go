@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gonuts/commander"
+	"github.com/gonuts/flag"
+)
+
+func hwaf_make_cmd_pmgr_remove() *commander.Command {
+	cmd := &commander.Command{
+		Run:       hwaf_run_cmd_pmgr_remove,
+		UsageLine: "remove [options] <project>[-<version>] [<project>[-<version>] ...]",
+		Short:     "remove installed projects",
+		Long: `
+remove removes projects installed by 'hwaf pmgr install': the files listed
+in their manifest, then the directories left empty.
+
+A project needed by other installed projects is not removed, unless -f is
+given. If several versions (or variants) of a project are installed, the
+version (or -variant) must be given.
+
+ex:
+ $ hwaf pmgr remove mana-core
+ $ hwaf pmgr remove mana-core-20130101
+ $ hwaf pmgr remove -variant=x86_64-linux-gcc-opt mana-core
+`,
+		Flag: *flag.NewFlagSet("hwaf-pmgr-remove", flag.ExitOnError),
+	}
+	cmd.Flag.Bool("v", false, "enable verbose output")
+	cmd.Flag.String("variant", "", "HWAF_VARIANT of the projects to remove")
+	cmd.Flag.Bool("f", false, "remove projects even if other projects need them")
+	return cmd
+}
+
+func hwaf_run_cmd_pmgr_remove(cmd *commander.Command, args []string) error {
+	var err error
+	n := "hwaf-" + cmd.Name()
+
+	if len(args) == 0 {
+		return fmt.Errorf("%s: you need to give the projects to remove", n)
+	}
+
+	verbose := cmd.Flag.Lookup("v").Value.Get().(bool)
+	variant := cmd.Flag.Lookup("variant").Value.Get().(string)
+	force := cmd.Flag.Lookup("f").Value.Get().(bool)
+
+	db, err := pmgr_load_db()
+	if err != nil {
+		return err
+	}
+
+	todo := []pmgr_project_t{}
+	for _, name := range args {
+		projs := db.find(name, variant)
+		switch len(projs) {
+		case 0:
+			return fmt.Errorf("%s: project [%s] is not installed", n, name)
+		case 1:
+			todo = append(todo, projs[0])
+		default:
+			for _, p := range projs {
+				fmt.Printf("%s: installed: %s\n", n, p)
+			}
+			return fmt.Errorf("%s: [%s] matches %d installed projects (give its version or -variant)", n, name, len(projs))
+		}
+	}
+
+	for _, p := range todo {
+		if deps := db.dependents(p); len(deps) > 0 {
+			for _, dep := range deps {
+				fmt.Printf("%s: [%s] needs [%s]\n", n, dep, p)
+			}
+			if !force {
+				return fmt.Errorf("%s: [%s] is needed by %d installed project(s) (use -f to remove it anyway)", n, p, len(deps))
+			}
+		}
+		if verbose {
+			fmt.Printf("%s: removing [%s] from [%s]...\n", n, p, p.Dir)
+		}
+		err = pmgr_remove(db, p)
+		if err != nil {
+			return err
+		}
+		if verbose {
+			fmt.Printf("%s: removing [%s] from [%s]... [ok]\n", n, p, p.Dir)
+		}
+	}
+	return err
+}
+
+// EOF
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gonuts/commander"
+	"github.com/gonuts/flag"
+)
+
+func hwaf_make_cmd_pmgr_search() *commander.Command {
+	cmd := &commander.Command{
+		Run:       hwaf_run_cmd_pmgr_search,
+		UsageLine: "search [options] <pattern>",
+		Short:     "search the binary repositories",
+		Long: `
+search lists the binary distributions of the repositories whose name
+contains <pattern> (or matches it, for a glob pattern such as 'mana-*').
+
+The repositories are given with -repo, or by the space separated list of
+the [hwaf-pmgr] repos option of the configuration:
+ [hwaf-pmgr]
+ repos = /srv/hwaf-repo http://cern.ch/mana-fwk/repo
+
+ex:
+ $ hwaf pmgr search mana
+ $ hwaf pmgr search -variant=x86_64-linux-gcc-opt 'mana-*'
+ $ hwaf pmgr search -repo=/srv/hwaf-repo mana
+`,
+		Flag: *flag.NewFlagSet("hwaf-pmgr-search", flag.ExitOnError),
+	}
+	cmd.Flag.Bool("v", false, "enable verbose output")
+	cmd.Flag.String("repo", "", "binary repository (directory or URL)")
+	cmd.Flag.String("variant", "", "only list the binary distributions for this HWAF_VARIANT")
+	return cmd
+}
+
+func hwaf_run_cmd_pmgr_search(cmd *commander.Command, args []string) error {
+	var err error
+	n := "hwaf-" + cmd.Name()
+
+	pattern := ""
+	switch len(args) {
+	case 1:
+		pattern = args[0]
+	default:
+		return fmt.Errorf("%s: you need to give a pattern to search for", n)
+	}
+
+	verbose := cmd.Flag.Lookup("v").Value.Get().(bool)
+	repo := cmd.Flag.Lookup("repo").Value.Get().(string)
+	variant := cmd.Flag.Lookup("variant").Value.Get().(string)
+
+	repos, err := pmgr_repos(repo)
+	if err != nil {
+		return err
+	}
+	entries, err := pmgr_available(repos)
+	if err != nil {
+		return err
+	}
+	db, err := pmgr_load_db()
+	if err != nil {
+		return err
+	}
+
+	nfound := 0
+	for _, e := range entries {
+		if variant != "" && e.Variant != variant {
+			continue
+		}
+		if !strings.Contains(e.Name, pattern) && !pmgr_match(pattern, e.Name, e.Version) {
+			continue
+		}
+		nfound++
+		status := ""
+		if db.get(e.Name, e.Version, e.Variant) >= 0 {
+			status = " [installed]"
+		}
+		fmt.Printf("%s%s\n", e, status)
+		if verbose {
+			fmt.Printf("  file:     %s/%s (%d bytes, signed: %v)\n", e.Repo, e.File, e.Size, e.Signed)
+			for _, proj := range e.Projects {
+				fmt.Printf("  upstream: %s-%s\n", proj.Name, proj.Version)
+			}
+		}
+	}
+	if nfound == 0 {
+		return fmt.Errorf("%s: no binary distribution matching [%s]", n, pattern)
+	}
+	return err
+}
+
+// EOF
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gonuts/commander"
+	"github.com/gonuts/flag"
+)
+
+func hwaf_make_cmd_pmgr_verify() *commander.Command {
+	cmd := &commander.Command{
+		Run:       hwaf_run_cmd_pmgr_verify,
+		UsageLine: "verify [options] [<project>[-<version>] ...]",
+		Short:     "check installed projects against their manifest",
+		Long: `
+verify checks the files of the projects installed by 'hwaf pmgr install'
+(all of them, by default) against the manifest of their binary
+distribution, reporting missing and modified files (see 'hwaf bdist
+verify').
+
+ex:
+ $ hwaf pmgr verify
+ $ hwaf pmgr verify mana-core
+ $ hwaf pmgr verify -variant=x86_64-linux-gcc-opt 'mana-*'
+`,
+		Flag: *flag.NewFlagSet("hwaf-pmgr-verify", flag.ExitOnError),
+	}
+	cmd.Flag.Bool("v", false, "enable verbose output")
+	cmd.Flag.String("variant", "", "HWAF_VARIANT of the projects to check")
+	return cmd
+}
+
+func hwaf_run_cmd_pmgr_verify(cmd *commander.Command, args []string) error {
+	var err error
+	n := "hwaf-" + cmd.Name()
+
+	verbose := cmd.Flag.Lookup("v").Value.Get().(bool)
+	variant := cmd.Flag.Lookup("variant").Value.Get().(string)
+
+	db, err := pmgr_load_db()
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		args = []string{"*"}
+	}
+	seen := make(map[string]bool)
+	todo := []pmgr_project_t{}
+	for _, name := range args {
+		projs := db.find(name, variant)
+		if len(projs) == 0 && name != "*" {
+			return fmt.Errorf("%s: project [%s] is not installed", n, name)
+		}
+		for _, p := range projs {
+			if !seen[p.Manifest] {
+				seen[p.Manifest] = true
+				todo = append(todo, p)
+			}
+		}
+	}
+
+	nbad := 0
+	for _, p := range todo {
+		problems, err := pmgr_verify(p)
+		if err != nil {
+			return err
+		}
+		for _, problem := range problems {
+			fmt.Printf("%s: %s\n", p, problem)
+		}
+		if len(problems) > 0 {
+			nbad++
+			continue
+		}
+		if verbose {
+			fmt.Printf("%s: [%s] is consistent with its manifest\n", n, p)
+		}
+	}
+	if nbad > 0 {
+		return fmt.Errorf("%s: %d installed project(s) do not match their manifest", n, nbad)
+	}
+	if verbose {
+		fmt.Printf("%s: checked %d installed project(s)\n", n, len(todo))
+	}
+	return err
+}
+
+// EOF
@@ -20,6 +20,12 @@ func hwaf_make_cmd_setup() *commander.Command {
 		Long: `
 setup sets up an existing workarea.
 
+The build type of -variant must be opt, dbg or one of the build types
+declared in the 'build-types' option of the [hwaf-cfg] configuration
+section, eg:
+  [hwaf-cfg]
+  build-types = prof, asan
+
 ex:
  $ hwaf setup
  $ hwaf setup .
@@ -165,6 +171,55 @@ func hwaf_run_cmd_setup(cmd *commander.Command, args []string) error {
 		proj_variant = variant
 	}
 
+	// an explicit -variant must use a known build type. the variant of an
+	// upstream project may use build types unknown to this context, or not
+	// follow the variant conventions at all: this is only reported.
+	check := true
+	usr_variant, err := g_ctx.ParseVariant(proj_variant)
+	switch {
+	case err != nil && variant != "":
+		return fmt.Errorf(
+			"%s: %v (more build types may be declared with the 'build-types' option of the [hwaf-cfg] section)",
+			n, err,
+		)
+	case err != nil:
+		usr_variant, err = hwaflib.ParseVariantLax(proj_variant)
+		if err != nil {
+			g_ctx.Warnf("%v: not checking the variants of the upstream projects\n", err)
+			check = false
+		} else {
+			g_ctx.Warnf("unknown build type [%s] in variant [%s]\n", usr_variant.Type, proj_variant)
+		}
+	}
+
+	// make sure upstream projects are usable with the requested variant
+	for _, projdir := range projdirs {
+		if !check {
+			break
+		}
+		pinfo, err := hwaflib.NewProjectInfos(filepath.Join(projdir, "project.info"))
+		if err != nil {
+			g_ctx.Warnf("project [%s]: %v\n", projdir, err)
+			continue
+		}
+		v, err := pinfo.Get("HWAF_VARIANT")
+		if err != nil || v == "" {
+			g_ctx.Warnf("project [%s] has no HWAF_VARIANT: not checking its variant\n", projdir)
+			continue
+		}
+		pvariant, err := hwaflib.ParseVariantLax(v)
+		if err != nil {
+			g_ctx.Warnf("project [%s]: %v: not checking its variant\n", projdir, err)
+			continue
+		}
+		if !g_ctx.CompatibleVariant(usr_variant, pvariant) {
+			g_ctx.Warnf(
+				"project [%s] was built for variant [%s] which is not compatible with [%s]\n",
+				projdir, pvariant, usr_variant,
+			)
+		}
+	}
+
 	if tags != "" {
 		tags_slice := strings.Split(tags, ",")
 		tags = strings.Join(tags_slice, " ")
@@ -53,7 +53,8 @@ func hwaf_run_cmd_waf(cmd *commander.Command, args []string) error {
 			return err
 		}
 
-		return err
+		hwaf_post_install()
+		return nil
 	}
 	subargs := append([]string{}, args...)
 	sub := g_ctx.Command(waf, subargs...)
@@ -62,7 +63,22 @@ func hwaf_run_cmd_waf(cmd *commander.Command, args []string) error {
 	sub.Stderr = os.Stderr
 
 	err = sub.Run()
+	if err != nil {
+		return err
+	}
 
+	for _, arg := range args {
+		switch arg {
+		case "install":
+			hwaf_post_install()
+			return err
+		case "configure":
+			_, err2 := hwaf_update_runtime_env()
+			if err2 != nil {
+				g_ctx.Warnf("could not compute the runtime environment: %v\n", err2)
+			}
+		}
+	}
 	return err
 }
 
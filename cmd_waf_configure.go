@@ -39,7 +39,16 @@ func hwaf_run_cmd_waf_configure(cmd *commander.Command, args []string) error {
 	sub := g_ctx.Command(waf, subargs...)
 	sub.Stdout = os.Stdout
 	sub.Stderr = os.Stderr
-	return sub.Run()
+	err = sub.Run()
+	if err != nil {
+		return err
+	}
+
+	_, err = hwaf_update_runtime_env()
+	if err != nil {
+		g_ctx.Warnf("could not compute the runtime environment: %v\n", err)
+	}
+	return nil
 }
 
 // EOF
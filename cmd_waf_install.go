@@ -15,6 +15,10 @@ func hwaf_make_cmd_waf_install() *commander.Command {
 		Long: `
 install installs the local project or packages.
 
+install also writes relocatable setup scripts (setup.sh, setup.csh and
+setup.fish) into the install area: sourcing them sets up the runtime
+environment of the project, wherever the install area has been moved to.
+
 ex:
  $ hwaf install
  $ hwaf install --prefix=my-install-area
@@ -39,7 +43,13 @@ func hwaf_run_cmd_waf_install(cmd *commander.Command, args []string) error {
 	sub := g_ctx.Command(waf, subargs...)
 	sub.Stdout = os.Stdout
 	sub.Stderr = os.Stderr
-	return sub.Run()
+	err = sub.Run()
+	if err != nil {
+		return err
+	}
+
+	hwaf_post_install()
+	return nil
 }
 
 // EOF
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/gonuts/commander"
@@ -15,8 +16,20 @@ func hwaf_make_cmd_waf_run() *commander.Command {
 		Long: `
 run a command with the correct (project) environment.
 
+The runtime environment of the project is computed by waf after each
+'hwaf configure' and 'hwaf install' and cached in the build directory.
+The environment snippets of the workarea (.hwaf/env.d) are applied on top
+of it. $VAR and ${VAR} in the command arguments are replaced by their value
+in the runtime environment.
+
+If the command is the name of a runtime alias declared by the project (see
+'hwaf alias ls'), the alias is expanded by /bin/sh, with the remaining
+arguments appended to it.
+
 ex:
  $ hwaf run some-command --some-flag some-data
+ $ hwaf run ls '$INSTALL_AREA/bin'
+ $ hwaf run athena -c 'EvtMax=10' jobo.py
 `,
 		Flag:        *flag.NewFlagSet("hwaf-waf-run", flag.ExitOnError),
 		CustomFlags: true,
@@ -26,25 +39,47 @@ ex:
 
 func hwaf_run_cmd_waf_run(cmd *commander.Command, args []string) error {
 	var err error
-	//n := "hwaf-" + cmd.Name()
+	n := "hwaf-" + cmd.Name()
+
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("%s: needs a command to run", n)
+	}
 
-	waf, err := g_ctx.WafBin()
+	env, _, err := hwaf_runtime_environ()
 	if err != nil {
 		return err
 	}
 
-	pwd, err := os.Getwd()
+	aliases, err := runtime_aliases()
 	if err != nil {
 		return err
 	}
 
-	err = os.Setenv("HWAF_WAF_SHELL_CWD", pwd)
+	subargs := make([]string, 0, len(args)+4)
+	for _, alias := range aliases {
+		if alias[0] != args[0] {
+			continue
+		}
+		// aliases may use any shell syntax: let the shell expand them.
+		subargs = append(subargs, "/bin/sh", "-c", alias[1]+` "$@"`, alias[0])
+		args = args[1:]
+		break
+	}
+	for _, arg := range args {
+		subargs = append(subargs, env_expand(arg, env))
+	}
+
+	exe, err := env_lookpath(subargs[0], env)
 	if err != nil {
 		return err
 	}
 
-	subargs := append([]string{"run", "--"}, args...)
-	sub := g_ctx.Command(waf, subargs...)
+	sub := g_ctx.Command(exe, subargs[1:]...)
+	sub.Args[0] = subargs[0]
+	sub.Env = environ_list(env)
 	sub.Stdin = os.Stdin
 	sub.Stdout = os.Stdout
 	sub.Stderr = os.Stderr
@@ -1,7 +1,12 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 
 	"github.com/gonuts/commander"
 	"github.com/gonuts/flag"
@@ -10,11 +15,36 @@ import (
 func hwaf_make_cmd_waf_shell() *commander.Command {
 	cmd := &commander.Command{
 		Run:       hwaf_run_cmd_waf_shell,
-		UsageLine: "shell",
+		UsageLine: "shell [shell-args...]",
 		Short:     "run an interactive shell with the correct environment",
 		Long: `
 run an interactive shell with the correct environment.
 
+The shell is taken from $SHELL (bash, zsh, fish, tcsh/csh and other POSIX
+shells are supported). The runtime environment of the project is computed
+by waf after each 'hwaf configure' and 'hwaf install' and cached in the
+build directory.
+
+The user's own rc files are read first, then hwaf:
+ - applies the runtime environment of the project, and the environment
+   snippets of the workarea (.hwaf/env.d),
+ - prepends a segment with the project name, version and variant to the
+   prompt,
+ - defines the runtime aliases of the project,
+ - executes the rc file of the workarea, if any (by default
+   .hwaf/shellrc.sh, .hwaf/shellrc.csh or .hwaf/shellrc.fish, depending
+   on the shell).
+
+The shell can be configured from local.conf or from the global hwaf.conf:
+
+ [hwaf-shell]
+ shell  = /bin/zsh
+ prompt = (${project} ${variant})
+ rcfile = scripts/myrc.sh
+
+where ${name}, ${version}, ${project} (name-version) and ${variant} are
+replaced in the prompt segment. An empty prompt disables the segment.
+
 ex:
  $ hwaf shell
 `,
@@ -28,27 +58,197 @@ func hwaf_run_cmd_waf_shell(cmd *commander.Command, args []string) error {
 	var err error
 	//n := "hwaf-" + cmd.Name()
 
-	waf, err := g_ctx.WafBin()
+	env, keys, err := hwaf_runtime_environ()
+	if err != nil {
+		return err
+	}
+
+	aliases, err := runtime_aliases()
+	if err != nil {
+		return err
+	}
+
+	workdir, err := g_ctx.Workarea()
+	if err != nil {
+		return err
+	}
+
+	shell := cfg_string("hwaf-shell", "shell", env["SHELL"])
+	if shell == "" {
+		shell = "/bin/sh"
+	}
+	shname := filepath.Base(shell)
+	format := shname
+	if v, ok := env_format_aliases[format]; ok {
+		format = v
+	}
+	if _, ok := alias_formats[format]; !ok {
+		format = "sh"
+	}
+
+	tmpdir, err := ioutil.TempDir("", "hwaf-env-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpdir)
+
+	// have the shell read the user's rc file first, then ours.
+	rc := new(bytes.Buffer)
+	rc_fname := filepath.Join(tmpdir, "hwaf-rc."+format)
+	subargs := []string{}
+	switch shname {
+	case "zsh":
+		// zsh reads its rc files from $ZDOTDIR: restore it once ours are read.
+		dotdir := env["ZDOTDIR"]
+		if dotdir == "" {
+			dotdir = env["HOME"]
+		}
+		zshenv := new(bytes.Buffer)
+		fmt.Fprintf(zshenv, "[ -f %[1]s/.zshenv ] && source %[1]s/.zshenv\n", sh_quote(dotdir))
+		fmt.Fprintf(zshenv, "_hwaf_zdotdir=\"${ZDOTDIR}\"\n")
+		fmt.Fprintf(zshenv, "[ \"${ZDOTDIR}\" = %s ] && _hwaf_zdotdir=%s\n", sh_quote(tmpdir), sh_quote(dotdir))
+		fmt.Fprintf(zshenv, "ZDOTDIR=%s\n", sh_quote(tmpdir))
+		err = ioutil.WriteFile(filepath.Join(tmpdir, ".zshenv"), zshenv.Bytes(), 0644)
+		if err != nil {
+			return err
+		}
+		rc_fname = filepath.Join(tmpdir, ".zshrc")
+		fmt.Fprintf(rc, "ZDOTDIR=\"${_hwaf_zdotdir}\"\n")
+		fmt.Fprintf(rc, "unset _hwaf_zdotdir\n")
+		fmt.Fprintf(rc, "[ -f \"${ZDOTDIR}/.zshrc\" ] && source \"${ZDOTDIR}/.zshrc\"\n")
+		env["ZDOTDIR"] = tmpdir
+		subargs = append(subargs, "-i")
+
+	case "bash":
+		fmt.Fprintf(rc, "[ -f \"${HOME}/.bashrc\" ] && source \"${HOME}/.bashrc\"\n")
+		subargs = append(subargs, "--init-file", rc_fname, "-i")
+
+	case "tcsh", "csh":
+		// C shells only read rc files from $HOME: restore it from ours.
+		home := env["HOME"]
+		rc_fname = filepath.Join(tmpdir, ".cshrc")
+		fmt.Fprintf(rc, "set home = %s\n", csh_quote(home))
+		fmt.Fprintf(rc, "setenv HOME %s\n", csh_quote(home))
+		fmt.Fprintf(rc, "if ( -f ~/.tcshrc && $?tcsh ) then\n")
+		fmt.Fprintf(rc, "  source ~/.tcshrc\n")
+		fmt.Fprintf(rc, "else if ( -f ~/.cshrc ) then\n")
+		fmt.Fprintf(rc, "  source ~/.cshrc\n")
+		fmt.Fprintf(rc, "endif\n")
+		env["HOME"] = tmpdir
+		subargs = append(subargs, "-i")
+
+	case "fish":
+		// the init command is run after the user's configuration files
+		subargs = append(subargs, "-i", "-C", "source "+fish_quote(rc_fname))
+
+	default:
+		if v := os.Getenv("ENV"); v != "" {
+			fmt.Fprintf(rc, "[ -f %s ] && . %s\n", sh_quote(v), sh_quote(v))
+		}
+		env["ENV"] = rc_fname
+		subargs = append(subargs, "-i")
+	}
+
+	// the user's rc file may reset some of the runtime variables:
+	// set them again afterwards.
+	final := make(map[string]string, len(keys))
+	for _, k := range keys {
+		if v, ok := env[k]; ok {
+			final[k] = v
+		}
+	}
+	write_env, err := env_writer_for(format)
+	if err != nil {
+		return err
+	}
+	err = write_env(rc, env_set_ops(final))
 	if err != nil {
 		return err
 	}
 
-	pwd, err := os.Getwd()
+	seg, err := shell_prompt_segment()
 	if err != nil {
 		return err
 	}
+	if seg != "" {
+		shell_write_prompt(rc, format, seg+" ")
+	}
 
-	err = os.Setenv("HWAF_WAF_SHELL_CWD", pwd)
+	write_aliases, err := alias_writer_for(format)
+	if err != nil {
+		return err
+	}
+	err = write_aliases(rc, aliases)
 	if err != nil {
 		return err
 	}
 
-	subargs := append([]string{"shell"}, args...)
-	sub := g_ctx.Command(waf, subargs...)
+	wa_rc := cfg_string("hwaf-shell", "rcfile", filepath.Join(".hwaf", "shellrc."+format))
+	if !filepath.IsAbs(wa_rc) {
+		wa_rc = filepath.Join(workdir, wa_rc)
+	}
+	if path_exists(wa_rc) {
+		switch format {
+		case "sh":
+			fmt.Fprintf(rc, ". %s\n", sh_quote(wa_rc))
+		case "csh":
+			fmt.Fprintf(rc, "source %s\n", csh_quote(wa_rc))
+		case "fish":
+			fmt.Fprintf(rc, "source %s\n", fish_quote(wa_rc))
+		}
+	}
+
+	err = ioutil.WriteFile(rc_fname, rc.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf(":: hwaf environment... [setup]\n")
+	fmt.Printf(":: hit ^D or exit to go back to the parent shell\n")
+
+	sub := g_ctx.Command(shell, append(subargs, args...)...)
+	sub.Env = environ_list(env)
 	sub.Stdin = os.Stdin
 	sub.Stdout = os.Stdout
 	sub.Stderr = os.Stderr
 	return sub.Run()
 }
 
+// shell_prompt_segment returns the prompt segment identifying the project
+// of the current workarea, as configured in the [hwaf-shell] section.
+func shell_prompt_segment() (string, error) {
+	pinfos, err := g_ctx.ProjectInfos()
+	if err != nil {
+		return "", err
+	}
+
+	vars := make(map[string]string, 4)
+	vars["name"], _ = pinfos.Get("HWAF_PROJECT_NAME")
+	vars["version"], _ = pinfos.Get("HWAF_PROJECT_VERSION")
+	vars["variant"], _ = pinfos.Get("HWAF_VARIANT")
+	if vars["variant"] == "" {
+		vars["variant"] = g_ctx.Variant()
+	}
+	vars["project"] = vars["name"]
+	if vars["version"] != "" {
+		vars["project"] += "-" + vars["version"]
+	}
+
+	prompt := cfg_string("hwaf-shell", "prompt", "[${project} ${variant}]")
+	return env_expand(prompt, vars), nil
+}
+
+// shell_write_prompt writes the code prepending seg to the prompt of a shell
+func shell_write_prompt(w io.Writer, format, seg string) {
+	switch format {
+	case "sh":
+		fmt.Fprintf(w, "PS1=%s\"${PS1}\"\n", sh_quote(seg))
+	case "csh":
+		fmt.Fprintf(w, "if ( $?prompt ) set prompt = %s$prompt:q\n", csh_quote(seg))
+	case "fish":
+		fmt.Fprintf(w, "functions -q fish_prompt; and functions -c fish_prompt _hwaf_fish_prompt\n")
+		fmt.Fprintf(w, "function fish_prompt; printf '%%s' %s; functions -q _hwaf_fish_prompt; and _hwaf_fish_prompt; end\n", fish_quote(seg))
+	}
+}
+
 // EOF
@@ -12,8 +12,10 @@ func hwaf_make_cmd_waf_show() *commander.Command {
 		Subcommands: []*commander.Command{
 			hwaf_make_cmd_waf_show_active_tags(),
 			hwaf_make_cmd_waf_show_aliases(),
+			hwaf_make_cmd_waf_show_compilers(),
 			hwaf_make_cmd_waf_show_constituents(),
 			hwaf_make_cmd_waf_show_default_variant(),
+			hwaf_make_cmd_waf_show_env(),
 			hwaf_make_cmd_waf_show_flags(),
 			hwaf_make_cmd_waf_show_platform(),
 			hwaf_make_cmd_waf_show_projects(),
@@ -1,13 +1,9 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"strings"
 
 	"github.com/gonuts/commander"
-	gocfg "github.com/gonuts/config"
 	"github.com/gonuts/flag"
 )
 
@@ -35,43 +31,22 @@ ex:
 func hwaf_run_cmd_waf_show_aliases(cmd *commander.Command, args []string) error {
 	var err error
 
-	pinfo, err := g_ctx.ProjectInfos()
+	list, err := runtime_aliases()
 	if err != nil {
 		return err
 	}
-
-	val, err := pinfo.Get("HWAF_RUNTIME_ALIASES")
-	if err != nil {
-		if _, ok := err.(gocfg.OptionError); ok {
-			// no alias defined
-			return fmt.Errorf("no runtime alias defined")
-		}
-		if err != nil {
-			return err
-		}
-	}
-
-	tmp := make([][2]string, 0)
-	val = strings.Replace(val, `['`, `["`, -1)
-	val = strings.Replace(val, `', '`, `", "`, -1)
-	val = strings.Replace(val, `']`, `"]`, -1)
-
-	buf := bytes.NewReader([]byte(val))
-	err = json.NewDecoder(buf).Decode(&tmp)
-	if err != nil {
-		return err
+	if len(list) == 0 {
+		return fmt.Errorf("no runtime alias defined")
 	}
 
-	aliases := make(map[string]string, len(tmp))
-	for _, alias := range tmp {
-		dst, src := alias[0], alias[1]
-		aliases[dst] = src
-		//fmt.Printf("%s=%q\n", dst, src)
+	aliases := make(map[string]string, len(list))
+	for _, alias := range list {
+		aliases[alias[0]] = alias[1]
 	}
 
 	if len(args) <= 0 {
-		for dst, src := range aliases {
-			fmt.Printf("%s=%q\n", dst, src)
+		for _, alias := range list {
+			fmt.Printf("%s=%q\n", alias[0], alias[1])
 		}
 	} else {
 		all_good := true
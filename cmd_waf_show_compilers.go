@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gonuts/commander"
+	"github.com/gonuts/flag"
+)
+
+func hwaf_make_cmd_waf_show_compilers() *commander.Command {
+	cmd := &commander.Command{
+		Run:       hwaf_run_cmd_waf_show_compilers,
+		UsageLine: "compilers",
+		Short:     "show the C/C++ compilers found on the system",
+		Long: `
+show compilers displays the C/C++ compilers found on the system, in order of
+preference ($CXX, $CC, [hwaf-toolchain] section, $PATH).
+The first one is used to infer the default variant.
+
+ex:
+ $ hwaf show compilers
+ *gcc47    4.7.2    /usr/bin/gcc ($PATH)
+  clang34  3.4      /usr/bin/clang ($PATH)
+`,
+		Flag: *flag.NewFlagSet("hwaf-waf-show-compilers", flag.ExitOnError),
+	}
+	return cmd
+}
+
+func hwaf_run_cmd_waf_show_compilers(cmd *commander.Command, args []string) error {
+	var err error
+	n := "hwaf-" + cmd.Name()
+
+	comps := g_ctx.Compilers()
+	if len(comps) == 0 {
+		return fmt.Errorf("%s: could not find any C/C++ compiler", n)
+	}
+
+	for i, comp := range comps {
+		mark := " "
+		if i == 0 {
+			mark = "*"
+		}
+		fmt.Printf("%s%-8s %-8s %s (%s)\n", mark, comp.Tag(), comp.Vers, comp.Cmd, comp.Origin)
+	}
+
+	return err
+}
+
+// EOF
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gonuts/commander"
+	"github.com/gonuts/flag"
+)
+
+func hwaf_make_cmd_waf_show_env() *commander.Command {
+	cmd := &commander.Command{
+		Run:       hwaf_run_cmd_waf_show_env,
+		UsageLine: "env [options] [<var-name> [<var-name> [...]]]",
+		Short:     "show where the runtime environment variables come from",
+		Long: `
+show env displays, for each runtime environment variable of the local
+project (or the given ones), its final value and the ordered list of
+statements which contributed to it, with the package and the line
+responsible for each of them.
+
+ex:
+ $ hwaf show env PYTHONPATH
+ PYTHONPATH=/opt/sw/python/2.7.5/lib/python2.7:/opt/sw/python/2.6.8/lib/python2.6
+   1: path_prepend  External/PyCmt (src/External/PyCmt/wscript:12)
+      '/opt/sw/python/2.6.8/lib/python2.6'
+   2: path_prepend  External/Python (src/External/Python/wscript:20)
+      '/opt/sw/python/2.7.5/lib/python2.7'
+
+ $ hwaf show env
+`,
+		Flag: *flag.NewFlagSet("hwaf-waf-show-env", flag.ExitOnError),
+	}
+	cmd.Flag.Bool("v", false, "enable verbose output (values before/after each statement)")
+	return cmd
+}
+
+func hwaf_run_cmd_waf_show_env(cmd *commander.Command, args []string) error {
+	var err error
+	n := "hwaf-" + cmd.Name()
+
+	verbose := cmd.Flag.Lookup("v").Value.Get().(bool)
+
+	pinfos, err := g_ctx.ProjectInfos()
+	if err != nil {
+		return err
+	}
+
+	spy, err := pinfos.EnvSpy()
+	if err != nil {
+		return fmt.Errorf("%s: no environment provenance informations (%v)", n, err)
+	}
+
+	env, err := waf_dump_env()
+	if err != nil {
+		return err
+	}
+
+	keys := args
+	if len(keys) == 0 {
+		keys, err = pinfos.GetList("HWAF_RUNTIME_ENVVARS")
+		if err != nil {
+			return err
+		}
+		sort.Strings(keys)
+	}
+
+	workdir, err := g_ctx.Workarea()
+	if err != nil {
+		return err
+	}
+
+	for i, k := range keys {
+		if i > 0 {
+			fmt.Printf("\n")
+		}
+		if v, ok := env[k]; ok {
+			fmt.Printf("%s=%s\n", k, v)
+		} else {
+			fmt.Printf("%s (not set)\n", k)
+		}
+
+		idx := 0
+		for _, entry := range spy {
+			if entry.Key != k {
+				continue
+			}
+			idx++
+			stmt := entry.Stmt
+			if stmt == "" {
+				stmt = "env." + entry.Action
+			}
+			fname := entry.File
+			if rel, err := filepath.Rel(workdir, fname); err == nil && !strings.HasPrefix(rel, "..") {
+				fname = rel
+			}
+			fmt.Printf("  %2d: %-13s %s (%s:%d)\n",
+				idx, stmt, spy_pkg_name(fname), fname, entry.Line,
+			)
+			fmt.Printf("      %s\n", spy_repr(entry.Value))
+			if verbose {
+				if entry.Code != "" {
+					fmt.Printf("      code: %s\n", strings.TrimSpace(entry.Code))
+				}
+				fmt.Printf("      old:  %s\n", spy_repr(entry.Old))
+				fmt.Printf("      new:  %s\n", spy_repr(entry.New))
+			}
+		}
+	}
+	return err
+}
+
+// spy_pkg_name returns the name of the package a wscript file belongs to
+func spy_pkg_name(fname string) string {
+	dir := filepath.Dir(fname)
+	pkgdir := "src"
+	if cfg, err := g_ctx.LocalCfg(); err == nil && cfg.HasOption("hwaf-cfg", "pkgdir") {
+		if v, err := cfg.String("hwaf-cfg", "pkgdir"); err == nil {
+			pkgdir = v
+		}
+	}
+	if rel, err := filepath.Rel(pkgdir, dir); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	if dir == "." {
+		return "<project>"
+	}
+	return dir
+}
+
+// spy_repr formats a value decoded from its python representation
+func spy_repr(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "None"
+	case string:
+		return "'" + strings.Replace(v, "'", `\'`, -1) + "'"
+	case []interface{}:
+		elems := make([]string, 0, len(v))
+		for _, elem := range v {
+			elems = append(elems, spy_repr(elem))
+		}
+		return "[" + strings.Join(elems, ", ") + "]"
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		elems := make([]string, 0, len(v))
+		for _, k := range keys {
+			elems = append(elems, spy_repr(k)+": "+spy_repr(v[k]))
+		}
+		return "{" + strings.Join(elems, ", ") + "}"
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// EOF
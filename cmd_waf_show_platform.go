@@ -5,7 +5,6 @@ import (
 
 	"github.com/gonuts/commander"
 	"github.com/gonuts/flag"
-	"github.com/hwaf/hwaf/platform"
 )
 
 func hwaf_make_cmd_waf_show_platform() *commander.Command {
@@ -29,7 +28,7 @@ func hwaf_run_cmd_waf_show_platform(cmd *commander.Command, args []string) error
 	var err error
 	//n := "hwaf-" + cmd.Name()
 
-	pinfos, err := platform.Infos()
+	pinfos, err := g_ctx.PlatformInfos()
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,173 @@
+package hwaflib
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	gocfg "github.com/gonuts/config"
+)
+
+// Compiler describes a C/C++ compiler found on the system
+type Compiler struct {
+	Name   string // compiler family (gcc, clang, icc, icx)
+	Cmd    string // command used to run the compiler (eg: /usr/bin/gcc, ccache g++)
+	Major  string // major version number
+	Minor  string // minor version number
+	Vers   string // full version string (eg: 4.7.2)
+	Origin string // where the compiler was found (eg: $CC, hwaf-toolchain, $PATH)
+}
+
+// Tag returns the compiler component of a variant (eg: gcc47, clang34)
+func (c Compiler) Tag() string {
+	return c.Name + c.Major + c.Minor
+}
+
+func (c Compiler) String() string {
+	return fmt.Sprintf(
+		"Compiler{Name=%q Vers=%q Cmd=%q Origin=%q}",
+		c.Name, c.Vers, c.Cmd, c.Origin,
+	)
+}
+
+// compiler_probes lists the patterns used to identify a compiler from its
+// '--version' output.
+// order matters: Apple's gcc is really clang and icc mimics gcc's output.
+var compiler_probes = []struct {
+	name string
+	re   *regexp.Regexp
+}{
+	{"clang", regexp.MustCompile(`(?:clang|LLVM) version (\d+)\.(\d+)((?:\.\d+)*)`)},
+	{"icc", regexp.MustCompile(`\(ICC\) (\d+)\.(\d+)((?:\.\d+)*)`)},
+	{"icx", regexp.MustCompile(`Intel\(R\) oneAPI .*?Compiler (\d+)\.(\d+)((?:\.\d+)*)`)},
+	{"gcc", regexp.MustCompile(`(?m)^\S+ \(.*\) (\d+)\.(\d+)((?:\.\d+)*)`)},
+}
+
+// ProbeCompiler runs the given compiler command (an executable, possibly
+// followed by arguments, eg: "ccache g++") and infers its family and version.
+func ProbeCompiler(cmd string) (Compiler, error) {
+	var comp Compiler
+	args := strings.Fields(cmd)
+	if len(args) == 0 {
+		return comp, fmt.Errorf("hwaf: empty compiler command")
+	}
+	exe, err := exec.LookPath(args[0])
+	if err != nil {
+		return comp, err
+	}
+	args = append(args[1:], "--version")
+
+	probe := exec.Command(exe, args...)
+	probe.Env = append(os.Environ(), "LC_ALL=C")
+	out, err := probe.CombinedOutput()
+	if err != nil {
+		return comp, fmt.Errorf("hwaf: could not run [%s --version]: %v", cmd, err)
+	}
+
+	for _, p := range compiler_probes {
+		m := p.re.FindStringSubmatch(string(out))
+		if m == nil {
+			continue
+		}
+		if p.name == "gcc" && !strings.Contains(string(out), "Free Software Foundation") {
+			continue
+		}
+		comp = Compiler{
+			Name:  p.name,
+			Cmd:   cmd,
+			Major: m[1],
+			Minor: m[2],
+			Vers:  m[1] + "." + m[2] + m[3],
+		}
+		return comp, nil
+	}
+	return comp, fmt.Errorf("hwaf: could not infer compiler from [%s --version]", cmd)
+}
+
+// compiler_candidates returns the list of compiler commands to probe, in
+// order of preference, along with their origin.
+func (ctx *Context) compiler_candidates() [][2]string {
+	cands := make([][2]string, 0, 8)
+	for _, k := range []string{"CXX", "CC"} {
+		if v := strings.TrimSpace(os.Getenv(k)); v != "" {
+			cands = append(cands, [2]string{v, "$" + k})
+		}
+	}
+
+	names := []string{"gcc", "clang", "icc", "icx"}
+
+	section := "hwaf-toolchain"
+	for _, cfg := range []*gocfg.Config{ctx.lcfg, ctx.gcfg} {
+		if cfg == nil {
+			continue
+		}
+		topdir, err := cfg.String(section, "path")
+		if err != nil || topdir == "" {
+			continue
+		}
+		topdir = os.ExpandEnv(topdir)
+		for _, name := range names {
+			exe := filepath.Join(topdir, "bin", name)
+			if path_exists(exe) {
+				cands = append(cands, [2]string{exe, section})
+			}
+		}
+		break
+	}
+
+	for _, name := range names {
+		if exe, err := exec.LookPath(name); err == nil {
+			cands = append(cands, [2]string{exe, "$PATH"})
+		}
+	}
+	return cands
+}
+
+// Compilers returns the list of compilers found on the system, in order of
+// preference: $CXX/$CC, the [hwaf-toolchain] configuration section, $PATH.
+func (ctx *Context) Compilers() []Compiler {
+	cache := ctx.probes()
+	if cache.Comps != nil {
+		return cache.Comps
+	}
+
+	comps := make([]Compiler, 0, 4)
+	seen := make(map[string]bool)
+	for _, cand := range ctx.compiler_candidates() {
+		cmd, origin := cand[0], cand[1]
+		key := cmd
+		if exe, err := filepath.EvalSymlinks(cmd); err == nil {
+			key = exe
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		comp, err := ProbeCompiler(cmd)
+		if err != nil {
+			ctx.Debugf("%v\n", err)
+			continue
+		}
+		comp.Origin = origin
+		comps = append(comps, comp)
+	}
+
+	cache.Comps = comps
+	ctx.save_probes()
+	return comps
+}
+
+// DefaultCompiler returns the preferred compiler found on the system.
+func (ctx *Context) DefaultCompiler() (Compiler, error) {
+	comps := ctx.Compilers()
+	if len(comps) == 0 {
+		return Compiler{}, fmt.Errorf("hwaf: could not find any C/C++ compiler")
+	}
+	return comps[0], nil
+}
+
+// EOF
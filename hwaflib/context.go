@@ -1,14 +1,12 @@
 package hwaflib
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
-	"regexp"
 	"runtime"
 	"strings"
 
@@ -45,6 +43,17 @@ type Context struct {
 	msg      *logger.Logger // for messaging
 	subcmds  []*exec.Cmd    // list of subcommands launched by hwaf
 	atexit   []func()       // list of functions to run at-exit
+
+	probe_cache *probes_t // cached results of platform and toolchain probing
+	wa_cwd      string    // directory from which the workarea was last looked for (see Workarea)
+	wa_err      error     // why no workarea was found from wa_cwd
+
+	environ       []string // environment hwaf was invoked with
+	cfgenv        []string // environment variables set from the [hwaf-env] sections
+	pytools       string   // python path to the hwaf tools
+	waf           string   // path to the waf binary
+	hermetic      bool     // whether waf is run in a minimal environment
+	hermetic_done bool     // whether the dropped variables were reported
 }
 
 func NewContext() (*Context, error) {
@@ -119,6 +128,7 @@ func (ctx *Context) WafBin() (string, error) {
 			ctx.Warnf("problem initializing waf: %v\n", err)
 			return "", err
 		}
+		ctx.waf = waf
 		return waf, nil
 	}
 
@@ -130,6 +140,7 @@ func (ctx *Context) WafBin() (string, error) {
 			ctx.Warnf("problem initializing waf: %v\n", err)
 			return "", err
 		}
+		ctx.waf = waf
 		return waf, nil
 	}
 
@@ -144,6 +155,12 @@ func (ctx *Context) Variant() string {
 	return ctx.variant
 }
 
+// InitialEnviron returns the environment hwaf was invoked with, before the
+// context modified it (configuration, env.d snippets, hwaf tools, ...)
+func (ctx *Context) InitialEnviron() []string {
+	return ctx.environ
+}
+
 func (ctx *Context) pkgdir() string {
 	if ctx.lcfg == nil {
 		return "src"
@@ -160,12 +177,21 @@ func (ctx *Context) Workarea() (string, error) {
 		return *ctx.workarea, nil
 	}
 
+	// do not look for the workarea again from the same directory: the
+	// failure is cached for the lifetime of the process. (it can not be
+	// cached with the probes: their cache lives in the workarea)
+	cwd, _ := os.Getwd()
+	if ctx.wa_err != nil && ctx.wa_cwd == cwd {
+		return "", ctx.wa_err
+	}
+
 	// FIXME: handle case where we are invoked from a submodule directory
 	git := exec.Command(
 		"git", "rev-parse", "--show-toplevel",
 	)
 	bout, err := git.Output()
 	if err != nil {
+		ctx.wa_cwd, ctx.wa_err = cwd, err
 		return "", err
 	}
 	out := strings.Trim(string(bout), " \r\n")
@@ -178,102 +204,109 @@ func (ctx *Context) Workarea() (string, error) {
 
 func (ctx *Context) DefaultVariant() string {
 	hwaf_os := runtime.GOOS
-	hwaf_arch := runtime.GOARCH
 	hwaf_comp := "gcc"
-	switch hwaf_arch {
-	case "amd64":
-		hwaf_arch = "x86_64"
-	case "386":
-		hwaf_arch = "i686"
-	default:
-		//hwaf_arch = "unknown"
-		panic(fmt.Sprintf("unknown architecture [%s]", hwaf_arch))
+	hwaf_arch, err := VariantArch(runtime.GOARCH)
+	if err != nil {
+		ctx.Warnf("%v\n", err)
 	}
 	//FIXME: is 'gcc' a good enough default ?
-	variant := fmt.Sprintf("%s-%s-%s-opt", hwaf_arch, hwaf_os, hwaf_comp)
+	variant := Variant{
+		Arch:     hwaf_arch,
+		Os:       hwaf_os,
+		Compiler: hwaf_comp,
+		Type:     "opt",
+	}
 
-	pinfos, err := platform.Infos()
+	cache := ctx.probes()
+	if cache.Variant != "" {
+		return cache.Variant
+	}
+	defer func() {
+		cache.Variant = variant.String()
+		ctx.save_probes()
+	}()
+
+	pinfos, err := ctx.PlatformInfos()
 	if err != nil {
-		//println("*** error:" + err.Error())
-		return variant
+		if comp, err := ctx.DefaultCompiler(); err == nil {
+			variant.Compiler = comp.Tag()
+		}
+		ctx.Warnf("%v (using generic variant [%s])\n", err, variant)
+		return variant.String()
 	}
 
 	// try harder...
 	variant2, err := ctx.infer_variant(pinfos, hwaf_arch, hwaf_os, hwaf_comp)
 	if err != nil {
-		//println("*** error:" + err.Error())
-		return variant
+		ctx.Warnf("%v (using generic variant [%s])\n", err, variant)
+		return variant.String()
 	}
 	variant = variant2
-	return variant
+	return variant.String()
 }
 
-func infer_gcc_version() (string, error) {
-	re_gcc_vers := regexp.MustCompile(`.*? \(.*?\) ([\d.]+).*?`)
-	out, err := exec.Command("gcc", "--version").Output()
-	if err != nil {
-		return "", err
-	}
-
-	lines := bytes.Split(out, []byte("\r\n"))
-	line := string(lines[0])
-
-	m := re_gcc_vers.FindStringSubmatch(line)
-	if m == nil {
-		return "", fmt.Errorf("hwaf: could not infer gcc version")
+// dist_map returns the table mapping distribution IDs to the OS component
+// of a variant.
+// The default table can be extended (or overridden) from the [hwaf-dists]
+// section of the global and local configuration files, e.g.:
+//
+//	[hwaf-dists]
+//	neon = ubuntu${version_nodots}
+func (ctx *Context) dist_map() map[string]string {
+	dists := platform.DefaultDistMap()
+	section := "hwaf-dists"
+	for _, cfg := range []*gocfg.Config{ctx.gcfg, ctx.lcfg} {
+		if cfg == nil || !cfg.HasSection(section) {
+			continue
+		}
+		options, err := cfg.Options(section)
+		if err != nil {
+			ctx.Warnf("problem reading section [%s]: %v\n", section, err)
+			continue
+		}
+		for _, k := range options {
+			v, err := cfg.RawString(section, k)
+			if err != nil {
+				continue
+			}
+			dists[strings.ToLower(k)] = strings.TrimSpace(v)
+		}
 	}
-
-	//fmt.Printf("gcc: %v\n", m)
-	m = strings.Split(m[1], ".")
-	major := m[0]
-	minor := m[1]
-	vers := fmt.Sprintf("gcc%s%s", major, minor)
-	return vers, nil
+	return dists
 }
 
-func (ctx *Context) infer_variant(pinfos platform.Platform, hwaf_arch, hwaf_os, hwaf_comp string) (string, error) {
+func (ctx *Context) infer_variant(pinfos platform.Platform, hwaf_arch, hwaf_os, hwaf_comp string) (Variant, error) {
 
 	var err error
-	var variant string
+	var variant Variant
 
 	hwaf_os = pinfos.DistId()
 
 	switch pinfos.System {
 	case "Linux":
-		hwaf_comp, err = infer_gcc_version()
-		if err != nil {
-			hwaf_comp = "gcc"
-			err = nil
+		hwaf_comp = "gcc"
+		if comp, err := ctx.DefaultCompiler(); err == nil {
+			hwaf_comp = comp.Tag()
 		}
-		switch pinfos.DistName {
-		case "centos", "sl", "slc", "rh", "rhel":
-			rel := strings.Split(pinfos.DistVers, ".")
-			major := rel[0]
-			hwaf_os = pinfos.DistName + major
-
-		case "ubuntu":
-			vers := strings.Replace(pinfos.DistVers, ".", "", -1)
-			hwaf_os = pinfos.DistName + vers
-
-		case "arch":
-			hwaf_os = "archlinux"
-
-		default:
+		hwaf_os, err = pinfos.DistTag(ctx.dist_map())
+		if err != nil {
 			ctx.Warnf("hwaf: unhandled distribution [%s]\n", pinfos.DistId())
 			hwaf_os = "linux"
-			hwaf_comp = "gcc"
+			err = nil
 		}
 
 	case "Darwin":
 		rel := strings.Split(pinfos.DistVers, ".")
 		major := rel[0]
-		minor := rel[1]
+		minor := ""
+		if len(rel) > 1 {
+			minor = rel[1]
+		}
 		hwaf_os = pinfos.DistName + major + minor
-		if strings.HasPrefix(pinfos.DistVers, "10.6") {
-			hwaf_comp, err = infer_gcc_version()
-			if err != nil {
-				hwaf_comp = "gcc"
-			}
+		if comp, err := ctx.DefaultCompiler(); err == nil {
+			hwaf_comp = comp.Tag()
+		} else if strings.HasPrefix(pinfos.DistVers, "10.6") {
+			hwaf_comp = "gcc"
 		} else if strings.HasPrefix(pinfos.DistVers, "10.7") {
 			hwaf_comp = "clang41"
 		} else if strings.HasPrefix(pinfos.DistVers, "10.8") {
@@ -281,14 +314,22 @@ func (ctx *Context) infer_variant(pinfos platform.Platform, hwaf_arch, hwaf_os,
 		} else if strings.HasPrefix(pinfos.DistVers, "10.9") {
 			hwaf_comp = "clang50"
 		} else {
-			panic(fmt.Sprintf("hwaf: unhandled distribution [%s]", pinfos.DistId()))
+			return variant, fmt.Errorf(
+				"hwaf: could not infer compiler for distribution [%s]",
+				pinfos.DistId(),
+			)
 		}
 
 	default:
-		panic(fmt.Sprintf("hwaf: unknown platform [%s]", pinfos.System))
+		return variant, fmt.Errorf("hwaf: unknown platform [%s]", pinfos.System)
 	}
 
-	variant = fmt.Sprintf("%s-%s-%s-opt", hwaf_arch, hwaf_os, hwaf_comp)
+	variant = Variant{
+		Arch:     hwaf_arch,
+		Os:       hwaf_os,
+		Compiler: hwaf_comp,
+		Type:     "opt",
+	}
 	return variant, err
 }
 
@@ -304,7 +345,7 @@ func hwaf_root() string {
 	exe, err := exec.LookPath(os.Args[0])
 	if err != nil {
 		// impossible ?
-		panic(err.Error())
+		return ""
 	}
 
 	bin, err := filepath.Abs(filepath.Dir(exe))
@@ -350,12 +391,14 @@ func (ctx *Context) load_env_from_cfg(cfg *gocfg.Config) error {
 		if err != nil {
 			ctx.Warnf("problem setting env. var [%s]: %v\n", k, err)
 		}
+		ctx.cfgenv = append(ctx.cfgenv, k)
 	}
 	return nil
 }
 
 func (ctx *Context) init() error {
 	var err error
+	ctx.environ = os.Environ()
 	root := hwaf_root()
 	if root == "" {
 		//return ErrNoHwafRootDir
@@ -447,6 +490,14 @@ func (ctx *Context) init() error {
 	}
 	err = nil
 
+	// environment snippets of the workarea come last
+	err = ctx.load_env_from_envd()
+	if err != nil {
+		return fmt.Errorf("hwaf: problem loading environment from env.d:\n%v", err)
+	}
+
+	ctx.init_hermetic()
+
 	// load local config
 	if ctx.lcfg != nil {
 		ctx.variant, err = ctx.lcfg.String("hwaf-cfg", "variant")
@@ -479,6 +530,7 @@ func (ctx *Context) init() error {
 			},
 			string(os.PathListSeparator),
 		)
+		ctx.pytools = hwaftools
 		if pypath == "" {
 			pypath = hwaftools
 		} else {
@@ -527,6 +579,9 @@ func (ctx *Context) init() error {
 			ctx.variant = ctx.DefaultVariant()
 		}
 	}
+	if _, verr := ctx.ParseVariant(ctx.variant); verr != nil {
+		ctx.Warnf("%v\n", verr)
+	}
 
 	// init local pkg db
 	_, _ = ctx.Workarea()
@@ -543,6 +598,16 @@ func (ctx *Context) init() error {
 	return err
 }
 
+// global_cfg_files returns the list of global configuration files, in
+// increasing order of precedence.
+func (ctx *Context) global_cfg_files() []string {
+	return []string{
+		filepath.Join(string(os.PathSeparator), "etc", "hwaf.conf"),
+		filepath.Join(ctx.Root, "etc", "hwaf.conf"),
+		os.ExpandEnv(filepath.Join("${HOME}", ".config", "hwaf", "local.conf")),
+	}
+}
+
 func (ctx *Context) GlobalCfg() (*gocfg.Config, error) {
 	var err error
 	if ctx.gcfg != nil {
@@ -551,12 +616,7 @@ func (ctx *Context) GlobalCfg() (*gocfg.Config, error) {
 
 	gcfg := gocfg.NewDefault()
 	// aggregate all configurations. last one wins.
-	for _, fname := range []string{
-		filepath.Join(string(os.PathSeparator), "etc", "hwaf.conf"),
-		filepath.Join(ctx.Root, "etc", "hwaf.conf"),
-		filepath.Join("${HOME}", ".config", "hwaf", "local.conf"),
-	} {
-		fname = os.ExpandEnv(fname)
+	for _, fname := range ctx.global_cfg_files() {
 		if !path_exists(fname) {
 			continue
 		}
@@ -657,10 +717,16 @@ func (ctx *Context) Errorf(format string, args ...interface{}) (n int, err error
 	return ctx.msg.Errorf(format, args...)
 }
 
-// Command returns the os/exec.Cmd struct with some valid defaults
+// Command returns the os/exec.Cmd struct with some valid defaults.
+// In hermetic mode, waf is given the minimal environment (see SetHermetic).
 func (ctx *Context) Command(name string, arg ...string) *exec.Cmd {
 	// fmt.Printf(">>>> command [%s]... %#v\n", name, arg)
 	cmd := exec.Command(name, arg...)
+	if ctx.hermetic && name == ctx.waf {
+		env, dropped := ctx.HermeticEnviron()
+		ctx.hermetic_report(dropped)
+		cmd.Env = env
+	}
 	ctx.subcmds = append(ctx.subcmds, cmd)
 	return cmd
 }
@@ -0,0 +1,59 @@
+package hwaflib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWorkareaNotFound(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "hwaf-test-workarea-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	pwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(pwd)
+
+	for _, dir := range []string{"a", "b"} {
+		err = os.MkdirAll(filepath.Join(tmpdir, dir), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// git would look for a repository up to the root otherwise
+	defer os.Setenv("GIT_CEILING_DIRECTORIES", os.Getenv("GIT_CEILING_DIRECTORIES"))
+	os.Setenv("GIT_CEILING_DIRECTORIES", tmpdir)
+
+	ctx := &Context{}
+	err = os.Chdir(filepath.Join(tmpdir, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err1 := ctx.Workarea()
+	if err1 == nil {
+		t.Fatalf("expected an error outside of a workarea")
+	}
+	// the failure is not looked up again from the same directory...
+	_, err2 := ctx.Workarea()
+	if err2 != err1 {
+		t.Errorf("workarea looked up again (err=%v, then err=%v)", err1, err2)
+	}
+
+	// ...but it is from another one
+	err = os.Chdir(filepath.Join(tmpdir, "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err3 := ctx.Workarea()
+	if err3 == nil || err3 == err1 {
+		t.Errorf("workarea not looked up again from another directory (err=%v)", err3)
+	}
+}
+
+// EOF
@@ -0,0 +1,254 @@
+package hwaflib
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// EnvOp is an environment modification read from a snippet file of the
+// .hwaf/env.d directory of a workarea.
+//
+// Snippet files hold one operation per line:
+//
+//	# a comment
+//	set     NAME=value
+//	prepend NAME=/some/dir
+//	append  NAME=/some/dir
+//	remove  NAME=/some/dir
+//	unset   NAME
+//
+// NAME=value is a shorthand for 'set NAME=value' and 'remove NAME' unsets
+// NAME. prepend, append and remove operate on path lists. Values may refer
+// to other variables as $VAR or ${VAR} ($$ for a literal $) and may be
+// enclosed in single or double quotes.
+type EnvOp struct {
+	Action string // set, prepend, append, remove or unset
+	Key    string // name of the environment variable
+	Value  string // value, before variable expansion
+	File   string // snippet file the operation comes from
+	Line   int    // line of the operation in the snippet file
+}
+
+func (op EnvOp) String() string {
+	if op.Action == "unset" {
+		return fmt.Sprintf("%s:%d: unset %s", op.File, op.Line, op.Key)
+	}
+	return fmt.Sprintf("%s:%d: %s %s=%s", op.File, op.Line, op.Action, op.Key, op.Value)
+}
+
+// EnvSnippets returns the environment operations of the snippet files of
+// the .hwaf/env.d directory of the workarea, in order: files are read in
+// lexical order of their names (10-foo.env, 20-bar.env, ...), hidden and
+// backup files are ignored.
+func (ctx *Context) EnvSnippets() ([]EnvOp, error) {
+	wdir, err := ctx.Workarea()
+	if err != nil {
+		return nil, nil
+	}
+	dir := filepath.Join(wdir, ".hwaf", "env.d")
+	if !path_exists(dir) {
+		return nil, nil
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(files))
+	for _, fi := range files {
+		name := fi.Name()
+		if !fi.Mode().IsRegular() || strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ops := make([]EnvOp, 0)
+	for _, name := range names {
+		fops, err := parse_env_snippet(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, fops...)
+	}
+	return ops, nil
+}
+
+func parse_env_snippet(fname string) ([]EnvOp, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ops := make([]EnvOp, 0)
+	scan := bufio.NewScanner(f)
+	iline := 0
+	for scan.Scan() {
+		iline++
+		line := strings.TrimSpace(scan.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		op := EnvOp{Action: "set", File: fname, Line: iline}
+		if idx := strings.IndexAny(line, " \t"); idx > 0 && !strings.Contains(line[:idx], "=") {
+			op.Action = line[:idx]
+			line = strings.TrimSpace(line[idx+1:])
+		}
+
+		op.Key = line
+		hasval := false
+		if idx := strings.Index(line, "="); idx >= 0 {
+			op.Key = strings.TrimSpace(line[:idx])
+			op.Value = strings.TrimSpace(line[idx+1:])
+			hasval = true
+		}
+		if n := len(op.Value); n >= 2 && (op.Value[0] == '"' || op.Value[0] == '\'') && op.Value[n-1] == op.Value[0] {
+			op.Value = op.Value[1 : n-1]
+		}
+
+		if op.Key == "" || strings.ContainsAny(op.Key, " \t$") {
+			return nil, fmt.Errorf("%s:%d: invalid variable name [%s]", fname, iline, op.Key)
+		}
+
+		switch op.Action {
+		case "set", "prepend", "append":
+			if !hasval {
+				return nil, fmt.Errorf("%s:%d: %s needs a value (%s=...)", fname, iline, op.Action, op.Key)
+			}
+		case "remove":
+			if !hasval {
+				op.Action = "unset"
+			}
+		case "unset":
+			if hasval {
+				return nil, fmt.Errorf("%s:%d: unset does not take a value", fname, iline)
+			}
+		default:
+			return nil, fmt.Errorf(
+				"%s:%d: unknown operation [%s] (expected set, prepend, append, remove or unset)",
+				fname, iline, op.Action,
+			)
+		}
+		ops = append(ops, op)
+	}
+	err = scan.Err()
+	if err != nil {
+		return nil, err
+	}
+	return ops, nil
+}
+
+// ApplyEnvOps applies the environment operations ops to env.
+// Prepending or appending a path already in a path list moves it, so
+// applying the same prepend and append operations twice is harmless. (but
+// not set operations referring to the variable, eg: PATH=/my/bin:${PATH})
+// Empty paths are ignored: the prepend and append operations whose value
+// expands to nothing (eg: an unset variable) are skipped and returned.
+func ApplyEnvOps(env map[string]string, ops []EnvOp) []EnvOp {
+	sep := string(os.PathListSeparator)
+	skipped := []EnvOp{}
+	for _, op := range ops {
+		v := os.Expand(op.Value, func(k string) string {
+			if k == "$" {
+				return "$"
+			}
+			return env[k]
+		})
+
+		remove := func(list string, entries []string) []string {
+			out := []string{}
+			if list == "" {
+				return out
+			}
+			for _, elem := range strings.Split(list, sep) {
+				keep := true
+				for _, e := range entries {
+					if elem == e {
+						keep = false
+						break
+					}
+				}
+				if keep {
+					out = append(out, elem)
+				}
+			}
+			return out
+		}
+
+		entries := []string{}
+		for _, e := range strings.Split(v, sep) {
+			if e != "" {
+				entries = append(entries, e)
+			}
+		}
+		switch op.Action {
+		case "set":
+			env[op.Key] = v
+		case "unset":
+			delete(env, op.Key)
+		case "prepend", "append":
+			if len(entries) == 0 {
+				skipped = append(skipped, op)
+				continue
+			}
+		}
+		switch op.Action {
+		case "prepend":
+			env[op.Key] = strings.Join(append(entries, remove(env[op.Key], entries)...), sep)
+		case "append":
+			env[op.Key] = strings.Join(append(remove(env[op.Key], entries), entries...), sep)
+		case "remove":
+			if _, ok := env[op.Key]; ok {
+				env[op.Key] = strings.Join(remove(env[op.Key], entries), sep)
+			}
+		}
+	}
+	return skipped
+}
+
+// load_env_from_envd applies the .hwaf/env.d snippets of the workarea to
+// the environment of the hwaf process.
+func (ctx *Context) load_env_from_envd() error {
+	ops, err := ctx.EnvSnippets()
+	if err != nil {
+		return err
+	}
+	if len(ops) == 0 {
+		return nil
+	}
+
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		if idx := strings.Index(kv, "="); idx > 0 {
+			env[kv[:idx]] = kv[idx+1:]
+		}
+	}
+	for _, op := range ApplyEnvOps(env, ops) {
+		ctx.Warnf("%v: empty value, ignored\n", op)
+	}
+
+	for _, op := range ops {
+		ctx.Debugf("hwaf: env.d: %v\n", op)
+		ctx.cfgenv = append(ctx.cfgenv, op.Key)
+		v, ok := env[op.Key]
+		if !ok {
+			err = os.Unsetenv(op.Key)
+		} else {
+			err = os.Setenv(op.Key, v)
+		}
+		if err != nil {
+			ctx.Warnf("problem setting env. var [%s]: %v\n", op.Key, err)
+		}
+	}
+	return nil
+}
+
+// EOF
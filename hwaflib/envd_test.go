@@ -0,0 +1,99 @@
+package hwaflib
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestApplyEnvOps(t *testing.T) {
+	sep := string(os.PathListSeparator)
+	list := func(dirs ...string) string { return strings.Join(dirs, sep) }
+
+	for _, table := range []struct {
+		name    string
+		env     map[string]string
+		ops     []EnvOp
+		want    map[string]string
+		skipped int
+	}{
+		{
+			name: "set",
+			env:  map[string]string{"ROOT": "/opt"},
+			ops:  []EnvOp{{Action: "set", Key: "MANA", Value: "${ROOT}/mana$$"}},
+			want: map[string]string{"ROOT": "/opt", "MANA": "/opt/mana$"},
+		},
+		{
+			name: "set-empty",
+			env:  map[string]string{"MANA": "x"},
+			ops:  []EnvOp{{Action: "set", Key: "MANA", Value: "$UNSET"}},
+			want: map[string]string{"MANA": ""},
+		},
+		{
+			name: "unset",
+			env:  map[string]string{"MANA": "x"},
+			ops:  []EnvOp{{Action: "unset", Key: "MANA"}, {Action: "unset", Key: "NONE"}},
+			want: map[string]string{},
+		},
+		{
+			name: "prepend-append",
+			env:  map[string]string{"PATH": list("/usr/bin", "/bin")},
+			ops: []EnvOp{
+				{Action: "prepend", Key: "PATH", Value: list("/opt/bin", "/bin")},
+				{Action: "append", Key: "PATH", Value: "/usr/bin"},
+				{Action: "prepend", Key: "PATH", Value: "/opt/bin"},
+			},
+			want: map[string]string{"PATH": list("/opt/bin", "/bin", "/usr/bin")},
+		},
+		{
+			name: "prepend-new",
+			env:  map[string]string{},
+			ops:  []EnvOp{{Action: "prepend", Key: "MANA_PATH", Value: "/opt/mana"}},
+			want: map[string]string{"MANA_PATH": "/opt/mana"},
+		},
+		{
+			// the empty entries of the value are dropped
+			name: "prepend-partly-empty",
+			env:  map[string]string{"PATH": "/bin"},
+			ops:  []EnvOp{{Action: "prepend", Key: "PATH", Value: list("$UNSET", "/opt/bin", "")}},
+			want: map[string]string{"PATH": list("/opt/bin", "/bin")},
+		},
+		{
+			name: "prepend-empty",
+			env:  map[string]string{"PATH": "/bin"},
+			ops: []EnvOp{
+				{Action: "prepend", Key: "PATH", Value: "$UNSET"},
+				{Action: "append", Key: "PATH", Value: list("", "${UNSET}")},
+				{Action: "append", Key: "MANA_PATH", Value: ""},
+			},
+			want:    map[string]string{"PATH": "/bin"},
+			skipped: 3,
+		},
+		{
+			name: "remove",
+			env:  map[string]string{"PATH": list("/opt/bin", "/bin", "/opt/bin")},
+			ops: []EnvOp{
+				{Action: "remove", Key: "PATH", Value: list("/opt/bin", "")},
+				{Action: "remove", Key: "NONE", Value: "/bin"},
+			},
+			want: map[string]string{"PATH": "/bin"},
+		},
+		{
+			name: "remove-empty",
+			env:  map[string]string{"PATH": "/bin"},
+			ops:  []EnvOp{{Action: "remove", Key: "PATH", Value: "$UNSET"}},
+			want: map[string]string{"PATH": "/bin"},
+		},
+	} {
+		skipped := ApplyEnvOps(table.env, table.ops)
+		if !reflect.DeepEqual(table.env, table.want) {
+			t.Errorf("%s: got=%v want=%v", table.name, table.env, table.want)
+		}
+		if len(skipped) != table.skipped {
+			t.Errorf("%s: skipped %v, expected %d operations", table.name, skipped, table.skipped)
+		}
+	}
+}
+
+// EOF
@@ -0,0 +1,224 @@
+package hwaflib
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	gocfg "github.com/gonuts/config"
+)
+
+// hermetic_whitelist lists the inherited environment variables kept in
+// hermetic mode, on top of the HWAF_xxx ones and the [hwaf-env] section.
+var hermetic_whitelist = []string{
+	"HOME",
+	"LANG",
+	"LC_ALL",
+	"LC_CTYPE",
+	"LC_MESSAGES",
+	"LOGNAME",
+	"PATH",
+	"PWD",
+	"SHELL",
+	"TERM",
+	"TMPDIR",
+	"TZ",
+	"USER",
+}
+
+// hermetic_cfg returns the value of an option of the [hwaf-hermetic]
+// configuration section, the local configuration winning over the global one.
+func (ctx *Context) hermetic_cfg(option string) (string, bool) {
+	section := "hwaf-hermetic"
+	for _, cfg := range []*gocfg.Config{ctx.lcfg, ctx.gcfg} {
+		if cfg == nil || !cfg.HasOption(section, option) {
+			continue
+		}
+		v, err := cfg.String(section, option)
+		if err != nil {
+			continue
+		}
+		return v, true
+	}
+	return "", false
+}
+
+// init_hermetic enables hermetic mode if requested by the configuration
+// ([hwaf-hermetic] enabled = true) or by a parent hwaf process.
+func (ctx *Context) init_hermetic() {
+	if os.Getenv("HWAF_HERMETIC") == "1" {
+		// the parent hwaf process already reported the dropped variables
+		ctx.hermetic = true
+		ctx.hermetic_done = true
+		return
+	}
+	section := "hwaf-hermetic"
+	for _, cfg := range []*gocfg.Config{ctx.lcfg, ctx.gcfg} {
+		if cfg == nil || !cfg.HasOption(section, "enabled") {
+			continue
+		}
+		v, err := cfg.Bool(section, "enabled")
+		if err != nil {
+			ctx.Warnf("invalid [%s] enabled value: %v\n", section, err)
+			break
+		}
+		ctx.SetHermetic(v)
+		break
+	}
+}
+
+// Hermetic returns whether waf is run in a minimal environment
+func (ctx *Context) Hermetic() bool {
+	return ctx.hermetic
+}
+
+// SetHermetic enables or disables hermetic mode.
+// In hermetic mode, waf is run in a minimal environment made of a whitelist
+// of the inherited variables, the [hwaf-env] and [hwaf-toolchain]
+// configuration sections, instead of the full user environment.
+// The mode is inherited by the hwaf sub-processes.
+func (ctx *Context) SetHermetic(v bool) {
+	ctx.hermetic = v
+	if v {
+		os.Setenv("HWAF_HERMETIC", "1")
+	} else {
+		os.Unsetenv("HWAF_HERMETIC")
+	}
+}
+
+// Environ returns the environment waf is run with: the current one, or the
+// minimal one in hermetic mode.
+func (ctx *Context) Environ() []string {
+	if !ctx.hermetic {
+		return os.Environ()
+	}
+	env, _ := ctx.HermeticEnviron()
+	return env
+}
+
+// HermeticEnviron returns the minimal environment waf is run with in
+// hermetic mode, together with the sorted list of the inherited variables
+// which were dropped or reset.
+func (ctx *Context) HermeticEnviron() ([]string, []string) {
+	keep := make(map[string]bool, len(hermetic_whitelist)+len(ctx.cfgenv))
+	for _, k := range hermetic_whitelist {
+		keep[k] = true
+	}
+	for _, k := range ctx.cfgenv {
+		keep[k] = true
+	}
+	if v, ok := ctx.hermetic_cfg("keep"); ok {
+		for _, k := range strings.Fields(strings.Replace(v, ",", " ", -1)) {
+			keep[k] = true
+		}
+	}
+
+	env := make(map[string]string, len(keep))
+	for _, kv := range os.Environ() {
+		idx := strings.Index(kv, "=")
+		if idx <= 0 {
+			continue
+		}
+		k := kv[:idx]
+		if keep[k] || strings.HasPrefix(k, "HWAF_") {
+			env[k] = kv[idx+1:]
+		}
+	}
+
+	// only hwaf's own python tools (and the configured ones)
+	if !keep["PYTHONPATH"] {
+		env["PYTHONPATH"] = ctx.pytools
+	}
+
+	pathsep := string(os.PathListSeparator)
+	prepend := func(k, v string) {
+		if old := env[k]; old != "" {
+			v = v + pathsep + old
+		}
+		env[k] = v
+	}
+
+	if v, ok := ctx.hermetic_cfg("path"); ok {
+		env["PATH"] = os.ExpandEnv(v)
+	}
+
+	// toolchain
+	for _, cfg := range []*gocfg.Config{ctx.lcfg, ctx.gcfg} {
+		if cfg == nil {
+			continue
+		}
+		section := "hwaf-toolchain"
+		topdir, err := cfg.String(section, "path")
+		if err != nil || topdir == "" {
+			continue
+		}
+		topdir = os.ExpandEnv(topdir)
+		if bindir := filepath.Join(topdir, "bin"); path_exists(bindir) {
+			prepend("PATH", bindir)
+		}
+		libvar := "LD_LIBRARY_PATH"
+		if runtime.GOOS == "darwin" {
+			libvar = "DYLD_LIBRARY_PATH"
+		}
+		libdirs := []string{}
+		if libdir, err := cfg.String(section, "libdir"); err == nil && libdir != "" {
+			libdirs = append(libdirs, os.ExpandEnv(libdir))
+		} else {
+			for _, dir := range []string{"lib64", "lib"} {
+				dir = filepath.Join(topdir, dir)
+				if path_exists(dir) {
+					libdirs = append(libdirs, dir)
+				}
+			}
+		}
+		if len(libdirs) > 0 {
+			prepend(libvar, strings.Join(libdirs, pathsep))
+		}
+		break
+	}
+
+	dropped := make([]string, 0)
+	for _, kv := range ctx.environ {
+		idx := strings.Index(kv, "=")
+		if idx <= 0 {
+			continue
+		}
+		k := kv[:idx]
+		v, ok := env[k]
+		switch {
+		case !ok:
+			dropped = append(dropped, k)
+		case v != kv[idx+1:] && !keep[k] && !strings.HasPrefix(k, "HWAF_"):
+			// reset by hermetic mode (PYTHONPATH, ...)
+			dropped = append(dropped, k)
+		}
+	}
+	sort.Strings(dropped)
+
+	out := make([]string, 0, len(env))
+	for k, v := range env {
+		out = append(out, k+"="+v)
+	}
+	sort.Strings(out)
+	return out, dropped
+}
+
+// hermetic_report reports (once) the inherited variables dropped in
+// hermetic mode.
+func (ctx *Context) hermetic_report(dropped []string) {
+	if ctx.hermetic_done {
+		return
+	}
+	ctx.hermetic_done = true
+	if len(dropped) == 0 {
+		ctx.Infof("hermetic mode: no inherited environment variable dropped\n")
+		return
+	}
+	ctx.Infof("hermetic mode: dropped %d inherited environment variable(s): %s\n",
+		len(dropped), strings.Join(dropped, " "),
+	)
+}
+
+// EOF
@@ -0,0 +1,213 @@
+package hwaflib
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/hwaf/hwaf/platform"
+)
+
+// probes_t holds the results of the platform and toolchain probing.
+// As these are rather expensive (uname, compilers --version, ...) they are
+// cached in the workarea between hwaf invocations and invalidated whenever
+// one of the files or environment variables they depend on is modified.
+type probes_t struct {
+	Version  string             `json:"version"` // hwaf version which wrote the cache
+	Host     string             `json:"host"`    // workareas may be shared b/w machines (AFS, NFS, ...)
+	Env      map[string]string  `json:"env"`     // environment variables the probes depend on
+	Files    map[string]int64   `json:"files"`   // mtimes of the files the probes depend on (-1: missing)
+	Platform *platform.Platform `json:"platform,omitempty"`
+	PlatErr  string             `json:"platform_error,omitempty"`
+	Comps    []Compiler         `json:"compilers"`
+	Variant  string             `json:"default_variant,omitempty"`
+}
+
+// probe_envvars lists the environment variables the probes depend on.
+var probe_envvars = []string{"CC", "CXX", "PATH"}
+
+func (ctx *Context) probes_version() string {
+	return ctx.Version() + "-" + ctx.Revision()
+}
+
+// probes_fname returns the name of the file holding the probes cache, or
+// an empty string if there is no (initialized) workarea.
+// Caching can be disabled by setting HWAF_NO_PROBE_CACHE=1.
+func (ctx *Context) probes_fname() string {
+	if os.Getenv("HWAF_NO_PROBE_CACHE") == "1" {
+		return ""
+	}
+	wdir, err := ctx.Workarea()
+	if err != nil || !path_exists(filepath.Join(wdir, ".hwaf")) {
+		return ""
+	}
+	return filepath.Join(wdir, ".hwaf", "cache", "probes.json")
+}
+
+// probes returns the current probes cache, loading it from the workarea if
+// it is still valid.
+func (ctx *Context) probes() *probes_t {
+	if ctx.probe_cache != nil {
+		return ctx.probe_cache
+	}
+	ctx.probe_cache = &probes_t{}
+
+	fname := ctx.probes_fname()
+	if fname == "" {
+		return ctx.probe_cache
+	}
+
+	buf, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return ctx.probe_cache
+	}
+	var cache probes_t
+	err = json.Unmarshal(buf, &cache)
+	if err != nil {
+		ctx.Debugf("hwaf: invalid probes cache [%s]: %v\n", fname, err)
+		return ctx.probe_cache
+	}
+	if !ctx.probes_valid(&cache) {
+		ctx.Debugf("hwaf: stale probes cache [%s]\n", fname)
+		return ctx.probe_cache
+	}
+	ctx.probe_cache = &cache
+	return ctx.probe_cache
+}
+
+func (ctx *Context) probes_valid(cache *probes_t) bool {
+	if cache.Version != ctx.probes_version() {
+		return false
+	}
+	host, _ := os.Hostname()
+	if cache.Host != host {
+		return false
+	}
+	for _, k := range probe_envvars {
+		if cache.Env[k] != os.Getenv(k) {
+			return false
+		}
+	}
+	for fname, mtime := range cache.Files {
+		if probe_mtime(fname) != mtime {
+			return false
+		}
+	}
+	return true
+}
+
+// probe_files returns the list of files the probes depend on.
+func (ctx *Context) probe_files(cache *probes_t) []string {
+	files := []string{
+		"/etc/os-release",
+		"/usr/lib/os-release",
+		"/etc/lsb-release",
+	}
+	for _, pattern := range []string{"/etc/*-release", "/etc/*_version"} {
+		matches, err := filepath.Glob(pattern)
+		if err == nil {
+			files = append(files, matches...)
+		}
+	}
+
+	// configuration files ([hwaf-dists], [hwaf-toolchain], ...)
+	files = append(files, ctx.global_cfg_files()...)
+	if ctx.workarea != nil {
+		files = append(files, filepath.Join(*ctx.workarea, "local.conf"))
+	}
+
+	// new compilers may have been installed
+	files = append(files, filepath.SplitList(os.Getenv("PATH"))...)
+	for _, comp := range cache.Comps {
+		exe, err := exec.LookPath(strings.Fields(comp.Cmd)[0])
+		if err == nil {
+			files = append(files, exe)
+		}
+	}
+	return files
+}
+
+func probe_mtime(fname string) int64 {
+	fi, err := os.Stat(fname)
+	if err != nil {
+		return -1
+	}
+	return fi.ModTime().UnixNano()
+}
+
+// save_probes writes the current probes cache into the workarea.
+func (ctx *Context) save_probes() {
+	fname := ctx.probes_fname()
+	if fname == "" {
+		return
+	}
+	cache := ctx.probes()
+	cache.Version = ctx.probes_version()
+	cache.Host, _ = os.Hostname()
+	cache.Env = make(map[string]string, len(probe_envvars))
+	for _, k := range probe_envvars {
+		cache.Env[k] = os.Getenv(k)
+	}
+	cache.Files = make(map[string]int64)
+	for _, f := range ctx.probe_files(cache) {
+		cache.Files[f] = probe_mtime(f)
+	}
+
+	buf, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		ctx.Debugf("hwaf: could not encode probes cache: %v\n", err)
+		return
+	}
+
+	err = os.MkdirAll(filepath.Dir(fname), 0755)
+	if err != nil {
+		ctx.Debugf("hwaf: could not create probes cache directory: %v\n", err)
+		return
+	}
+
+	// write atomically: concurrent hwaf processes may share the workarea.
+	tmp, err := ioutil.TempFile(filepath.Dir(fname), "probes-")
+	if err != nil {
+		ctx.Debugf("hwaf: could not create probes cache: %v\n", err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(buf)
+	if err == nil {
+		err = tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), fname)
+	}
+	if err != nil {
+		ctx.Debugf("hwaf: could not write probes cache [%s]: %v\n", fname, err)
+	}
+}
+
+// PlatformInfos returns the informations about the current platform,
+// possibly from the workarea cache.
+func (ctx *Context) PlatformInfos() (platform.Platform, error) {
+	cache := ctx.probes()
+	if cache.Platform != nil {
+		var err error
+		if cache.PlatErr != "" {
+			err = errors.New(cache.PlatErr)
+		}
+		return *cache.Platform, err
+	}
+
+	pinfos, err := platform.Infos()
+	cache.Platform = &pinfos
+	cache.PlatErr = ""
+	if err != nil {
+		cache.PlatErr = err.Error()
+	}
+	ctx.save_probes()
+	return pinfos, err
+}
+
+// EOF
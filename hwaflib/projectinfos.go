@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"path/filepath"
 	"sort"
+	"strconv"
+	"strings"
 
 	gocfg "github.com/gonuts/config"
 )
@@ -42,6 +44,253 @@ func (pi *ProjectInfos) Get(key string) (string, error) {
 	return s, err
 }
 
+// GetList returns the value of a list-valued key (eg: HWAF_RUNTIME_ENVVARS)
+// as a list of strings.
+func (pi *ProjectInfos) GetList(key string) ([]string, error) {
+	v, err := pi.GetValue(key)
+	if err != nil {
+		return nil, err
+	}
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("hwaf: [%s] is not a list (%v)", key, v)
+	}
+	strs := make([]string, 0, len(list))
+	for _, elem := range list {
+		str, ok := elem.(string)
+		if !ok {
+			return nil, fmt.Errorf("hwaf: [%s] is not a list of strings (%v)", key, v)
+		}
+		strs = append(strs, str)
+	}
+	return strs, nil
+}
+
+// GetValue returns the value of a key, decoded from its python
+// representation: strings are returned as string, lists and tuples as
+// []interface{}, dicts as map[string]interface{} and None as nil.
+// Other values (numbers, booleans, ...) are returned verbatim as string.
+func (pi *ProjectInfos) GetValue(key string) (interface{}, error) {
+	s, err := pi.cfg.RawString("DEFAULT", key)
+	if err != nil {
+		return nil, err
+	}
+	v, rest, err := py_parse(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("hwaf: could not decode [%s]: %v", key, err)
+	}
+	if strings.TrimSpace(rest) != "" {
+		return nil, fmt.Errorf("hwaf: could not decode [%s]: trailing data %q", key, rest)
+	}
+	return v, nil
+}
+
+// py_parse decodes the python literal at the beginning of s and returns it
+// together with the rest of the input.
+func py_parse(s string) (interface{}, string, error) {
+	s = strings.TrimLeft(s, " \t\n")
+	if s == "" {
+		return nil, s, fmt.Errorf("unexpected end of input")
+	}
+	switch s[0] {
+	case '[', '(':
+		end := byte(']')
+		if s[0] == '(' {
+			end = ')'
+		}
+		list := make([]interface{}, 0)
+		s = strings.TrimLeft(s[1:], " \t\n")
+		for {
+			if s == "" {
+				return nil, s, fmt.Errorf("unterminated list")
+			}
+			if s[0] == end {
+				return list, s[1:], nil
+			}
+			var (
+				v   interface{}
+				err error
+			)
+			v, s, err = py_parse(s)
+			if err != nil {
+				return nil, s, err
+			}
+			list = append(list, v)
+			s, err = py_parse_sep(s, end)
+			if err != nil {
+				return nil, s, err
+			}
+		}
+	case '{':
+		dict := make(map[string]interface{})
+		s = strings.TrimLeft(s[1:], " \t\n")
+		for {
+			if s == "" {
+				return nil, s, fmt.Errorf("unterminated dict")
+			}
+			if s[0] == '}' {
+				return dict, s[1:], nil
+			}
+			var (
+				k, v interface{}
+				err  error
+			)
+			k, s, err = py_parse(s)
+			if err != nil {
+				return nil, s, err
+			}
+			s = strings.TrimLeft(s, " \t\n")
+			if s == "" || s[0] != ':' {
+				return nil, s, fmt.Errorf("missing ':' in dict")
+			}
+			v, s, err = py_parse(s[1:])
+			if err != nil {
+				return nil, s, err
+			}
+			dict[fmt.Sprintf("%v", k)] = v
+			s, err = py_parse_sep(s, '}')
+			if err != nil {
+				return nil, s, err
+			}
+		}
+	case 'u', 'b', '\'', '"':
+		if s[0] == 'u' || s[0] == 'b' {
+			if len(s) < 2 || (s[1] != '\'' && s[1] != '"') {
+				break
+			}
+			s = s[1:]
+		}
+		return py_parse_str(s)
+	}
+	idx := strings.IndexAny(s, ",:])} \t\n")
+	if idx < 0 {
+		idx = len(s)
+	}
+	tok := strings.TrimSpace(s[:idx])
+	if tok == "" {
+		return nil, s, fmt.Errorf("unexpected %q", s[:1])
+	}
+	if tok == "None" {
+		return nil, s[idx:], nil
+	}
+	return tok, s[idx:], nil
+}
+
+// py_parse_sep consumes the separator following an element of a list,
+// tuple or dict: a ',' or the closing delimiter end (which is left in the
+// returned input).
+func py_parse_sep(s string, end byte) (string, error) {
+	s = strings.TrimLeft(s, " \t\n")
+	switch {
+	case s == "" || s[0] == end:
+		return s, nil
+	case s[0] == ',':
+		return strings.TrimLeft(s[1:], " \t\n"), nil
+	}
+	return s, fmt.Errorf("expected ',' or %q, got %q", end, s[:1])
+}
+
+// py_parse_str decodes the python string literal at the beginning of s.
+func py_parse_str(s string) (interface{}, string, error) {
+	quote := s[0]
+	buf := make([]byte, 0, len(s))
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == quote:
+			return string(buf), s[i+1:], nil
+		case c == '\\' && i+1 < len(s):
+			i++
+			switch c = s[i]; c {
+			case 'n':
+				buf = append(buf, '\n')
+			case 't':
+				buf = append(buf, '\t')
+			case 'r':
+				buf = append(buf, '\r')
+			case 'x', 'u', 'U':
+				n := map[byte]int{'x': 2, 'u': 4, 'U': 8}[c]
+				if i+n >= len(s) {
+					return nil, s, fmt.Errorf("invalid escape sequence")
+				}
+				r, err := strconv.ParseUint(s[i+1:i+1+n], 16, 32)
+				if err != nil {
+					return nil, s, err
+				}
+				if c == 'x' {
+					buf = append(buf, byte(r))
+				} else {
+					buf = append(buf, string(rune(r))...)
+				}
+				i += n
+			default:
+				buf = append(buf, c)
+			}
+		default:
+			buf = append(buf, c)
+		}
+	}
+	return nil, s, fmt.Errorf("unterminated string")
+}
+
+// EnvSpyEntry is a modification of the environment recorded while
+// configuring the project (see hwaf-spy-env.py)
+type EnvSpyEntry struct {
+	Action string      // low-level modification (set, append_value, prepend_value, append_unique)
+	Stmt   string      // statement responsible for the modification (eg: path_prepend), if any
+	Key    string      // name of the modified variable
+	Old    interface{} // value before the modification
+	New    interface{} // value after the modification
+	Value  interface{} // value passed to the modification
+	File   string      // file where the modification happened
+	Line   int         // line where the modification happened
+	Code   string      // source code of the modification
+}
+
+// EnvSpy returns the ordered list of environment modifications recorded
+// while configuring the project.
+func (pi *ProjectInfos) EnvSpy() ([]EnvSpyEntry, error) {
+	v, err := pi.GetValue("HWAF_ENV_SPY")
+	if err != nil {
+		return nil, err
+	}
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("hwaf: HWAF_ENV_SPY is not a list")
+	}
+
+	str := func(v interface{}) string {
+		if v == nil {
+			return ""
+		}
+		return fmt.Sprintf("%v", v)
+	}
+
+	entries := make([]EnvSpyEntry, 0, len(list))
+	for _, elem := range list {
+		dict, ok := elem.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("hwaf: invalid HWAF_ENV_SPY entry (%v)", elem)
+		}
+		entry := EnvSpyEntry{
+			Action: str(dict["action"]),
+			Stmt:   str(dict["stmt"]),
+			Key:    str(dict["key"]),
+			Old:    dict["old"],
+			New:    dict["new"],
+			Value:  dict["val"],
+		}
+		// who: (filename, lineno, function, code)
+		if who, ok := dict["who"].([]interface{}); ok && len(who) == 4 {
+			entry.File = str(who[0])
+			entry.Line, _ = strconv.Atoi(str(who[1]))
+			entry.Code = str(who[3])
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
 func (pi *ProjectInfos) Keys() []string {
 
 	opts, err := pi.cfg.Options("DEFAULT")
@@ -0,0 +1,193 @@
+package hwaflib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPyParse(t *testing.T) {
+	type list = []interface{}
+	type dict = map[string]interface{}
+
+	for _, table := range []struct {
+		in   string
+		want interface{}
+		rest string
+	}{
+		{`'abc'`, "abc", ""},
+		{`"abc" rest`, "abc", " rest"},
+		{`''`, "", ""},
+		{`'it\'s'`, "it's", ""},
+		{`"say \"hi\""`, `say "hi"`, ""},
+		{`'a\nb\tc\\d'`, "a\nb\tc\\d", ""},
+		{`'\x41é\U0001F600'`, "Aé\U0001F600", ""},
+		{`'a"b'`, `a"b`, ""},
+		{`u'unicode'`, "unicode", ""},
+		{`b"bytes"`, "bytes", ""},
+		{`unicorn`, "unicorn", ""},
+		{`None`, nil, ""},
+		{`42`, "42", ""},
+		{`True`, "True", ""},
+		{`[]`, list{}, ""},
+		{`()`, list{}, ""},
+		{`['a', 'b']`, list{"a", "b"}, ""},
+		{`['a', 'b',]`, list{"a", "b"}, ""},
+		{`('a',)`, list{"a"}, ""},
+		{`[ 'a' ,None , 1 ]`, list{"a", nil, "1"}, ""},
+		{`[['a', ('b', u'c')], []]`, list{list{"a", list{"b", "c"}}, list{}}, ""},
+		{`{}`, dict{}, ""},
+		{`{'a': 'x', 'b': None,}`, dict{"a": "x", "b": nil}, ""},
+		{`{'a': {'b': ['c', 'd']}, 1: ('e',)}`, dict{"a": dict{"b": list{"c", "d"}}, "1": list{"e"}}, ""},
+		{`[{'k': 'v'}, {}] tail`, list{dict{"k": "v"}, dict{}}, " tail"},
+	} {
+		got, rest, err := py_parse(table.in)
+		if err != nil {
+			t.Errorf("py_parse(%q): %v", table.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, table.want) || rest != table.rest {
+			t.Errorf("py_parse(%q):\ngot=  %#v (rest=%q)\nwant= %#v (rest=%q)",
+				table.in, got, rest, table.want, table.rest,
+			)
+		}
+	}
+}
+
+func TestPyParseInvalid(t *testing.T) {
+	for _, in := range []string{
+		``,
+		`   `,
+		`}`,
+		`]`,
+		`,`,
+		`[1}`,
+		`(1]`,
+		`{'a': 1]`,
+		`[`,
+		`['a'`,
+		`['a',`,
+		`[1 2]`,
+		`['a' 'b']`,
+		`[,]`,
+		`[1,,2]`,
+		`{'a' 'b'}`,
+		`{'a': 1 'b': 2}`,
+		`{'a':}`,
+		`{'a'`,
+		`'abc`,
+		`"abc'`,
+		`'\x4'`,
+		`'\u12'`,
+		`'\xzz'`,
+	} {
+		done := make(chan error, 1)
+		go func() {
+			_, _, err := py_parse(in)
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if err == nil {
+				t.Errorf("py_parse(%q): expected an error", in)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("py_parse(%q): did not return", in)
+		}
+	}
+}
+
+func TestProjectInfosValues(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "hwaf-test-pinfos-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	fname := filepath.Join(tmpdir, "project.info")
+	err = ioutil.WriteFile(fname, []byte(strings.Join([]string{
+		`HWAF_PROJECT_NAME = 'mana-core'`,
+		`HWAF_RUNTIME_ENVVARS = ['PATH', u'LD_LIBRARY_PATH', "MANA_DATA"]`,
+		`HWAF_EMPTY = []`,
+		`HWAF_MIXED = ['a', ['b']]`,
+		`HWAF_BAD = [1}`,
+		`HWAF_TRAILING = ['a'] ['b']`,
+		`HWAF_ENV_SPY = [{'action': 'set', 'key': 'PATH', 'old': [], 'new': ['/opt/bin'], 'val': ['/opt/bin'], 'who': ('/ws/wscript', 12, 'configure', "ctx.env.PATH = ['/opt/bin']"), 'stmt': 'path_prepend'}, {'action': 'append_value', 'key': 'CXXFLAGS', 'old': None, 'new': ['-O2'], 'val': '-O2', 'who': None}]`,
+		"",
+	}, "\n")), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinfos, err := NewProjectInfos(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name, err := pinfos.Get("HWAF_PROJECT_NAME")
+	if err != nil || name != "mana-core" {
+		t.Errorf("Get(HWAF_PROJECT_NAME): got=%q (err=%v)", name, err)
+	}
+
+	for _, table := range []struct {
+		key  string
+		want []string
+		err  string
+	}{
+		{key: "HWAF_RUNTIME_ENVVARS", want: []string{"PATH", "LD_LIBRARY_PATH", "MANA_DATA"}},
+		{key: "HWAF_EMPTY", want: []string{}},
+		{key: "HWAF_PROJECT_NAME", err: "is not a list"},
+		{key: "HWAF_MIXED", err: "not a list of strings"},
+		{key: "HWAF_BAD", err: "could not decode"},
+		{key: "HWAF_TRAILING", err: "trailing data"},
+		{key: "HWAF_MISSING", err: "HWAF_MISSING"},
+	} {
+		got, err := pinfos.GetList(table.key)
+		if table.err != "" {
+			if err == nil || !strings.Contains(err.Error(), table.err) {
+				t.Errorf("GetList(%s): got err=%v, want %q", table.key, err, table.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, table.want) {
+			t.Errorf("GetList(%s): got=%q (err=%v) want=%q", table.key, got, err, table.want)
+		}
+	}
+
+	spy, err := pinfos.EnvSpy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []EnvSpyEntry{
+		{
+			Action: "set",
+			Stmt:   "path_prepend",
+			Key:    "PATH",
+			Old:    []interface{}{},
+			New:    []interface{}{"/opt/bin"},
+			Value:  []interface{}{"/opt/bin"},
+			File:   "/ws/wscript",
+			Line:   12,
+			Code:   "ctx.env.PATH = ['/opt/bin']",
+		},
+		{
+			Action: "append_value",
+			Key:    "CXXFLAGS",
+			New:    []interface{}{"-O2"},
+			Value:  "-O2",
+		},
+	}
+	if !reflect.DeepEqual(spy, want) {
+		t.Errorf("EnvSpy:\ngot=  %#v\nwant= %#v", spy, want)
+	}
+	for _, key := range pinfos.Keys() {
+		if key == "HWAF_ENV_SPY" {
+			t.Errorf("Keys: HWAF_ENV_SPY should not be listed")
+		}
+	}
+}
+
+// EOF
@@ -0,0 +1,201 @@
+package hwaflib
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	gocfg "github.com/gonuts/config"
+)
+
+// Variant is a build configuration quadruplet, <arch>-<os>-<compiler>-<type>
+// (eg: x86_64-slc6-gcc47-opt)
+type Variant struct {
+	Arch     string // architecture (eg: x86_64, i686)
+	Os       string // operating system (eg: slc6, ubuntu1404, darwin109)
+	Compiler string // compiler (eg: gcc47, clang34)
+	Type     string // build type (eg: opt, dbg)
+}
+
+// DefaultBuildTypes is the list of build types known to hwaf.
+// More can be declared via the 'build-types' option of the [hwaf-cfg]
+// configuration section.
+var DefaultBuildTypes = []string{"opt", "dbg"}
+
+var (
+	re_variant_arch = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+	re_variant_os   = regexp.MustCompile(`^[a-z][a-z0-9.]*$`)
+	re_variant_comp = regexp.MustCompile(`^[a-z][a-z+]*[0-9]*$`)
+	re_variant_type = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+	// enterprise linux rebuilds are binary compatible with each other
+	re_variant_el = regexp.MustCompile(`^(?:slc|sl|centos|rhel|rh|alma|rocky|ol|el)([0-9]+)$`)
+)
+
+// VariantArch returns the variant architecture component corresponding to
+// a GOARCH value (eg: amd64 -> x86_64.)
+// Unknown architectures are returned as-is, with an error.
+func VariantArch(goarch string) (string, error) {
+	switch goarch {
+	case "amd64":
+		return "x86_64", nil
+	case "386":
+		return "i686", nil
+	case "arm64":
+		return "aarch64", nil
+	case "ppc64le":
+		return "ppc64le", nil
+	case "arm":
+		return "armv7l", nil
+	case "s390x":
+		return "s390x", nil
+	}
+	return goarch, fmt.Errorf("hwaf: unknown architecture [%s]", goarch)
+}
+
+// ParseVariant parses and validates a variant string.
+// The build type must be one of DefaultBuildTypes or bldtypes.
+func ParseVariant(s string, bldtypes ...string) (Variant, error) {
+	var v Variant
+	toks := strings.Split(s, "-")
+	if len(toks) != 4 {
+		return v, fmt.Errorf(
+			"hwaf: invalid variant %q (expected <arch>-<os>-<compiler>-<type>)",
+			s,
+		)
+	}
+	v = Variant{
+		Arch:     toks[0],
+		Os:       toks[1],
+		Compiler: toks[2],
+		Type:     toks[3],
+	}
+	return v, v.Validate(bldtypes...)
+}
+
+// ParseVariantLax parses and validates a variant string, accepting any
+// well-formed build type.
+// It is meant for the variants of already built projects (eg: upstream
+// projects), which may use build types unknown to this installation.
+func ParseVariantLax(s string) (Variant, error) {
+	toks := strings.Split(s, "-")
+	return ParseVariant(s, toks[len(toks)-1])
+}
+
+func (v Variant) String() string {
+	return strings.Join([]string{v.Arch, v.Os, v.Compiler, v.Type}, "-")
+}
+
+// Validate checks each component of the variant.
+// The build type must be one of DefaultBuildTypes or bldtypes.
+func (v Variant) Validate(bldtypes ...string) error {
+	for _, c := range []struct {
+		name  string
+		value string
+		re    *regexp.Regexp
+	}{
+		{"architecture", v.Arch, re_variant_arch},
+		{"os", v.Os, re_variant_os},
+		{"compiler", v.Compiler, re_variant_comp},
+		{"build type", v.Type, re_variant_type},
+	} {
+		if !c.re.MatchString(c.value) {
+			return fmt.Errorf("hwaf: invalid %s %q in variant %q", c.name, c.value, v)
+		}
+	}
+
+	types := append(append([]string{}, DefaultBuildTypes...), bldtypes...)
+	for _, t := range types {
+		if t == v.Type {
+			return nil
+		}
+	}
+	return fmt.Errorf(
+		"hwaf: unknown build type %q in variant %q (known build types: %v)",
+		v.Type, v, types,
+	)
+}
+
+// OsClass returns the binary-compatibility class of the variant's OS.
+// classes maps an OS to its class and takes precedence over the default
+// rules (eg: slc6, sl6, centos6 and rhel6 all belong to the el6 class.)
+func (v Variant) OsClass(classes map[string]string) string {
+	if class, ok := classes[v.Os]; ok {
+		return class
+	}
+	if m := re_variant_el.FindStringSubmatch(v.Os); m != nil {
+		return "el" + m[1]
+	}
+	return v.Os
+}
+
+// Compatible returns whether binaries built for the variant o can be used
+// for the variant v (eg: x86_64-slc6-gcc47-opt binaries are usable on a
+// x86_64-centos6-gcc47-opt host.)
+// classes is the OS compatibility table passed to OsClass.
+func (v Variant) Compatible(o Variant, classes map[string]string) bool {
+	return v.Arch == o.Arch &&
+		v.Compiler == o.Compiler &&
+		v.Type == o.Type &&
+		v.OsClass(classes) == o.OsClass(classes)
+}
+
+// BuildTypes returns the list of build types known to this context:
+// DefaultBuildTypes and the ones listed in the 'build-types' option of the
+// [hwaf-cfg] section of the global and local configuration files.
+func (ctx *Context) BuildTypes() []string {
+	types := append([]string{}, DefaultBuildTypes...)
+	for _, cfg := range []*gocfg.Config{ctx.gcfg, ctx.lcfg} {
+		if cfg == nil || !cfg.HasOption("hwaf-cfg", "build-types") {
+			continue
+		}
+		v, err := cfg.String("hwaf-cfg", "build-types")
+		if err != nil {
+			continue
+		}
+		types = append(types, strings.Fields(strings.Replace(v, ",", " ", -1))...)
+	}
+	return types
+}
+
+// ParseVariant parses and validates a variant string, accepting the build
+// types known to this context.
+func (ctx *Context) ParseVariant(s string) (Variant, error) {
+	return ParseVariant(s, ctx.BuildTypes()...)
+}
+
+// OsClasses returns the OS compatibility table from the [hwaf-os-compat]
+// section of the global and local configuration files, eg:
+//
+//	[hwaf-os-compat]
+//	cc7 = el7
+//	debian8 = ubuntu1404
+func (ctx *Context) OsClasses() map[string]string {
+	classes := make(map[string]string)
+	section := "hwaf-os-compat"
+	for _, cfg := range []*gocfg.Config{ctx.gcfg, ctx.lcfg} {
+		if cfg == nil || !cfg.HasSection(section) {
+			continue
+		}
+		options, err := cfg.Options(section)
+		if err != nil {
+			continue
+		}
+		for _, k := range options {
+			v, err := cfg.String(section, k)
+			if err != nil {
+				continue
+			}
+			classes[k] = strings.TrimSpace(v)
+		}
+	}
+	return classes
+}
+
+// CompatibleVariant returns whether binaries built for variant bin can be
+// used for variant host, using the OS compatibility table of this context.
+func (ctx *Context) CompatibleVariant(host, bin Variant) bool {
+	return host.Compatible(bin, ctx.OsClasses())
+}
+
+// EOF
@@ -0,0 +1,51 @@
+package hwaflib
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseVariant(t *testing.T) {
+	for _, table := range []struct {
+		variant  string
+		bldtypes []string
+		strict   string // expected error of ParseVariant
+		lax      string // expected error of ParseVariantLax
+	}{
+		{variant: "x86_64-slc6-gcc47-opt"},
+		{variant: "x86_64-slc6-gcc47-dbg"},
+		{variant: "x86_64-slc6-gcc47-prof", bldtypes: []string{"prof"}},
+		{variant: "x86_64-slc6-gcc47-prof", strict: "unknown build type"},
+		{variant: "x86_64-slc6-gcc47", strict: "invalid variant", lax: "invalid variant"},
+		{variant: "x86_64-slc6-gcc47-opt-x", strict: "invalid variant", lax: "invalid variant"},
+		{variant: "x86_64-SLC6-gcc47-opt", strict: "invalid os", lax: "invalid os"},
+		{variant: "x86_64-slc6-gcc47-Opt", strict: "invalid build type", lax: "invalid build type"},
+		{variant: "", strict: "invalid variant", lax: "invalid variant"},
+	} {
+		for _, c := range []struct {
+			name  string
+			parse func(string) (Variant, error)
+			err   string
+		}{
+			{"ParseVariant", func(s string) (Variant, error) { return ParseVariant(s, table.bldtypes...) }, table.strict},
+			{"ParseVariantLax", ParseVariantLax, table.lax},
+		} {
+			v, err := c.parse(table.variant)
+			if c.err != "" {
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Errorf("%s(%q): got err=%v, want %q", c.name, table.variant, err, c.err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s(%q): %v", c.name, table.variant, err)
+				continue
+			}
+			if v.String() != table.variant {
+				t.Errorf("%s(%q): got=%q", c.name, table.variant, v)
+			}
+		}
+	}
+}
+
+// EOF
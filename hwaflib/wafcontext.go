@@ -116,15 +116,9 @@ func waf_gen_wscript_from_yml(fname string) error {
 		return err
 	}
 
-	data := make(map[string]interface{})
-	err = yaml.Unmarshal(buf, data)
-	if err != nil {
-		return fmt.Errorf("error decoding file [%s]: %v", hscript, err)
-	}
-
-	wscript, err := waf_get_wscript(data)
+	wscript, err := waf_decode_hscript(hscript, buf)
 	if err != nil {
-		return fmt.Errorf("error parsing file [%s]:\n%v", hscript, err)
+		return err
 	}
 
 	enc := hlib.NewHscriptPyEncoder(f)
@@ -140,6 +134,29 @@ func waf_gen_wscript_from_yml(fname string) error {
 	return err
 }
 
+// LoadHscript decodes the hscript.yml file fname
+func LoadHscript(fname string) (*hlib.Wscript_t, error) {
+	buf, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	return waf_decode_hscript(fname, buf)
+}
+
+func waf_decode_hscript(fname string, buf []byte) (*hlib.Wscript_t, error) {
+	data := make(map[string]interface{})
+	err := yaml.Unmarshal(buf, data)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding file [%s]: %v", fname, err)
+	}
+
+	wscript, err := waf_get_wscript(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing file [%s]:\n%v", fname, err)
+	}
+	return wscript, nil
+}
+
 func waf_get_yaml_map(data interface{}) map[string]interface{} {
 	out := make(map[string]interface{})
 	vdata := data.(map[interface{}]interface{})
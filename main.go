@@ -17,6 +17,7 @@ hwaf manages hep-waf based applications and libraries.
  bdist-rpm
  bdist-dmg
  bdist-deb
+ bdist-oci
 
  run
 
@@ -64,9 +65,14 @@ func init() {
 			hwaf_make_cmd_waf_bdist(),
 			hwaf_make_cmd_waf_bdist_deb(),
 			hwaf_make_cmd_waf_bdist_dmg(),
+			hwaf_make_cmd_waf_bdist_oci(),
 			hwaf_make_cmd_waf_bdist_rpm(),
 
 			hwaf_make_cmd_dump_env(),
+			hwaf_make_cmd_alias(),
+			hwaf_make_cmd_env(),
+			hwaf_make_cmd_export(),
+			hwaf_make_cmd_keys(),
 
 			hwaf_make_cmd_git(),
 			hwaf_make_cmd_pkg(),
@@ -76,6 +82,7 @@ func init() {
 		},
 		Flag: *flag.NewFlagSet("hwaf", flag.ExitOnError),
 	}
+	g_cmd.Flag.Bool("hermetic", false, "run waf in a minimal environment (see [hwaf-hermetic] configuration section)")
 }
 
 func main() {
@@ -84,9 +91,15 @@ func main() {
 	pwd, err := os.Getwd()
 	handle_err(err)
 
+	// the command is the first argument after the global flags
+	// (eg: hwaf -hermetic setup)
+	err = g_cmd.Flag.Parse(os.Args[1:])
+	handle_err(err)
+	args := g_cmd.Flag.Args()
+
 	wdir := pwd
-	if len(os.Args) > 1 {
-		switch os.Args[1] {
+	if len(args) > 0 {
+		switch args[0] {
 		case "init", "setup", "asetup":
 			// these are supposed to *create* the .hwaf directory...
 
@@ -112,9 +125,9 @@ func main() {
 		handle_err(err)
 	}
 
-	if len(os.Args) == 1 {
+	if len(args) == 0 {
 		if path_exists("wscript") {
-			os.Args = append(os.Args, "waf", "build+install")
+			args = []string{"waf", "build+install"}
 		} else {
 			g_ctx.Errorf("'hwaf' needs a command to run (or be executed from a directory containing a wscript file.)\n")
 			g_ctx.Errorf("run 'hwaf help' for informations\n")
@@ -122,10 +135,10 @@ func main() {
 		}
 	}
 
-	err = g_cmd.Flag.Parse(os.Args[1:])
-	handle_err(err)
+	if g_cmd.Flag.Lookup("hermetic").Value.Get().(bool) {
+		g_ctx.SetHermetic(true)
+	}
 
-	args := g_cmd.Flag.Args()
 	err = g_cmd.Dispatch(args)
 	handle_err(err)
 
@@ -0,0 +1,125 @@
+package platform
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DefaultDistMap returns the default table mapping a distribution ID
+// (as found in the ID field of /etc/os-release) to the pattern used to
+// build the OS component of a variant (eg: slc6, ubuntu1404, ...)
+//
+// Patterns may refer to the following variables:
+//   - ${id}:             the distribution ID (eg: centos)
+//   - ${version}:        the distribution version (eg: 6.5)
+//   - ${major}:          the major part of the version (eg: 6)
+//   - ${minor}:          the minor part of the version (eg: 5)
+//   - ${version_nodots}: the version with its dots removed (eg: 65)
+func DefaultDistMap() map[string]string {
+	return map[string]string{
+		"alma":                "alma${major}",
+		"almalinux":           "alma${major}",
+		"amzn":                "amzn${major}",
+		"arch":                "archlinux",
+		"cel":                 "${id}${major}",
+		"centos":              "${id}${major}",
+		"debian":              "${id}${major}",
+		"fedora":              "${id}${major}",
+		"gentoo":              "gentoo",
+		"linuxmint":           "mint${version_nodots}",
+		"ol":                  "${id}${major}",
+		"opensuse":            "opensuse${major}",
+		"opensuse-leap":       "opensuse${major}",
+		"opensuse-tumbleweed": "opensusetw",
+		"rh":                  "${id}${major}",
+		"rhel":                "${id}${major}",
+		"rocky":               "rocky${major}",
+		"scientific":          "sl${major}",
+		"sl":                  "${id}${major}",
+		"slc":                 "${id}${major}",
+		"sles":                "${id}${major}",
+		"ubuntu":              "${id}${version_nodots}",
+	}
+}
+
+// DistTag returns the OS component of a variant for this platform, using
+// the given table of patterns (see DefaultDistMap.)
+// If the distribution ID is not in the table, the distributions listed in
+// ID_LIKE are tried in turn, with their pattern applied to this platform.
+func (p *Platform) DistTag(dists map[string]string) (string, error) {
+	ids := append([]string{p.DistName}, p.DistLike...)
+	for _, id := range ids {
+		pattern, ok := dists[strings.ToLower(id)]
+		if !ok {
+			continue
+		}
+		return p.expand_dist(pattern), nil
+	}
+	return "", fmt.Errorf("platform: unhandled distribution [%s]", p.DistId())
+}
+
+func (p *Platform) expand_dist(pattern string) string {
+	vers := strings.Split(p.DistVers, ".")
+	major := vers[0]
+	minor := ""
+	if len(vers) > 1 {
+		minor = vers[1]
+	}
+	return os.Expand(pattern, func(n string) string {
+		switch n {
+		case "id":
+			return strings.ToLower(p.DistName)
+		case "version":
+			return p.DistVers
+		case "major":
+			return major
+		case "minor":
+			return minor
+		case "version_nodots":
+			return strings.Replace(p.DistVers, ".", "", -1)
+		}
+		return ""
+	})
+}
+
+// parse_os_release parses a file following the os-release(5) format:
+// newline-separated, shell-compatible KEY=VALUE assignments.
+func parse_os_release(fname string) (map[string]string, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	vars := make(map[string]string)
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		line := strings.TrimSpace(scan.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		idx := strings.Index(line, "=")
+		if idx <= 0 {
+			continue
+		}
+		k := strings.TrimSpace(line[:idx])
+		v := strings.TrimSpace(line[idx+1:])
+		switch {
+		case len(v) > 1 && v[0] == '"' && v[len(v)-1] == '"':
+			if vv, err := strconv.Unquote(v); err == nil {
+				v = vv
+			} else {
+				v = v[1 : len(v)-1]
+			}
+		case len(v) > 1 && v[0] == '\'' && v[len(v)-1] == '\'':
+			v = v[1 : len(v)-1]
+		}
+		vars[k] = v
+	}
+	return vars, scan.Err()
+}
+
+// EOF
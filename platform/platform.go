@@ -12,14 +12,15 @@ import (
 )
 
 type Platform struct {
-	System    string // Operating system name
-	Node      string // Network node hostname
-	Release   string // Operating system release
-	Version   string // Operating system version
-	Machine   string // Machine hardware name
-	Processor string // Processor type
-	DistName  string // distribution name (eg: slc, darwin,...)
-	DistVers  string // distribution version (eg: 6.2, 10.6,...)
+	System    string   // Operating system name
+	Node      string   // Network node hostname
+	Release   string   // Operating system release
+	Version   string   // Operating system version
+	Machine   string   // Machine hardware name
+	Processor string   // Processor type
+	DistName  string   // distribution name (eg: slc, darwin,...)
+	DistVers  string   // distribution version (eg: 6.2, 10.6,...)
+	DistLike  []string // related distributions (ID_LIKE in /etc/os-release)
 }
 
 func (p Platform) String() string {
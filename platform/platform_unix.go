@@ -9,8 +9,6 @@ import (
 	"regexp"
 	"sort"
 	"strings"
-
-	gocfg "github.com/gonuts/config"
 )
 
 func uname(opts ...string) (string, error) {
@@ -24,6 +22,11 @@ func uname(opts ...string) (string, error) {
 	return out, err
 }
 
+func file_exists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
 func infos() (Platform, error) {
 	var err error
 	var plat Platform
@@ -58,7 +61,7 @@ func infos() (Platform, error) {
 	return plat, err
 }
 
-func parse_dist_files(fnames []string) (distname, distvers string, err error) {
+func parse_dist_files(fnames []string) (distname, distvers string, distlike []string, err error) {
 	distname = ""
 	distvers = ""
 
@@ -155,11 +158,12 @@ func parse_dist_files(fnames []string) (distname, distvers string, err error) {
 			continue
 		}
 		//fmt.Printf("dist=%q distvers=%v\n", distname, distvers)
-		return distname, distvers, nil
+		return distname, distvers, nil, nil
 	}
 
 	for _, args := range [][]string{
 		{"/etc/os-release", "ID", "VERSION_ID"},
+		{"/usr/lib/os-release", "ID", "VERSION_ID"},
 		{"/etc/lsb-release", "DISTRIB_ID", "DISTRIB_RELEASE"},
 	} {
 		fname := args[0]
@@ -171,32 +175,23 @@ func parse_dist_files(fnames []string) (distname, distvers string, err error) {
 			continue
 		}
 
-		cfg, err := gocfg.ReadDefault(fname)
+		vars, err := parse_os_release(fname)
 		if err != nil {
 			//fmt.Printf("++ %v\n", err)
 			continue
 		}
 
-		d_name, err := cfg.RawString("DEFAULT", id_str)
-		if err == nil {
-			distname = strings.Trim(d_name, `"'`)
-		} else {
-			//fmt.Printf("++ %v\n", err)
+		distname = strings.ToLower(vars[id_str])
+		if distname == "" {
 			continue
 		}
-		d_vers, err := cfg.RawString("DEFAULT", vers_str)
-		if err == nil {
-			distvers = strings.Trim(d_vers, `"'`)
-		} else {
-			// distvers is optional...
-
-			//fmt.Printf("++ %v\n", err)
-			//continue
-		}
-		return distname, distvers, nil
+		// distvers is optional (e.g. rolling releases)
+		distvers = vars[vers_str]
+		distlike = strings.Fields(strings.ToLower(vars["ID_LIKE"]))
+		return distname, distvers, distlike, nil
 	}
 
-	return "", "", fmt.Errorf("platform: unsupported linux distribution")
+	return "", "", nil, fmt.Errorf("platform: unsupported linux distribution")
 }
 
 func (p *Platform) init_dist() error {
@@ -204,6 +199,7 @@ func (p *Platform) init_dist() error {
 
 	distname := strings.ToLower(p.System)
 	distvers := ""
+	var distlike []string
 
 	switch p.System {
 	case "Linux":
@@ -212,13 +208,20 @@ func (p *Platform) init_dist() error {
 		if err != nil {
 			return err
 		}
+		// os-release(5) may only be available under /usr/lib
+		if fname := "/usr/lib/os-release"; file_exists(fname) {
+			files = append(files, fname)
+		}
 		sort.Strings(files)
-		distname, distvers, err = parse_dist_files(files)
-		if err != nil {
-			return err
+		distname, distvers, distlike, err = parse_dist_files(files)
+		if err == nil && distname == "" {
+			err = fmt.Errorf("platform: unsupported linux distribution")
 		}
-		if distname == "" {
-			return fmt.Errorf("platform: unsupported linux distribution")
+		if err != nil {
+			// generic linux
+			distname = strings.ToLower(p.System)
+			distvers = ""
+			distlike = nil
 		}
 
 	case "Darwin":
@@ -230,11 +233,12 @@ func (p *Platform) init_dist() error {
 		distname = "darwin"
 
 	default:
-		panic(fmt.Sprintf("platform: unknown platform [%s]", p.System))
+		err = fmt.Errorf("platform: unknown platform [%s]", p.System)
 	}
 
 	p.DistName = distname
 	p.DistVers = distvers
+	p.DistLike = distlike
 	return err
 }
 
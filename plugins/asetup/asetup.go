@@ -12,7 +12,6 @@ import (
 	"github.com/gonuts/commander"
 	gocfg "github.com/gonuts/config"
 	"github.com/hwaf/hwaf/hwaflib"
-	"github.com/hwaf/hwaf/platform"
 )
 
 func path_exists(name string) bool {
@@ -210,7 +209,7 @@ func (a *asetup_t) process(args []string) error {
 	var err error
 	opts := new_options()
 
-	pinfos, err := platform.Infos()
+	pinfos, err := a.ctx.PlatformInfos()
 	if err != nil {
 		return err
 	}
@@ -233,15 +232,10 @@ func (a *asetup_t) process(args []string) error {
 		hwaf_os = "slc" + major
 	}
 	hwaf_comp := "gcc"
-	hwaf_arch := ""
-	switch runtime.GOARCH {
-	case "amd64":
-		hwaf_arch = "x86_64"
-	case "386":
-		hwaf_arch = "i686"
-	default:
-		//hwaf_arch = "unknown"
-		panic(fmt.Sprintf("unknown architecture [%s]", hwaf_arch))
+	hwaf_arch, err := hwaflib.VariantArch(runtime.GOARCH)
+	if err != nil {
+		a.ctx.Warnf("%v\n", err)
+		err = nil
 	}
 	hwaf_bld := "opt"
 	for _, arg := range args {
@@ -315,7 +309,12 @@ func (a *asetup_t) process(args []string) error {
 		return err
 	}
 
-	usr_variant := fmt.Sprintf("%s-%s-%s-%s", hwaf_arch, hwaf_os, hwaf_comp, hwaf_bld)
+	usr_variant := hwaflib.Variant{
+		Arch:     hwaf_arch,
+		Os:       hwaf_os,
+		Compiler: hwaf_comp,
+		Type:     hwaf_bld,
+	}
 	proj_root := filepath.Join(sitedir, projname)
 	if !path_exists(proj_root) {
 		err = fmt.Errorf("no such directory [%s]", proj_root)
@@ -349,21 +348,18 @@ func (a *asetup_t) process(args []string) error {
 	}
 
 	// dft_variant is a variation on DefaultVariant.
-	dft_variant := fmt.Sprintf(
-		"%s-%s-%s-%s",
-		hwaf_arch,
-		hwaf_os,
-		strings.Split(a.ctx.DefaultVariant(), "-")[2], // compiler
-		hwaf_bld,
-	)
+	dft_variant := usr_variant
+	if v, err := hwaflib.ParseVariant(a.ctx.DefaultVariant()); err == nil {
+		dft_variant.Compiler = v.Compiler
+	}
 
 	found := false
 	for ii, variant := range []string{
 		cli_variant,
-		usr_variant,
+		usr_variant.String(),
 		a.ctx.Variant(),
 		a.ctx.DefaultVariant(),
-		dft_variant,
+		dft_variant.String(),
 	} {
 		if variant == "" {
 			continue
@@ -386,6 +382,35 @@ func (a *asetup_t) process(args []string) error {
 		found = true
 		break
 	}
+	if !found {
+		// no exact match: look for a binary compatible variant
+		// (eg: x86_64-slc6-gcc47-opt for x86_64-centos6-gcc47-opt)
+		host := usr_variant
+		if v, err := a.ctx.ParseVariant(cli_variant); err == nil {
+			host = v
+		}
+		dirs, err := filepath.Glob(filepath.Join(opts.projdir, "*"))
+		if err != nil {
+			return err
+		}
+		sort.Strings(dirs)
+		for _, dir := range dirs {
+			variant, err := a.ctx.ParseVariant(filepath.Base(dir))
+			if err != nil {
+				continue
+			}
+			if !a.ctx.CompatibleVariant(host, variant) {
+				continue
+			}
+			if a.verbose {
+				a.ctx.Infof("using compatible variant [%s] for [%s]\n", variant, host)
+			}
+			opts.projdir = dir
+			opts.variant = variant.String()
+			found = true
+			break
+		}
+	}
 	if !found {
 		return fmt.Errorf("hwaf: could not find a suitable project")
 	}
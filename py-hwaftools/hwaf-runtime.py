@@ -543,6 +543,8 @@ class DumpEnvCmdContext(waflib.Configure.ConfigurationContext):
         
         self.env.load(self.cachedir.find_node("_cache.py").abspath())
         self.env.HWAF_ENABLE_INSTALL_AREA = '1'
+        # same environment than 'hwaf run' and 'hwaf shell'
+        self.hwaf_setup_runtime()
 
         py_exe = self.env.PYTHON
         if isinstance(py_exe, (list, tuple)):
@@ -42,14 +42,48 @@ def hwaf_setup_spy_env(ctx):
                            )):
             return True
         return False
+
+    # hwaf functions implementing the hscript/cmt statements: environment
+    # modifications made by these are recorded on behalf of their caller
+    # (ie: the wscript of a package)
+    _spy_stmts = {
+        'hwaf_declare_macro':       'set',
+        'hwaf_macro_prepend':       'set_prepend',
+        'hwaf_macro_prepend_value': 'set_prepend',
+        'hwaf_macro_append':        'set_append',
+        'hwaf_macro_append_value':  'set_append',
+        'hwaf_macro_remove':        'set_remove',
+        'hwaf_declare_path':        'path',
+        'hwaf_path_prepend':        'path_prepend',
+        'hwaf_path_append':         'path_append',
+        'hwaf_path_remove':         'path_remove',
+        }
+
+    def _spy_who(stack):
+        '''return the frame and the statement responsible for an environment
+        modification, or None if the modification is internal to waf/hwaf
+        '''
+        if not _tb_stack_filter(stack):
+            return tuple(stack[-1]), None
+        i = len(stack) - 1
+        while i >= 0 and _tb_stack_filter(stack[:i+1]):
+            i -= 1
+        if i < 0:
+            return None
+        # skip the waflib.Configure.conf wrappers
+        for frame in stack[i+1:]:
+            stmt = _spy_stmts.get(frame[2], None)
+            if stmt is not None:
+                return tuple(stack[i]), stmt
+        return None
     
     #mylog = open('spy.log.txt', 'w')
     def _new_ConfigSet_setitem(self, key, value):
         if key == 'HWAF_ENV_SPY':
             return _orig_ConfigSet_setitem(self, key, value)
         
-        stack = traceback.extract_stack()[:-1]
-        if _tb_stack_filter(stack):
+        who = _spy_who(traceback.extract_stack()[:-1])
+        if who is None:
             return _orig_ConfigSet_setitem(self, key, value)
             
         #print(">>> __setitem__(%s, %s)..." % (key, stack), file=mylog)
@@ -66,7 +100,8 @@ def hwaf_setup_spy_env(ctx):
                   'old': old_value,
                   'new': new_value,
                   'val': value,
-                  'who': stack[-1],
+                  'who': who[0],
+                  'stmt': who[1],
                   }]
                 )
         return ret
@@ -75,8 +110,8 @@ def hwaf_setup_spy_env(ctx):
     def _new_ConfigSet_setattr(self, key, value):
         if key == 'HWAF_ENV_SPY':
             return _orig_ConfigSet_setattr(self, key, value)
-        stack = traceback.extract_stack()[:-1]
-        if _tb_stack_filter(stack):
+        who = _spy_who(traceback.extract_stack()[:-1])
+        if who is None:
             return _orig_ConfigSet_setattr(self, key, value)
         #print(">>> __setattr__(%s, %s)..." % (key, stack), file=mylog)
         #mylog.flush()
@@ -92,7 +127,8 @@ def hwaf_setup_spy_env(ctx):
                   'old': old_value,
                   'new': new_value,
                   'val': value,
-                  'who': stack[-1],
+                  'who': who[0],
+                  'stmt': who[1],
                   }]
                 )
         return ret
@@ -101,8 +137,8 @@ def hwaf_setup_spy_env(ctx):
     def _new_ConfigSet_append_value(self, var, val):
         if var == 'HWAF_ENV_SPY':
             return _orig_ConfigSet_append_value(self, var, val)
-        stack = traceback.extract_stack()[:-1]
-        if _tb_stack_filter(stack):
+        who = _spy_who(traceback.extract_stack()[:-1])
+        if who is None:
             return _orig_ConfigSet_append_value(self, var, val)
         
         #print(">>> append_value(%s, %s)..." % (var, stack), file=mylog)
@@ -119,7 +155,8 @@ def hwaf_setup_spy_env(ctx):
                   'old': old_value,
                   'new': new_value,
                   'val': val,
-                  'who': stack[-1],
+                  'who': who[0],
+                  'stmt': who[1],
                   }]
                 )
         return ret
@@ -128,8 +165,8 @@ def hwaf_setup_spy_env(ctx):
     def _new_ConfigSet_prepend_value(self, var, val):
         if var == 'HWAF_ENV_SPY':
             return _orig_ConfigSet_prepend_value(self, var, val)
-        stack = traceback.extract_stack()[:-1]
-        if _tb_stack_filter(stack):
+        who = _spy_who(traceback.extract_stack()[:-1])
+        if who is None:
             return _orig_ConfigSet_prepend_value(self, var, val)
         
         #print(">>> prepend_value(%s, %s)..." % (var, stack), file=mylog)
@@ -146,7 +183,8 @@ def hwaf_setup_spy_env(ctx):
                   'old': old_value,
                   'new': new_value,
                   'val': val,
-                  'who': stack[-1],
+                  'who': who[0],
+                  'stmt': who[1],
                   }]
                 )
         return ret
@@ -155,8 +193,8 @@ def hwaf_setup_spy_env(ctx):
     def _new_ConfigSet_append_unique(self, var, val):
         if var == 'HWAF_ENV_SPY':
             return _orig_ConfigSet_append_unique(self, var, val)
-        stack = traceback.extract_stack()[:-1]
-        if _tb_stack_filter(stack):
+        who = _spy_who(traceback.extract_stack()[:-1])
+        if who is None:
             return _orig_ConfigSet_append_unique(self, var, val)
         
         #print(">>> append_unique(%s, %s)..." % (var, stack), file=mylog)
@@ -173,7 +211,8 @@ def hwaf_setup_spy_env(ctx):
                   'old': old_value,
                   'new': new_value,
                   'val': val,
-                  'who': stack[-1],
+                  'who': who[0],
+                  'stmt': who[1],
                   }]
                 )
         return ret
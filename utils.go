@@ -19,6 +19,22 @@ func path_exists(name string) bool {
 	return false
 }
 
+// cfg_string returns the value of option in section of the local
+// configuration (local.conf), of the global configuration or def.
+func cfg_string(section, option, def string) string {
+	if cfg, err := g_ctx.LocalCfg(); err == nil && cfg.HasOption(section, option) {
+		if v, err := cfg.RawString(section, option); err == nil {
+			return v
+		}
+	}
+	if cfg, err := g_ctx.GlobalCfg(); err == nil && cfg.HasOption(section, option) {
+		if v, err := cfg.RawString(section, option); err == nil {
+			return v
+		}
+	}
+	return def
+}
+
 func handle_err(err error) {
 	if err != nil {
 		g_ctx.Errorf("%v\n", err.Error())
@@ -0,0 +1,607 @@
+package main
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/hwaf/hwaf/hwaflib"
+)
+
+// bdist_manifest_dir is the directory (relative to the install area) holding
+// the manifests of the binary distributions.
+const bdist_manifest_dir = "share/hwaf/manifests"
+
+// bdist_manifest_t is the manifest embedded in every binary distribution
+type bdist_manifest_t struct {
+	Name         string            // name of the binary distribution
+	Version      string            // version of the binary distribution
+	Variant      string            // HWAF_VARIANT quadruplet
+	Prefix       string            `json:",omitempty"` // installation prefix the distribution was built for
+	Staging      string            `json:",omitempty"` // directory the install area was staged in (DESTDIR), if any
+	Projects     []bdist_project_t // upstream projects
+	Packages     []bdist_package_t // packages of the project
+	Host         string            // build host
+	HwafVersion  string            // version of hwaf which created the distribution
+	HwafRevision string            // revision of hwaf which created the distribution
+	Files        []bdist_file_t    // content of the distribution
+}
+
+// bdist_project_t describes an upstream project of a binary distribution
+type bdist_project_t struct {
+	Name    string
+	Version string
+}
+
+// bdist_package_t describes a package of a binary distribution
+type bdist_package_t struct {
+	Path     string // path of the package in the workarea
+	Type     string // type of VCS (git, svn, local, ...)
+	Repo     string // remote repository URL
+	Revision string // VCS revision of the checked out package
+}
+
+// bdist_file_t describes a file of a binary distribution
+type bdist_file_t struct {
+	Name   string // path relative to the top of the distribution
+	Size   int64
+	Sha256 string `json:",omitempty"` // hex-encoded SHA-256 (regular files)
+	Link   string `json:",omitempty"` // symlink target
+}
+
+// bdist_manifest_name returns the path of the manifest of a distribution,
+// relative to the top of the distribution.
+func bdist_manifest_name(name, vers string) string {
+	return path.Join(bdist_manifest_dir, name+"-"+vers+".json")
+}
+
+// bdist_is_manifest returns whether name is the path of a manifest,
+// relative to the top of a distribution or not.
+func bdist_is_manifest(name string) bool {
+	dir, file := path.Split(name)
+	dir = strings.TrimSuffix(dir, "/")
+	return strings.HasSuffix(file, ".json") &&
+		(dir == bdist_manifest_dir || strings.HasSuffix(dir, "/"+bdist_manifest_dir))
+}
+
+// bdist_new_manifest creates the manifest of the binary distribution of the
+// directory root (the install area). If keep is not nil, only the files for
+// which it returns true are listed.
+func bdist_new_manifest(root, name, vers, variant string, keep func(name string) bool) (*bdist_manifest_t, error) {
+	m := &bdist_manifest_t{
+		Name:         name,
+		Version:      vers,
+		Variant:      variant,
+		Projects:     []bdist_project_t{},
+		Packages:     []bdist_package_t{},
+		HwafVersion:  g_ctx.Version(),
+		HwafRevision: g_ctx.Revision(),
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	m.Host = host
+	m.Prefix, m.Staging = bdist_build_prefix(root)
+
+	projs, err := upstream_projects()
+	if err != nil {
+		return nil, err
+	}
+	for _, proj := range projs {
+		m.Projects = append(m.Projects, bdist_project_t{Name: proj[0], Version: proj[1]})
+	}
+
+	workdir, _ := g_ctx.Workarea()
+	for _, pkgname := range g_ctx.PkgDb.Pkgs() {
+		pkg, err := g_ctx.PkgDb.GetPkg(pkgname)
+		if err != nil {
+			return nil, err
+		}
+		m.Packages = append(m.Packages, bdist_package_t{
+			Path:     pkg.Path,
+			Type:     pkg.Type,
+			Repo:     pkg.Repo,
+			Revision: pkg_revision(workdir, pkg),
+		})
+	}
+
+	m.Files, err = bdist_tree_files(root, keep)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// bdist_build_prefix returns the installation prefix of the local project
+// and the directory it was staged in (with DESTDIR, if any), if root is its
+// install area. Both are empty if root is not the install area (eg: if it
+// is the DESTDIR directory itself).
+func bdist_build_prefix(root string) (string, string) {
+	pinfos, err := g_ctx.ProjectInfos()
+	if err != nil {
+		return "", ""
+	}
+	prefix, err := pinfos.Get("INSTALL_AREA")
+	if err != nil || prefix == "" {
+		prefix, err = pinfos.Get("PREFIX")
+		if err != nil {
+			return "", ""
+		}
+	}
+	prefix, err = filepath.Abs(prefix)
+	if err != nil {
+		return "", ""
+	}
+	staging, err := project_install_area(pinfos)
+	if err != nil {
+		return "", ""
+	}
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return "", ""
+	}
+	switch root {
+	case prefix:
+		return prefix, ""
+	case staging:
+		return prefix, staging
+	}
+	return "", ""
+}
+
+// data returns the JSON encoding of the manifest
+func (m *bdist_manifest_t) data() ([]byte, error) {
+	data, err := json.MarshalIndent(m, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// add_entries lists the additional files entries of the distribution (see
+// tar_create) in the manifest, replacing the files of the tree they hide.
+func (m *bdist_manifest_t) add_entries(entries []tar_entry_t) {
+	for _, entry := range entries {
+		file := bdist_file_t{
+			Name:   strings.Trim(path.Clean("/"+entry.name), "/"),
+			Size:   int64(len(entry.data)),
+			Sha256: fmt.Sprintf("%x", sha256.Sum256(entry.data)),
+		}
+		found := false
+		for i := range m.Files {
+			if m.Files[i].Name == file.Name {
+				m.Files[i] = file
+				found = true
+				break
+			}
+		}
+		if !found {
+			m.Files = append(m.Files, file)
+		}
+	}
+}
+
+// bdist_setup_scripts returns the up-to-date setup scripts of the current
+// project as additional files of the distribution of the directory root
+// (see hwaf_setup_scripts), without touching the install area.
+// Errors are only reported: the distribution then holds the setup scripts
+// of the install area as they are.
+func bdist_setup_scripts(root string) []tar_entry_t {
+	ops, err := hwaf_runtime_env()
+	if err != nil {
+		g_ctx.Warnf("could not generate setup scripts: %v\n", err)
+		return nil
+	}
+	install_area, scripts, err := hwaf_setup_scripts(ops)
+	if err != nil {
+		g_ctx.Warnf("could not generate setup scripts: %v\n", err)
+		return nil
+	}
+	root, err = filepath.Abs(root)
+	if err != nil {
+		g_ctx.Warnf("could not generate setup scripts: %v\n", err)
+		return nil
+	}
+	rel, err := filepath.Rel(root, install_area)
+	if err != nil || !install_is_local(filepath.ToSlash(rel)) {
+		g_ctx.Warnf("install area [%s] is not under [%s]: setup scripts not updated\n", install_area, root)
+		return nil
+	}
+	for i := range scripts {
+		scripts[i].name = path.Join(filepath.ToSlash(rel), scripts[i].name)
+	}
+	return scripts
+}
+
+// pkg_revision returns the VCS revision of a locally checked out package
+// (or an empty string if it can not be determined).
+func pkg_revision(workdir string, pkg hwaflib.VcsPackage) string {
+	var cmd *exec.Cmd
+	switch pkg.Type {
+	case "git":
+		cmd = exec.Command("git", "rev-parse", "HEAD")
+	case "svn":
+		cmd = exec.Command("svnversion", "-n", ".")
+	default:
+		return ""
+	}
+	for _, dir := range []string{pkg.RepoDir, pkg.Path} {
+		if dir == "" {
+			continue
+		}
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(workdir, dir)
+		}
+		if !path_exists(dir) {
+			continue
+		}
+		cmd.Dir = dir
+		out, err := cmd.Output()
+		if err != nil {
+			g_ctx.Debugf("hwaf: could not get the revision of [%s]: %v\n", pkg.Path, err)
+			return ""
+		}
+		return strings.TrimSpace(string(out))
+	}
+	return ""
+}
+
+// sha256_file returns the hex-encoded SHA-256 digest of the file fname
+func sha256_file(fname string) (string, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	sum := sha256.New()
+	_, err = io.Copy(sum, f)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sum.Sum(nil)), nil
+}
+
+// bdist_stat_file describes the file fname as a file of a distribution
+func bdist_stat_file(name, fname string) (bdist_file_t, error) {
+	file := bdist_file_t{Name: name}
+	fi, err := os.Lstat(fname)
+	if err != nil {
+		return file, err
+	}
+	switch {
+	case fi.Mode()&os.ModeSymlink != 0:
+		file.Link, err = os.Readlink(fname)
+		file.Size = int64(len(file.Link))
+	case fi.Mode().IsRegular():
+		file.Size = fi.Size()
+		file.Sha256, err = sha256_file(fname)
+	default:
+		err = fmt.Errorf("hwaf: [%s] is not a regular file", fname)
+	}
+	return file, err
+}
+
+// bdist_tree_files returns the files and symlinks of the directory root,
+// sorted by name. Manifests are left out, as well as the files for which
+// keep (if not nil) returns false.
+func bdist_tree_files(root string, keep func(name string) bool) ([]bdist_file_t, error) {
+	files := []bdist_file_t{}
+	err := filepath.Walk(root, func(fname string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, fname)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if bdist_is_manifest(name) || (keep != nil && !keep(name)) {
+			return nil
+		}
+		if !fi.Mode().IsRegular() && fi.Mode()&os.ModeSymlink == 0 {
+			g_ctx.Warnf("skipping special file [%s]\n", fname)
+			return nil
+		}
+		file, err := bdist_stat_file(name, fname)
+		if err != nil {
+			return err
+		}
+		files = append(files, file)
+		return nil
+	})
+	return files, err
+}
+
+// bdist_components are the sub-packages a binary distribution may be split
+// into (see bdist_split_t)
+var bdist_components = []string{"runtime", "devel", "debug"}
+
+// bdist_split_defaults are the default path rules of the sub-packages
+var bdist_split_defaults = map[string]string{
+	"runtime": "",
+	"devel": "include/ *.h *.hh *.hpp *.hxx *.icc *.a *.la *.pc" +
+		" lib/pkgconfig/ lib64/pkgconfig/ share/pkgconfig/" +
+		" lib/cmake/ lib64/cmake/ share/cmake/" +
+		" *Config.cmake *ConfigVersion.cmake *-config.cmake *-config-version.cmake",
+	"debug": "lib/debug/ *.debug *.dSYM/ *.dwo",
+}
+
+// bdist_component_suffixes are the suffixes appended to the name of the
+// distribution for its sub-packages, per output format
+var bdist_component_suffixes = map[string]map[string]string{
+	"tar": {"runtime": "", "devel": "-devel", "debug": "-debug"},
+	"rpm": {"runtime": "", "devel": "-devel", "debug": "-debuginfo"},
+	"deb": {"runtime": "", "devel": "-dev", "debug": "-dbg"},
+}
+
+// bdist_component_name returns the name of the sub-package comp of the
+// distribution name, for the output format (tar, rpm or deb)
+func bdist_component_name(name, comp, format string) string {
+	return name + bdist_component_suffixes[format][comp]
+}
+
+// bdist_split_t holds the path rules splitting the install area into
+// runtime, devel and debug sub-packages.
+//
+// The rules of a project are read from the [hwaf-bdist-split] section of
+// the configuration (runtime, devel and debug options): a list of patterns
+// separated by spaces or commas. A pattern ending with "/" matches a
+// directory and everything below it, a pattern without "/" is matched
+// against the base name of the files and any other pattern against their
+// full path (relative to the install area), following path.Match.
+//
+// A file goes to the runtime sub-package if it matches an explicit runtime
+// rule, then to the debug or the devel one if it matches one of their
+// rules, and to the runtime one otherwise.
+type bdist_split_t struct {
+	rules map[string][]string // patterns, by component
+}
+
+// bdist_new_split returns the split rules of the current project
+func bdist_new_split() (*bdist_split_t, error) {
+	split := &bdist_split_t{rules: make(map[string][]string)}
+	for _, comp := range bdist_components {
+		value := cfg_string("hwaf-bdist-split", comp, bdist_split_defaults[comp])
+		patterns := strings.FieldsFunc(value, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t' || r == '\n'
+		})
+		for _, pattern := range patterns {
+			_, err := path.Match(strings.TrimSuffix(pattern, "/"), "")
+			if err != nil {
+				return nil, fmt.Errorf("hwaf: invalid %s split rule [%s]: %v", comp, pattern, err)
+			}
+		}
+		split.rules[comp] = patterns
+	}
+	return split, nil
+}
+
+// component returns the sub-package of the file name (relative to the
+// install area)
+func (split *bdist_split_t) component(name string) string {
+	for _, comp := range []string{"runtime", "debug", "devel"} {
+		for _, pattern := range split.rules[comp] {
+			if split_match(pattern, name) {
+				return comp
+			}
+		}
+	}
+	return "runtime"
+}
+
+// keep returns a filter selecting the files of the sub-package comp
+func (split *bdist_split_t) keep(comp string) func(name string) bool {
+	return func(name string) bool {
+		return split.component(name) == comp
+	}
+}
+
+// split_match returns whether the file name matches the split rule pattern
+func split_match(pattern, name string) bool {
+	if strings.HasSuffix(pattern, "/") {
+		// a directory and its content
+		pattern = strings.TrimSuffix(pattern, "/")
+		for dir := name; dir != "." && dir != "/"; dir = path.Dir(dir) {
+			if split_match(pattern, dir) {
+				return true
+			}
+		}
+		return false
+	}
+	if !strings.Contains(pattern, "/") {
+		name = path.Base(name)
+	}
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
+
+// bdist_archive_t is the content of a binary distribution archive
+type bdist_archive_t struct {
+	files     map[string]bdist_file_t // files and symlinks, by name
+	manifests map[string][]byte       // content of the manifests, by name
+}
+
+func (ar *bdist_archive_t) add(name string, mode os.FileMode, size int64, r io.Reader) error {
+	name = strings.Trim(path.Clean("/"+name), "/")
+	file := bdist_file_t{Name: name, Size: size}
+	switch {
+	case mode.IsDir():
+		return nil
+	case mode&os.ModeSymlink != 0:
+		link, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		file.Link = string(link)
+	case mode.IsRegular():
+		if bdist_is_manifest(name) {
+			data, err := ioutil.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			ar.manifests[name] = data
+			return nil
+		}
+		sum := sha256.New()
+		n, err := io.Copy(sum, r)
+		if err != nil {
+			return err
+		}
+		file.Size = n
+		file.Sha256 = fmt.Sprintf("%x", sum.Sum(nil))
+	default:
+		return nil
+	}
+	ar.files[name] = file
+	return nil
+}
+
+// read_tar reads the content of a tarball
+func (ar *bdist_archive_t) read_tar(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		mode := hdr.FileInfo().Mode()
+		var data io.Reader = tr
+		switch hdr.Typeflag {
+		case tar.TypeSymlink:
+			data = strings.NewReader(hdr.Linkname)
+		case tar.TypeLink:
+			// hard link: same content as the linked file
+			link := strings.Trim(path.Clean("/"+hdr.Linkname), "/")
+			file, ok := ar.files[link]
+			if !ok {
+				return fmt.Errorf("hwaf: hard link [%s] to unknown file [%s]", hdr.Name, hdr.Linkname)
+			}
+			file.Name = strings.Trim(path.Clean("/"+hdr.Name), "/")
+			ar.files[file.Name] = file
+			continue
+		}
+		err = ar.add(hdr.Name, mode, hdr.Size, data)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// bdist_read_archive reads the binary distribution fname: a (compressed)
+// tarball, a RPM or a DEB.
+func bdist_read_archive(fname string) (*bdist_archive_t, error) {
+	ar := &bdist_archive_t{
+		files:     make(map[string]bdist_file_t),
+		manifests: make(map[string][]byte),
+	}
+
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch {
+	case strings.HasSuffix(fname, ".rpm"):
+		err = rpm_skip_headers(f)
+		if err != nil {
+			return nil, err
+		}
+		r, err := tar_decompress(f)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		err = rpm_read_cpio(r, ar.add)
+		if err != nil {
+			return nil, err
+		}
+
+	case strings.HasSuffix(fname, ".deb"):
+		data, err := deb_data_reader(f)
+		if err != nil {
+			return nil, err
+		}
+		r, err := tar_decompress(data)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		err = ar.read_tar(r)
+		if err != nil {
+			return nil, err
+		}
+
+	default:
+		r, err := tar_decompress(f)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		err = ar.read_tar(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ar, nil
+}
+
+// bdist_load_manifest decodes a manifest
+func bdist_load_manifest(name string, data []byte) (*bdist_manifest_t, error) {
+	m := &bdist_manifest_t{}
+	err := json.Unmarshal(data, m)
+	if err != nil {
+		return nil, fmt.Errorf("hwaf: invalid manifest [%s]: %v", name, err)
+	}
+	return m, nil
+}
+
+// bdist_manifest_root returns the top of the distribution the manifest name
+// belongs to.
+func bdist_manifest_root(name string) string {
+	dir := path.Dir(name)
+	return strings.Trim(strings.TrimSuffix(dir, bdist_manifest_dir), "/")
+}
+
+// bdist_manifest_top returns the top of the installed distribution the
+// manifest file fname belongs to.
+func bdist_manifest_top(fname string) string {
+	return strings.TrimSuffix(filepath.Dir(fname), filepath.FromSlash("/"+bdist_manifest_dir))
+}
+
+// bdist_verify checks the files of a distribution against its manifest.
+// stat returns the description of a file of the distribution from its name.
+// It returns the list of problems found.
+func bdist_verify(m *bdist_manifest_t, stat func(name string) (bdist_file_t, error)) []string {
+	problems := []string{}
+	for _, want := range m.Files {
+		got, err := stat(want.Name)
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("missing:    %s", want.Name))
+		case want.Link != got.Link:
+			problems = append(problems, fmt.Sprintf("modified:   %s (link to %q, expected %q)", want.Name, got.Link, want.Link))
+		case want.Sha256 != got.Sha256:
+			problems = append(problems, fmt.Sprintf("modified:   %s (sha256 %s, expected %s)", want.Name, got.Sha256, want.Sha256))
+		}
+	}
+	return problems
+}
+
+// EOF
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitMatch(t *testing.T) {
+	for _, table := range []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		// patterns without a '/' match the base name
+		{"*.h", "include/mana/mana.h", true},
+		{"*.h", "mana.h", true},
+		{"*.h", "include/mana/mana.hh", false},
+		{"*.a", "lib/libmana.a", true},
+		{"*.a", "lib/libmana.a.so", false},
+		// patterns with a '/' match the whole name
+		{"lib/*.a", "lib/libmana.a", true},
+		{"lib/*.a", "lib64/libmana.a", false},
+		{"lib/*.a", "lib/static/libmana.a", false},
+		// a directory and its content
+		{"include/", "include", true},
+		{"include/", "include/mana/mana.h", true},
+		{"include/", "share/include/mana.h", true}, // any include directory
+		{"include/", "includes/mana.h", false},
+		{"lib/pkgconfig/", "lib/pkgconfig/mana.pc", true},
+		{"lib/pkgconfig/", "lib64/pkgconfig/mana.pc", false},
+		{"*.dSYM/", "bin/mana.dSYM/Contents/Info.plist", true},
+		{"*.dSYM/", "bin/mana", false},
+	} {
+		match := split_match(table.pattern, table.name)
+		if match != table.match {
+			t.Errorf("split_match(%q, %q): got=%v want=%v",
+				table.pattern, table.name, match, table.match,
+			)
+		}
+	}
+}
+
+func TestSplitComponent(t *testing.T) {
+	split := &bdist_split_t{rules: make(map[string][]string)}
+	for _, comp := range bdist_components {
+		split.rules[comp] = strings.Fields(bdist_split_defaults[comp])
+	}
+
+	for _, table := range []struct {
+		name string
+		comp string
+	}{
+		{"bin/mana", "runtime"},
+		{"lib/libmana.so", "runtime"},
+		{"lib/libmana.so.1", "runtime"},
+		{"share/mana/data.txt", "runtime"},
+		{"include/mana/mana.h", "devel"},
+		{"include/mana/mana", "devel"},
+		{"src/mana.icc", "devel"},
+		{"lib/libmana.a", "devel"},
+		{"lib/pkgconfig/mana.pc", "devel"},
+		{"lib64/cmake/mana/manaConfig.cmake", "devel"},
+		{"share/mana/mana-config.cmake", "devel"},
+		{"lib/debug/lib/libmana.so.debug", "debug"},
+		{"lib/debug/usr/bin/mana", "debug"},
+		{"bin/mana.dwo", "debug"},
+		{"bin/mana.dSYM/Contents/Info.plist", "debug"},
+		// debug rules come before devel ones
+		{"lib/debug/include/mana.h", "debug"},
+	} {
+		comp := split.component(table.name)
+		if comp != table.comp {
+			t.Errorf("component(%q): got=%q want=%q", table.name, comp, table.comp)
+		}
+	}
+
+	// runtime rules come first
+	split.rules["runtime"] = []string{"lib/libmana-static.a", "include/mana/config/"}
+	for _, table := range []struct {
+		name string
+		comp string
+	}{
+		{"lib/libmana-static.a", "runtime"},
+		{"lib/libmana.a", "devel"},
+		{"include/mana/config/version.h", "runtime"},
+		{"include/mana/mana.h", "devel"},
+	} {
+		comp := split.component(table.name)
+		if comp != table.comp {
+			t.Errorf("component(%q): got=%q want=%q", table.name, comp, table.comp)
+		}
+	}
+
+	keep := split.keep("devel")
+	if !keep("include/mana/mana.h") || keep("bin/mana") {
+		t.Errorf("keep(devel) does not select the devel files")
+	}
+}
+
+func TestManifestAddEntries(t *testing.T) {
+	m := &bdist_manifest_t{Files: []bdist_file_t{
+		{Name: "bin/mana", Size: 2, Sha256: "old"},
+		{Name: "setup.sh", Size: 3, Sha256: "stale"},
+	}}
+	sum := func(s string) string { return fmt.Sprintf("%x", sha256.Sum256([]byte(s))) }
+	m.add_entries([]tar_entry_t{
+		{name: "./setup.sh", data: []byte("fresh")},
+		{name: "setup.csh", data: []byte("csh")},
+	})
+	want := []bdist_file_t{
+		{Name: "bin/mana", Size: 2, Sha256: "old"},
+		{Name: "setup.sh", Size: 5, Sha256: sum("fresh")},
+		{Name: "setup.csh", Size: 3, Sha256: sum("csh")},
+	}
+	if !reflect.DeepEqual(m.Files, want) {
+		t.Fatalf("add_entries:\ngot=  %+v\nwant= %+v", m.Files, want)
+	}
+}
+
+// EOF
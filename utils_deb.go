@@ -0,0 +1,365 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ar_write_header writes the global header of an ar archive
+func ar_write_header(w io.Writer) error {
+	_, err := io.WriteString(w, "!<arch>\n")
+	return err
+}
+
+// ar_write_member writes a member of an ar archive (common format, as used
+// by .deb files), padded to an even size.
+func ar_write_member(w io.Writer, name string, mtime time.Time, mode int64, size int64, r io.Reader) error {
+	if len(name) > 16 {
+		return fmt.Errorf("hwaf: ar member name too long [%s]", name)
+	}
+	hdr := fmt.Sprintf("%-16s%-12d%-6d%-6d%-8o%-10d`\n",
+		name, mtime.Unix(), 0, 0, mode, size,
+	)
+	_, err := io.WriteString(w, hdr)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(w, r)
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("hwaf: ar member [%s]: wrote %d bytes (expected %d)", name, n, size)
+	}
+	if size%2 != 0 {
+		_, err = io.WriteString(w, "\n")
+	}
+	return err
+}
+
+// deb_compressors lists the compression formats of the data archive of a
+// .deb file, with the suffix of the corresponding ar member.
+var deb_compressors = map[string]string{
+	"gz": ".tar.gz",
+	"xz": ".tar.xz",
+}
+
+// deb_compress returns a writer compressing into w with the given format.
+// xz compression needs the xz command.
+func deb_compress(w io.Writer, format string) (io.WriteCloser, error) {
+	if _, ok := deb_compressors[format]; !ok {
+		return nil, fmt.Errorf("hwaf: unknown compression format [%s] (expected gz or xz)", format)
+	}
+	return tar_compress(w, format)
+}
+
+// deb_data_t describes the data archive of a .deb file
+type deb_data_t struct {
+	md5sums bytes.Buffer // content of the DEBIAN/md5sums file
+	size    int64        // installed size (in KiB)
+}
+
+// deb_build_time returns the modification time of the entries of a .deb
+// file created from the tarball fname which are not in it (the top
+// directory of the data archive and the members of the ar archive):
+// $SOURCE_DATE_EPOCH, or the modification time of the newest entry of the
+// tarball.
+func deb_build_time(fname string) (time.Time, error) {
+	if t, ok := source_date_epoch(); ok {
+		return time.Unix(t, 0), nil
+	}
+	var mtime time.Time
+	r, err := tar_open(fname)
+	if err != nil {
+		return mtime, err
+	}
+	defer r.Close()
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return mtime, err
+		}
+		if hdr.ModTime.After(mtime) {
+			mtime = hdr.ModTime
+		}
+	}
+	return mtime, nil
+}
+
+// deb_write_data rewrites the tar archive src into the data archive of a
+// .deb file, dropping the first strip components of the names and
+// installing the files under prefix. The top directory is written with the
+// modification time mtime (see deb_build_time).
+func deb_write_data(w io.Writer, src *tar.Reader, strip int, prefix string, mtime time.Time) (*deb_data_t, error) {
+	data := &deb_data_t{}
+	tw := tar.NewWriter(w)
+
+	dirs := make(map[string]bool)
+	mkdir := func(dir string, mtime time.Time) error {
+		var parents []string
+		for d := dir; d != "." && d != "/" && !dirs[d]; d = path.Dir(d) {
+			parents = append([]string{d}, parents...)
+		}
+		for _, d := range parents {
+			dirs[d] = true
+			data.size++
+			err := tw.WriteHeader(&tar.Header{
+				Name:     "./" + d + "/",
+				Mode:     0755,
+				Typeflag: tar.TypeDir,
+				ModTime:  mtime,
+				Uname:    "root",
+				Gname:    "root",
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	err := tw.WriteHeader(&tar.Header{
+		Name:     "./",
+		Mode:     0755,
+		Typeflag: tar.TypeDir,
+		ModTime:  mtime,
+		Uname:    "root",
+		Gname:    "root",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	prefix = strings.Trim(path.Clean("/"+prefix), "/")
+	for {
+		hdr, err := src.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := strings.Trim(path.Clean("/"+hdr.Name), "/")
+		elems := strings.SplitN(name, "/", strip+1)
+		if len(elems) <= strip {
+			continue
+		}
+		name = path.Join(prefix, elems[strip])
+
+		if hdr.Typeflag == tar.TypeDir {
+			err = mkdir(name, hdr.ModTime)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		err = mkdir(path.Dir(name), hdr.ModTime)
+		if err != nil {
+			return nil, err
+		}
+
+		out := *hdr
+		out.Name = "./" + name
+		out.Uid, out.Gid = 0, 0
+		out.Uname, out.Gname = "root", "root"
+		if hdr.Typeflag == tar.TypeLink {
+			link := strings.Trim(path.Clean("/"+hdr.Linkname), "/")
+			if elems := strings.SplitN(link, "/", strip+1); len(elems) > strip {
+				out.Linkname = "./" + path.Join(prefix, elems[strip])
+			}
+		}
+		err = tw.WriteHeader(&out)
+		if err != nil {
+			return nil, err
+		}
+		data.size += (hdr.Size + 1023) / 1024
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			data.size++
+			continue
+		}
+		sum := md5.New()
+		_, err = io.Copy(io.MultiWriter(tw, sum), src)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&data.md5sums, "%x  %s\n", sum.Sum(nil), name)
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// deb_write_control writes the control archive of a .deb file
+func deb_write_control(w io.Writer, control []byte, md5sums []byte, mtime time.Time) error {
+	zw, err := gzip.NewWriterLevel(w, gzip.BestCompression)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(zw)
+	err = tw.WriteHeader(&tar.Header{
+		Name:     "./",
+		Mode:     0755,
+		Typeflag: tar.TypeDir,
+		ModTime:  mtime,
+		Uname:    "root",
+		Gname:    "root",
+	})
+	if err != nil {
+		return err
+	}
+	for _, file := range []struct {
+		name string
+		data []byte
+	}{
+		{"control", control},
+		{"md5sums", md5sums},
+	} {
+		err = tw.WriteHeader(&tar.Header{
+			Name:     "./" + file.name,
+			Mode:     0644,
+			Size:     int64(len(file.data)),
+			Typeflag: tar.TypeReg,
+			ModTime:  mtime,
+			Uname:    "root",
+			Gname:    "root",
+		})
+		if err != nil {
+			return err
+		}
+		_, err = tw.Write(file.data)
+		if err != nil {
+			return err
+		}
+	}
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// deb_write writes a .deb file made of the given control file and of the
+// content of the data archive stored in the file data.
+func deb_write(w io.Writer, control []byte, ctrl *deb_data_t, data *os.File, compression string, mtime time.Time) error {
+	ctrlbuf := new(bytes.Buffer)
+	err := deb_write_control(ctrlbuf, control, ctrl.md5sums.Bytes(), mtime)
+	if err != nil {
+		return err
+	}
+
+	fi, err := data.Stat()
+	if err != nil {
+		return err
+	}
+	_, err = data.Seek(0, 0)
+	if err != nil {
+		return err
+	}
+
+	err = ar_write_header(w)
+	if err != nil {
+		return err
+	}
+	for _, member := range []struct {
+		name string
+		size int64
+		r    io.Reader
+	}{
+		{"debian-binary", 4, strings.NewReader("2.0\n")},
+		{"control.tar.gz", int64(ctrlbuf.Len()), ctrlbuf},
+		{"data" + deb_compressors[compression], fi.Size(), data},
+	} {
+		err = ar_write_member(w, member.name, mtime, 0100644, member.size, member.r)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deb_pkg_name returns a valid Debian package name for name
+func deb_pkg_name(name string) string {
+	name = strings.ToLower(name)
+	out := make([]rune, 0, len(name))
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '+', r == '-', r == '.':
+			out = append(out, r)
+		default:
+			out = append(out, '-')
+		}
+	}
+	return strings.Trim(string(out), "-.")
+}
+
+// deb_depends returns the Debian dependencies on the upstream projects
+// listed in the local configuration (local.conf's projects).
+func deb_depends() ([]string, error) {
+	projs, err := upstream_projects()
+	if err != nil {
+		return nil, err
+	}
+	deps := make([]string, 0, len(projs))
+	for _, proj := range projs {
+		dep := deb_pkg_name(proj[0])
+		if proj[1] != "" {
+			dep += " (>= " + proj[1] + ")"
+		}
+		deps = append(deps, dep)
+	}
+	return deps, nil
+}
+
+// deb_data_reader returns a reader of the (compressed) data archive of the
+// .deb file r.
+func deb_data_reader(r io.Reader) (io.Reader, error) {
+	magic := make([]byte, 8)
+	_, err := io.ReadFull(r, magic)
+	if err != nil {
+		return nil, err
+	}
+	if string(magic) != "!<arch>\n" {
+		return nil, fmt.Errorf("hwaf: not a DEB file (invalid ar header)")
+	}
+	for {
+		hdr := make([]byte, 60)
+		_, err = io.ReadFull(r, hdr)
+		if err != nil {
+			if err == io.EOF {
+				err = fmt.Errorf("hwaf: no data archive in DEB file")
+			}
+			return nil, err
+		}
+		name := strings.TrimRight(strings.TrimSpace(string(hdr[:16])), "/")
+		size, err := strconv.ParseInt(strings.TrimSpace(string(hdr[48:58])), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("hwaf: invalid ar member [%s]: %v", name, err)
+		}
+		if strings.HasPrefix(name, "data.tar") {
+			return io.LimitReader(r, size), nil
+		}
+		_, err = io.CopyN(ioutil.Discard, r, size+size%2)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// EOF
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDebWriteMtime(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "hwaf-test-deb-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	// a 'hwaf bdist' tarball
+	fname := filepath.Join(tmpdir, "mana-1.0.tar.gz")
+	f, err := os.Create(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := gzip.NewWriter(f)
+	tw := tar.NewWriter(zw)
+	for _, e := range []struct {
+		name  string
+		data  string
+		mtime int64
+	}{
+		{"mana-1.0/bin/mana", "#!/bin/sh\n", 1300000000},
+		{"mana-1.0/lib/libmana.so", "lib", 1400000000},
+		{"mana-1.0/share/README", "readme", 1350000000},
+	} {
+		err = tw.WriteHeader(&tar.Header{
+			Name:     e.name,
+			Mode:     0644,
+			Size:     int64(len(e.data)),
+			Typeflag: tar.TypeReg,
+			ModTime:  time.Unix(e.mtime, 0),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = tw.Write([]byte(e.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range []io.Closer{tw, zw, f} {
+		err = c.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mkdeb := func() []byte {
+		mtime, err := deb_build_time(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		src, err := tar_open(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer src.Close()
+		data, err := ioutil.TempFile(tmpdir, "data-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer data.Close()
+		zw := gzip.NewWriter(data)
+		ddata, err := deb_write_data(zw, tar.NewReader(src), 1, "/", mtime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = zw.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := new(bytes.Buffer)
+		err = deb_write(buf, []byte("Package: mana\n"), ddata, data, "gz", mtime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	// ar_mtimes returns the modification times of the members of an ar
+	// archive and the content of its data.tar.gz member
+	ar_mtimes := func(deb []byte) ([]int64, []byte) {
+		mtimes := []int64{}
+		var data []byte
+		for buf := deb[len("!<arch>\n"):]; len(buf) >= 60; {
+			hdr := string(buf[:60])
+			mtime, err := strconv.ParseInt(strings.TrimSpace(hdr[16:28]), 10, 64)
+			if err != nil {
+				t.Fatalf("invalid ar header %q: %v", hdr, err)
+			}
+			size, err := strconv.ParseInt(strings.TrimSpace(hdr[48:58]), 10, 64)
+			if err != nil {
+				t.Fatalf("invalid ar header %q: %v", hdr, err)
+			}
+			mtimes = append(mtimes, mtime)
+			if strings.TrimSpace(hdr[:16]) == "data.tar.gz" {
+				data = buf[60 : 60+size]
+			}
+			buf = buf[60+size+size%2:]
+		}
+		return mtimes, data
+	}
+
+	for _, table := range []struct {
+		epoch string
+		want  int64
+	}{
+		{"", 1400000000}, // the newest file of the tarball
+		{"1357000000", 1357000000},
+	} {
+		func() {
+			defer os.Setenv("SOURCE_DATE_EPOCH", os.Getenv("SOURCE_DATE_EPOCH"))
+			os.Setenv("SOURCE_DATE_EPOCH", table.epoch)
+
+			deb := mkdeb()
+			time.Sleep(1100 * time.Millisecond)
+			if !bytes.Equal(deb, mkdeb()) {
+				t.Errorf("epoch=%q: DEB depends on the time it is built", table.epoch)
+			}
+
+			mtimes, data := ar_mtimes(deb)
+			if len(mtimes) != 3 {
+				t.Fatalf("epoch=%q: invalid ar members (mtimes=%v)", table.epoch, mtimes)
+			}
+			for _, mtime := range mtimes {
+				if mtime != table.want {
+					t.Errorf("epoch=%q: ar member mtime=%d, want=%d", table.epoch, mtime, table.want)
+				}
+			}
+
+			zr, err := gzip.NewReader(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			hdr, err := tar.NewReader(zr).Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hdr.Name != "./" || hdr.ModTime.Unix() != table.want {
+				t.Errorf("epoch=%q: top directory %q mtime=%d, want=%d",
+					table.epoch, hdr.Name, hdr.ModTime.Unix(), table.want,
+				)
+			}
+		}()
+	}
+}
+
+// EOF
@@ -0,0 +1,757 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// env_action is the kind of modification applied to an environment variable
+type env_action int
+
+const (
+	env_set     env_action = iota // set the variable to a value
+	env_unset                     // remove the variable from the environment
+	env_prepend                   // prepend a value to a path-like variable
+	env_append                    // append a value to a path-like variable
+)
+
+var env_action_names = map[env_action]string{
+	env_set:     "set",
+	env_unset:   "unset",
+	env_prepend: "prepend",
+	env_append:  "append",
+}
+
+func (a env_action) String() string {
+	return env_action_names[a]
+}
+
+func (a env_action) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+func (a *env_action) UnmarshalJSON(data []byte) error {
+	var name string
+	err := json.Unmarshal(data, &name)
+	if err != nil {
+		return err
+	}
+	for k, v := range env_action_names {
+		if v == name {
+			*a = k
+			return nil
+		}
+	}
+	return fmt.Errorf("hwaf: unknown environment action [%s]", name)
+}
+
+// env_op_t describes a modification of an environment variable
+type env_op_t struct {
+	Action env_action `json:"action"`
+	Key    string     `json:"key"`
+	Value  string     `json:"value,omitempty"`
+
+	// Root, if not empty, is a directory whose occurrences in Value are
+	// written by the shell formats (sh, csh, fish) as references to the
+	// env_root_var variable. (used by relocatable setup scripts)
+	Root string `json:"-"`
+}
+
+// env_root_var is the name of the shell variable holding the directory
+// which replaces env_op_t.Root
+const env_root_var = "_hwaf_setup_dir"
+
+// env_quote_root quotes v with quote, replacing the occurrences of the
+// directory root by ref.
+func env_quote_root(v, root string, quote func(string) string, ref string) string {
+	if root == "" {
+		return quote(v)
+	}
+	out := ""
+	lit := ""
+	for v != "" {
+		idx := strings.Index(v, root)
+		if idx < 0 {
+			lit += v
+			break
+		}
+		end := idx + len(root)
+		if end < len(v) && v[end] != '/' && v[end] != os.PathListSeparator {
+			// not a path prefix (eg: /opt/foo vs /opt/foobar)
+			lit += v[:end]
+			v = v[end:]
+			continue
+		}
+		lit += v[:idx]
+		if lit != "" {
+			out += quote(lit)
+			lit = ""
+		}
+		out += ref
+		v = v[end:]
+	}
+	if lit != "" || out == "" {
+		out += quote(lit)
+	}
+	return out
+}
+
+func sh_value(op env_op_t) string {
+	return env_quote_root(op.Value, op.Root, sh_quote, `"${`+env_root_var+`}"`)
+}
+
+func csh_value(op env_op_t) string {
+	return env_quote_root(op.Value, op.Root, csh_quote, `"${`+env_root_var+`}"`)
+}
+
+func fish_value(v, root string) string {
+	return env_quote_root(v, root, fish_quote, `"$`+env_root_var+`"`)
+}
+
+// env_writer writes a list of environment modifications in a given format
+type env_writer func(w io.Writer, ops []env_op_t) error
+
+// env_formats lists the output formats known to hwaf, indexed by name.
+var env_formats = map[string]env_writer{
+	"sh":         env_write_sh,
+	"csh":        env_write_csh,
+	"fish":       env_write_fish,
+	"json":       env_write_json,
+	"dotenv":     env_write_dotenv,
+	"modulefile": env_write_modulefile,
+	"lua":        env_write_lua,
+}
+
+// env_format_aliases maps alternative names (shells, tools) to a format name
+var env_format_aliases = map[string]string{
+	"bash":    "sh",
+	"ksh":     "sh",
+	"zsh":     "sh",
+	"tcsh":    "csh",
+	"env":     "dotenv",
+	"tcl":     "modulefile",
+	"modules": "modulefile",
+	"lmod":    "lua",
+}
+
+// env_format_names returns the sorted list of known output formats
+func env_format_names() []string {
+	names := make([]string, 0, len(env_formats))
+	for k := range env_formats {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// env_writer_for returns the writer for the given format (or shell) name
+func env_writer_for(name string) (env_writer, error) {
+	if alias, ok := env_format_aliases[name]; ok {
+		name = alias
+	}
+	w, ok := env_formats[name]
+	if !ok {
+		return nil, fmt.Errorf(
+			"hwaf: unknown environment format [%s] (known formats: %s)",
+			name,
+			strings.Join(env_format_names(), ", "),
+		)
+	}
+	return w, nil
+}
+
+// env_set_ops returns the list of operations setting all the variables of
+// env, in a deterministic order.
+func env_set_ops(env map[string]string) []env_op_t {
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	ops := make([]env_op_t, 0, len(keys))
+	for _, k := range keys {
+		ops = append(ops, env_op_t{Action: env_set, Key: k, Value: env[k]})
+	}
+	return ops
+}
+
+var env_pathsep = string(os.PathListSeparator)
+
+// env_session_vars lists the variables describing the user's session, which
+// are never taken from a project's runtime environment.
+var env_session_vars = map[string]bool{
+	"_":        true,
+	"DISPLAY":  true,
+	"EDITOR":   true,
+	"HISTORY":  true,
+	"HISTSIZE": true,
+	"HOME":     true,
+	"LANG":     true,
+	"LC_ALL":   true,
+	"OLDPWD":   true,
+	"PS1":      true,
+	"PWD":      true,
+	"SHELL":    true,
+	"SHLVL":    true,
+	"TERM":     true,
+	"TERMCAP":  true,
+	"USER":     true,
+}
+
+// environ_map converts a list of "key=value" strings (as returned by
+// os.Environ) into a map.
+func environ_map(environ []string) map[string]string {
+	env := make(map[string]string, len(environ))
+	for _, kv := range environ {
+		idx := strings.Index(kv, "=")
+		if idx <= 0 {
+			// skip malformed entries (and windows' per-drive "=C:" ones)
+			continue
+		}
+		env[kv[:idx]] = kv[idx+1:]
+	}
+	return env
+}
+
+// env_is_pathlike returns whether a variable holds a list of paths
+// (eg: PATH, LD_LIBRARY_PATH, XDG_DATA_DIRS)
+func env_is_pathlike(k string) bool {
+	return strings.HasSuffix(k, "PATH") ||
+		strings.HasSuffix(k, "_PATHS") ||
+		strings.HasSuffix(k, "_DIRS")
+}
+
+// env_diff returns the list of operations turning the environment old into
+// the environment new, in a deterministic order.
+// Path-like variables are, when possible, expressed as prepend and append
+// operations on their old value.
+func env_diff(old, new map[string]string) []env_op_t {
+	keys := make([]string, 0, len(old)+len(new))
+	for k := range new {
+		keys = append(keys, k)
+	}
+	for k := range old {
+		if _, ok := new[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	ops := make([]env_op_t, 0, len(keys))
+	for _, k := range keys {
+		nv, nok := new[k]
+		ov, ook := old[k]
+		switch {
+		case !nok:
+			ops = append(ops, env_op_t{Action: env_unset, Key: k})
+		case ook && ov == nv:
+			// unchanged
+		case ook && ov != "" && env_is_pathlike(k):
+			ops = append(ops, env_path_diff(k, ov, nv)...)
+		default:
+			ops = append(ops, env_op_t{Action: env_set, Key: k, Value: nv})
+		}
+	}
+	return ops
+}
+
+// env_path_diff expresses the new value of a path-like variable as prepend
+// and/or append operations on its old value, if the old value is still there
+// in one piece (entries may have been dropped, eg: non-existing directories,
+// but not re-ordered.)
+func env_path_diff(k, old, new string) []env_op_t {
+	oelems := strings.Split(old, env_pathsep)
+	nelems := strings.Split(new, env_pathsep)
+
+	oidx := make(map[string]int, len(oelems))
+	for i, e := range oelems {
+		if _, dup := oidx[e]; !dup {
+			oidx[e] = i
+		}
+	}
+
+	first, last := -1, -1
+	for i, e := range nelems {
+		if _, ok := oidx[e]; ok {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+
+	set := []env_op_t{{Action: env_set, Key: k, Value: new}}
+	if first < 0 {
+		return set
+	}
+	prev := -1
+	for _, e := range nelems[first : last+1] {
+		j, ok := oidx[e]
+		if !ok || j <= prev {
+			return set
+		}
+		prev = j
+	}
+
+	ops := make([]env_op_t, 0, 2)
+	if pre := nelems[:first]; len(pre) > 0 {
+		ops = append(ops, env_op_t{
+			Action: env_prepend,
+			Key:    k,
+			Value:  strings.Join(pre, env_pathsep),
+		})
+	}
+	if post := nelems[last+1:]; len(post) > 0 {
+		ops = append(ops, env_op_t{
+			Action: env_append,
+			Key:    k,
+			Value:  strings.Join(post, env_pathsep),
+		})
+	}
+	return ops
+}
+
+// env_apply applies the operations ops on the environment env.
+// The entries prepended or appended to a variable are first removed from
+// its current value, so applying ops on an environment where they were
+// already applied yields the same value.
+func env_apply(env map[string]string, ops []env_op_t) {
+	for _, op := range ops {
+		v, ok := env[op.Key]
+		if ok && (op.Action == env_prepend || op.Action == env_append) {
+			v = env_path_remove(v, op.Value)
+		}
+		switch op.Action {
+		case env_set:
+			env[op.Key] = op.Value
+		case env_unset:
+			delete(env, op.Key)
+		case env_prepend:
+			if ok && v != "" {
+				env[op.Key] = op.Value + env_pathsep + v
+			} else {
+				env[op.Key] = op.Value
+			}
+		case env_append:
+			if ok && v != "" {
+				env[op.Key] = v + env_pathsep + op.Value
+			} else {
+				env[op.Key] = op.Value
+			}
+		}
+	}
+}
+
+// env_path_remove removes the entries of the path list entries from the
+// path list v
+func env_path_remove(v, entries string) string {
+	drop := make(map[string]bool)
+	for _, e := range strings.Split(entries, env_pathsep) {
+		drop[e] = true
+	}
+	out := []string{}
+	for _, e := range strings.Split(v, env_pathsep) {
+		if !drop[e] {
+			out = append(out, e)
+		}
+	}
+	return strings.Join(out, env_pathsep)
+}
+
+// environ_list converts an environment map into a sorted list of
+// "key=value" strings (as expected by os/exec.Cmd.Env)
+func environ_list(env map[string]string) []string {
+	environ := make([]string, 0, len(env))
+	for k, v := range env {
+		environ = append(environ, k+"="+v)
+	}
+	sort.Strings(environ)
+	return environ
+}
+
+// env_undo returns the list of operations reverting the operations ops,
+// which were applied on the environment old.
+func env_undo(old map[string]string, ops []env_op_t) []env_op_t {
+	undo := make([]env_op_t, 0, len(ops))
+	seen := make(map[string]bool, len(ops))
+	for _, op := range ops {
+		if seen[op.Key] {
+			continue
+		}
+		seen[op.Key] = true
+		if v, ok := old[op.Key]; ok {
+			undo = append(undo, env_op_t{Action: env_set, Key: op.Key, Value: v})
+		} else {
+			undo = append(undo, env_op_t{Action: env_unset, Key: op.Key})
+		}
+	}
+	return undo
+}
+
+// sh_quote quotes a string for POSIX shells: everything is single-quoted,
+// embedded single quotes are closed, escaped and reopened.
+func sh_quote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// csh_quote quotes a string for (t)csh: history expansion ('!') happens even
+// inside single quotes and newlines have to be escaped.
+func csh_quote(s string) string {
+	s = strings.Replace(s, "'", `'\''`, -1)
+	s = strings.Replace(s, "!", `\!`, -1)
+	s = strings.Replace(s, "\n", "\\\n", -1)
+	return "'" + s + "'"
+}
+
+// fish_quote quotes a string for fish: only \ and ' are special inside
+// single quotes.
+func fish_quote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, "'", `\'`, -1)
+	return "'" + s + "'"
+}
+
+// tcl_quote quotes a string as a double-quoted Tcl word
+func tcl_quote(s string) string {
+	buf := make([]byte, 0, len(s)+2)
+	buf = append(buf, '"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\', '"', '$', '[', ']':
+			buf = append(buf, '\\', c)
+		case '\n':
+			buf = append(buf, '\\', 'n')
+		case '\t':
+			buf = append(buf, '\\', 't')
+		default:
+			buf = append(buf, c)
+		}
+	}
+	buf = append(buf, '"')
+	return string(buf)
+}
+
+// lua_quote quotes a string as a double-quoted Lua (5.1) string literal
+func lua_quote(s string) string {
+	buf := make([]byte, 0, len(s)+2)
+	buf = append(buf, '"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' || c == '"':
+			buf = append(buf, '\\', c)
+		case c == '\n':
+			buf = append(buf, '\\', 'n')
+		case c == '\t':
+			buf = append(buf, '\\', 't')
+		case c < 0x20 || c == 0x7f:
+			buf = append(buf, []byte(fmt.Sprintf("\\%03d", c))...)
+		default:
+			buf = append(buf, c)
+		}
+	}
+	buf = append(buf, '"')
+	return string(buf)
+}
+
+// dotenv_escape escapes a string to be embedded in a double-quoted .env value
+func dotenv_escape(s string) string {
+	r := strings.NewReplacer(
+		`\`, `\\`,
+		`"`, `\"`,
+		`$`, `\$`,
+		"\n", `\n`,
+	)
+	return r.Replace(s)
+}
+
+// dotenv_quote quotes a string for .env files: single quotes (no escapes,
+// no interpolation) when possible, double quotes otherwise.
+func dotenv_quote(s string) string {
+	if !strings.ContainsAny(s, "'\n") {
+		return "'" + s + "'"
+	}
+	return `"` + dotenv_escape(s) + `"`
+}
+
+// re_env_key matches the variable names shells and modules can handle
+var re_env_key = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// env_writable returns whether the variable of op can be written in a
+// setup script (eg: not exported bash functions, BASH_FUNC_module%%)
+func env_writable(op env_op_t) bool {
+	if re_env_key.MatchString(op.Key) {
+		return true
+	}
+	g_ctx.Debugf("hwaf: skipping variable with an invalid name [%s]\n", op.Key)
+	return false
+}
+
+func env_write_sh(w io.Writer, ops []env_op_t) error {
+	buf := new(bytes.Buffer)
+	for _, op := range ops {
+		if !env_writable(op) {
+			continue
+		}
+		k := op.Key
+		switch op.Action {
+		case env_set:
+			fmt.Fprintf(buf, "export %s=%s\n", k, sh_value(op))
+		case env_unset:
+			fmt.Fprintf(buf, "unset %s\n", k)
+		case env_prepend:
+			fmt.Fprintf(buf, "export %s=%s\"${%s:+%s${%s}}\"\n",
+				k, sh_value(op), k, env_pathsep, k,
+			)
+		case env_append:
+			fmt.Fprintf(buf, "export %s=\"${%s:+${%s}%s}\"%s\n",
+				k, k, k, env_pathsep, sh_value(op),
+			)
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func env_write_csh(w io.Writer, ops []env_op_t) error {
+	buf := new(bytes.Buffer)
+	for _, op := range ops {
+		if !env_writable(op) {
+			continue
+		}
+		k := op.Key
+		switch op.Action {
+		case env_set:
+			fmt.Fprintf(buf, "setenv %s %s\n", k, csh_value(op))
+		case env_unset:
+			fmt.Fprintf(buf, "unsetenv %s\n", k)
+		case env_prepend, env_append:
+			v := csh_value(op) + env_pathsep + `"${` + k + `}"`
+			if op.Action == env_append {
+				v = `"${` + k + `}"` + env_pathsep + csh_value(op)
+			}
+			fmt.Fprintf(buf, "if ( $?%s ) then\n", k)
+			fmt.Fprintf(buf, "  setenv %s %s\n", k, v)
+			fmt.Fprintf(buf, "else\n")
+			fmt.Fprintf(buf, "  setenv %s %s\n", k, csh_value(op))
+			fmt.Fprintf(buf, "endif\n")
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// fish_is_path returns whether fish handles the variable as a colon-separated
+// list (fish >= 3.0: all variables whose name ends with PATH)
+func fish_is_path(k string) bool {
+	return strings.HasSuffix(k, "PATH")
+}
+
+func fish_list(k, v, root string) string {
+	if !fish_is_path(k) {
+		return fish_value(v, root)
+	}
+	elems := strings.Split(v, env_pathsep)
+	for i, e := range elems {
+		elems[i] = fish_value(e, root)
+	}
+	return strings.Join(elems, " ")
+}
+
+func env_write_fish(w io.Writer, ops []env_op_t) error {
+	buf := new(bytes.Buffer)
+	for _, op := range ops {
+		if !env_writable(op) {
+			continue
+		}
+		k := op.Key
+		switch op.Action {
+		case env_set:
+			fmt.Fprintf(buf, "set -gx %s %s\n", k, fish_list(k, op.Value, op.Root))
+		case env_unset:
+			fmt.Fprintf(buf, "set -e %s\n", k)
+		case env_prepend, env_append:
+			if fish_is_path(k) {
+				v := fish_list(k, op.Value, op.Root) + " $" + k
+				if op.Action == env_append {
+					v = "$" + k + " " + fish_list(k, op.Value, op.Root)
+				}
+				fmt.Fprintf(buf, "set -gx %s %s\n", k, v)
+				continue
+			}
+			v := fish_value(op.Value+env_pathsep, op.Root) + "\"$" + k + "\""
+			if op.Action == env_append {
+				v = "\"$" + k + "\"" + fish_value(env_pathsep+op.Value, op.Root)
+			}
+			fmt.Fprintf(buf, "if set -q %s; set -gx %s %s; else; set -gx %s %s; end\n",
+				k, k, v, k, fish_value(op.Value, op.Root),
+			)
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// env_write_json writes the environment as a JSON object when only setting
+// variables, and as a JSON array of operations otherwise.
+func env_write_json(w io.Writer, ops []env_op_t) error {
+	var data interface{} = ops
+	env := make(map[string]string, len(ops))
+	for _, op := range ops {
+		if op.Action != env_set {
+			env = nil
+			break
+		}
+		env[op.Key] = op.Value
+	}
+	if env != nil {
+		data = env
+	}
+	buf, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	buf = append(buf, '\n')
+	_, err = w.Write(buf)
+	return err
+}
+
+func env_write_dotenv(w io.Writer, ops []env_op_t) error {
+	buf := new(bytes.Buffer)
+	for _, op := range ops {
+		if !env_writable(op) {
+			continue
+		}
+		k := op.Key
+		switch op.Action {
+		case env_set:
+			fmt.Fprintf(buf, "%s=%s\n", k, dotenv_quote(op.Value))
+		case env_unset:
+			// K= would set K to an empty value
+			fmt.Fprintf(buf, "# unset %s (not expressible in a .env file)\n", k)
+		case env_prepend:
+			fmt.Fprintf(buf, "%s=\"%s%s${%s}\"\n",
+				k, dotenv_escape(op.Value), env_pathsep, k,
+			)
+		case env_append:
+			fmt.Fprintf(buf, "%s=\"${%s}%s%s\"\n",
+				k, k, env_pathsep, dotenv_escape(op.Value),
+			)
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func env_write_modulefile(w io.Writer, ops []env_op_t) error {
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "#%%Module1.0\n")
+	for _, op := range ops {
+		if !env_writable(op) {
+			continue
+		}
+		k := op.Key
+		switch op.Action {
+		case env_set:
+			fmt.Fprintf(buf, "setenv %s %s\n", k, tcl_quote(op.Value))
+		case env_unset:
+			fmt.Fprintf(buf, "unsetenv %s\n", k)
+		case env_prepend:
+			fmt.Fprintf(buf, "prepend-path %s %s\n", k, tcl_quote(op.Value))
+		case env_append:
+			fmt.Fprintf(buf, "append-path %s %s\n", k, tcl_quote(op.Value))
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func env_write_lua(w io.Writer, ops []env_op_t) error {
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "-- -*- lua -*-\n")
+	for _, op := range ops {
+		if !env_writable(op) {
+			continue
+		}
+		k := lua_quote(op.Key)
+		switch op.Action {
+		case env_set:
+			fmt.Fprintf(buf, "setenv(%s, %s)\n", k, lua_quote(op.Value))
+		case env_unset:
+			fmt.Fprintf(buf, "unsetenv(%s)\n", k)
+		case env_prepend:
+			fmt.Fprintf(buf, "prepend_path(%s, %s)\n", k, lua_quote(op.Value))
+		case env_append:
+			fmt.Fprintf(buf, "append_path(%s, %s)\n", k, lua_quote(op.Value))
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// alias_writer writes a list of (alias, command) pairs as alias definitions
+type alias_writer func(w io.Writer, aliases [][2]string) error
+
+// alias_formats lists the shells hwaf can write alias definitions for.
+// Other shell names are resolved with env_format_aliases.
+var alias_formats = map[string]alias_writer{
+	"sh":   alias_write_sh,
+	"csh":  alias_write_csh,
+	"fish": alias_write_fish,
+}
+
+// alias_writer_for returns the alias writer for the given shell name
+func alias_writer_for(name string) (alias_writer, error) {
+	if alias, ok := env_format_aliases[name]; ok {
+		name = alias
+	}
+	w, ok := alias_formats[name]
+	if !ok {
+		names := make([]string, 0, len(alias_formats))
+		for k := range alias_formats {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf(
+			"hwaf: no alias support for shell [%s] (known shells: %s)",
+			name,
+			strings.Join(names, ", "),
+		)
+	}
+	return w, nil
+}
+
+func alias_write_sh(w io.Writer, aliases [][2]string) error {
+	buf := new(bytes.Buffer)
+	for _, alias := range aliases {
+		fmt.Fprintf(buf, "alias %s=%s\n", alias[0], sh_quote(alias[1]))
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func alias_write_csh(w io.Writer, aliases [][2]string) error {
+	buf := new(bytes.Buffer)
+	for _, alias := range aliases {
+		fmt.Fprintf(buf, "alias %s %s\n", alias[0], csh_quote(alias[1]))
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func alias_write_fish(w io.Writer, aliases [][2]string) error {
+	buf := new(bytes.Buffer)
+	for _, alias := range aliases {
+		fmt.Fprintf(buf, "alias %s %s\n", alias[0], fish_quote(alias[1]))
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// EOF
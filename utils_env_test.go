@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEnvWriteDotenv(t *testing.T) {
+	test_init_context(t)
+
+	buf := new(bytes.Buffer)
+	err := env_write_dotenv(buf, []env_op_t{
+		{Action: env_set, Key: "MANA_ROOT", Value: "/opt/mana"},
+		{Action: env_set, Key: "MANA_MSG", Value: "it's"},
+		{Action: env_unset, Key: "MANA_DEBUG"},
+		{Action: env_prepend, Key: "PATH", Value: "/opt/mana/bin"},
+		{Action: env_append, Key: "MANA_PATH", Value: "/opt/mana/share"},
+		{Action: env_set, Key: "BASH_FUNC_module%%", Value: "() { :; }"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "MANA_ROOT='/opt/mana'\n" +
+		"MANA_MSG=\"it's\"\n" +
+		"# unset MANA_DEBUG (not expressible in a .env file)\n" +
+		"PATH=\"/opt/mana/bin" + env_pathsep + "${PATH}\"\n" +
+		"MANA_PATH=\"${MANA_PATH}" + env_pathsep + "/opt/mana/share\"\n"
+	if got := buf.String(); got != want {
+		t.Fatalf("invalid .env file:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// EOF
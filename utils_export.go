@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/hwaf/hwaf/hlib"
+	"github.com/hwaf/hwaf/hwaflib"
+)
+
+// export_project_t describes the local project for the recipes of other
+// package managers (see 'hwaf export')
+type export_project_t struct {
+	Name        string         // name of the project
+	Version     string         // version of the project
+	Url         string         // URL home page
+	Source      string         // URL of the source distribution
+	Sha256      string         // SHA-256 of the source distribution (if known)
+	HwafVersion string         // version of hwaf generating the recipe
+	Projects    []export_dep_t // upstream projects
+	Deps        []export_dep_t // external dependencies of the packages
+}
+
+// export_dep_t is a dependency of the project, named after the conventions
+// of the target package manager
+type export_dep_t struct {
+	Name    string   // name of the dependency for the package manager
+	Version string   // version (or version constraint) of the dependency
+	Option  string   // name of the --with-<option> configure option
+	Runtime bool     // whether the dependency is needed at runtime
+	Link    bool     // whether the dependency is needed to build/link
+	Pkgs    []string // packages of the project needing it
+}
+
+// export_new_project describes the local project for the package manager
+// tool (spack, easybuild).
+//
+// External dependencies are the dependencies of the packages (from their
+// hscript.yml) which are not packages of the project. They are renamed
+// after the [hwaf-export-<tool>] section of the configuration:
+//
+//	[hwaf-export-spack]
+//	External/AtlasROOT = root@5.34:
+//	AtlasPolicy =
+//
+// ie: <dependency> = <name>[@<version>] (spack) or <name> [<version>]
+// (easybuild). An empty value drops the dependency.
+func export_new_project(tool, url, source, sha256 string) (*export_project_t, error) {
+	pinfos, err := g_ctx.ProjectInfos()
+	if err != nil {
+		return nil, err
+	}
+	name, err := pinfos.Get("HWAF_PROJECT_NAME")
+	if err != nil {
+		return nil, err
+	}
+	vers, err := pinfos.Get("HWAF_PROJECT_VERSION")
+	if err != nil || vers == "" {
+		return nil, fmt.Errorf("hwaf: project [%s] has no version (HWAF_PROJECT_VERSION)", name)
+	}
+
+	if url == "" {
+		url = "http://cern.ch/mana-fwk"
+	}
+	if source == "" {
+		source = strings.TrimSuffix(url, "/") + "/" + name + "-" + vers + ".tar.gz"
+	}
+	if !strings.Contains(source, "://") {
+		// a local source distribution
+		fname, err := filepath.Abs(os.ExpandEnv(source))
+		if err != nil {
+			return nil, err
+		}
+		if sha256 == "" && path_exists(fname) {
+			sha256, err = sha256_file(fname)
+			if err != nil {
+				return nil, err
+			}
+		}
+		source = "file://" + filepath.ToSlash(fname)
+	}
+	if sha256 == "" {
+		g_ctx.Warnf("no checksum for the source distribution [%s] (use -sha256)\n", source)
+	}
+
+	proj := &export_project_t{
+		Name:        name,
+		Version:     vers,
+		Url:         url,
+		Source:      source,
+		Sha256:      sha256,
+		HwafVersion: g_ctx.Version(),
+		Projects:    []export_dep_t{},
+		Deps:        []export_dep_t{},
+	}
+
+	projs, err := upstream_projects()
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range projs {
+		proj.Projects = append(proj.Projects, export_dep_t{
+			Name:    export_name(tool, p[0]),
+			Version: p[1],
+			Option:  p[0],
+			Runtime: true,
+			Link:    true,
+		})
+	}
+
+	deps, err := export_pkg_deps()
+	if err != nil {
+		return nil, err
+	}
+	for _, dep := range deps {
+		value := cfg_string("hwaf-export-"+tool, dep.Name, dep.Name)
+		dep.Option = strings.ToLower(path.Base(dep.Name))
+		switch value {
+		case dep.Name:
+			// not renamed
+			dep.Name = export_name(tool, path.Base(dep.Name))
+		case "":
+			// dropped
+			continue
+		default:
+			dep.Name, dep.Version = export_split_dep(tool, value)
+		}
+		proj.Deps = append(proj.Deps, dep)
+	}
+	return proj, nil
+}
+
+// export_pkg_deps returns the dependencies of the packages of the local
+// project which are not packages of the project, sorted by name
+func export_pkg_deps() ([]export_dep_t, error) {
+	workdir, err := g_ctx.Workarea()
+	if err != nil {
+		return nil, err
+	}
+	pkgdir := cfg_string("hwaf-cfg", "pkgdir", "src")
+
+	internal := make(map[string]bool)
+	hscripts := make(map[string]*hlib.Wscript_t)
+	for _, pkgname := range g_ctx.PkgDb.Pkgs() {
+		pkg, err := g_ctx.PkgDb.GetPkg(pkgname)
+		if err != nil {
+			return nil, err
+		}
+		rel := strings.TrimPrefix(filepath.ToSlash(pkg.Path), pkgdir+"/")
+		internal[rel] = true
+		internal[path.Base(rel)] = true
+
+		fname := filepath.Join(workdir, pkg.Path, "hscript.yml")
+		if !path_exists(fname) {
+			g_ctx.Debugf("hwaf: no hscript.yml for package [%s]\n", pkg.Path)
+			continue
+		}
+		wscript, err := hwaflib.LoadHscript(fname)
+		if err != nil {
+			return nil, err
+		}
+		internal[wscript.Package.Name] = true
+		hscripts[rel] = wscript
+	}
+
+	deps := make(map[string]*export_dep_t)
+	for pkg, wscript := range hscripts {
+		for _, d := range wscript.Package.Deps {
+			if internal[d.Name] || internal[path.Base(d.Name)] {
+				continue
+			}
+			dep, ok := deps[d.Name]
+			if !ok {
+				dep = &export_dep_t{Name: d.Name, Version: string(d.Version)}
+				deps[d.Name] = dep
+			}
+			if d.Type.HasMask(hlib.RuntimeDep) {
+				dep.Runtime = true
+			}
+			if d.Type.HasMask(hlib.PublicDep | hlib.PrivateDep) {
+				dep.Link = true
+			}
+			dep.Pkgs = append(dep.Pkgs, pkg)
+		}
+	}
+
+	out := make([]export_dep_t, 0, len(deps))
+	for _, dep := range deps {
+		sort.Strings(dep.Pkgs)
+		out = append(out, *dep)
+	}
+	sort.Sort(export_deps(out))
+	return out, nil
+}
+
+type export_deps []export_dep_t
+
+func (p export_deps) Len() int           { return len(p) }
+func (p export_deps) Less(i, j int) bool { return p[i].Name < p[j].Name }
+func (p export_deps) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// export_name returns the default name of the dependency name for the
+// package manager tool
+func export_name(tool, name string) string {
+	if tool == "spack" {
+		// spack packages are lower case, with dashes
+		return strings.Replace(strings.ToLower(name), "_", "-", -1)
+	}
+	return name
+}
+
+// export_split_dep splits a dependency of the [hwaf-export-<tool>] section
+// into its name and version
+func export_split_dep(tool, value string) (string, string) {
+	value = strings.TrimSpace(value)
+	if tool == "spack" {
+		if i := strings.Index(value, "@"); i >= 0 {
+			return value[:i], value[i+1:]
+		}
+		return value, ""
+	}
+	toks := strings.Fields(value)
+	if len(toks) > 1 {
+		return toks[0], toks[1]
+	}
+	return value, ""
+}
+
+// spack_class_name returns the name of the spack class of the package name
+// (eg: mana-core -> ManaCore)
+func spack_class_name(name string) string {
+	out := []rune{}
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		out = append(out, r)
+	}
+	if len(out) == 0 || unicode.IsDigit(out[0]) {
+		out = append([]rune("Hwaf"), out...)
+	}
+	return string(out)
+}
+
+// easybuild_root_var returns the name of the variable EasyBuild sets to the
+// installation directory of the module name (eg: mana-core -> EBROOTMANAMINUSCORE)
+func easybuild_root_var(name string) string {
+	name = strings.ToUpper(name)
+	name = strings.Replace(name, "-", "MINUS", -1)
+	name = strings.Replace(name, "+", "PLUS", -1)
+	return "EBROOT" + name
+}
+
+// py_quote returns s as a python string literal
+func py_quote(s string) string {
+	return "'" + strings.Replace(strings.Replace(s, `\`, `\\`, -1), "'", `\'`, -1) + "'"
+}
+
+// EOF
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// bdist_check_signature checks the signature of the binary distribution
+// fname. Unsigned or mis-signed distributions are refused, unless insecure
+// is set.
+func bdist_check_signature(fname string, insecure bool) error {
+	k, err := sig_verify(fname)
+	switch {
+	case err == nil:
+		g_ctx.Infof("[%s] signed by [%s] (%s)\n", filepath.Base(fname), k.Name, k.Id)
+		return nil
+	case insecure:
+		g_ctx.Warnf("%v (installing it anyway)\n", err)
+		return nil
+	case err == err_unsigned:
+		return fmt.Errorf("hwaf: [%s] is not signed (use -insecure to install it anyway)", fname)
+	}
+	return fmt.Errorf("%v (use -insecure to install it anyway)", err)
+}
+
+// bdist_install unpacks the binary distribution tarball fname under the
+// directory destdir, after checking its signature (see
+// bdist_check_signature), and relocates the installed files from the
+// prefix the distribution was built for (see bdist_relocate). It returns
+// the list of the top-level directories it installed.
+func bdist_install(fname, destdir string, insecure bool) ([]string, error) {
+	err := bdist_check_signature(fname, insecure)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(destdir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := tar_open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	tops := []string{}
+	seen := make(map[string]bool)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := path.Clean(hdr.Name)
+		if !install_is_local(name) {
+			return nil, fmt.Errorf("hwaf: [%s]: refusing to install [%s] outside of [%s]", fname, hdr.Name, destdir)
+		}
+		if name == "." {
+			continue
+		}
+		if top := strings.SplitN(name, "/", 2)[0]; !seen[top] {
+			seen[top] = true
+			tops = append(tops, filepath.Join(destdir, top))
+		}
+
+		err = install_check_parents(destdir, name)
+		if err != nil {
+			return nil, fmt.Errorf("hwaf: [%s]: %v", fname, err)
+		}
+		dst := filepath.Join(destdir, filepath.FromSlash(name))
+		mode := os.FileMode(hdr.Mode).Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(dst, mode|0700)
+		case tar.TypeReg, tar.TypeRegA:
+			err = os.MkdirAll(filepath.Dir(dst), 0755)
+			if err != nil {
+				break
+			}
+			os.Remove(dst)
+			var f *os.File
+			f, err = os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+			if err != nil {
+				break
+			}
+			_, err = io.Copy(f, tr)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		case tar.TypeSymlink:
+			err = os.MkdirAll(filepath.Dir(dst), 0755)
+			if err != nil {
+				break
+			}
+			os.Remove(dst)
+			err = os.Symlink(hdr.Linkname, dst)
+		case tar.TypeLink:
+			link := path.Clean(hdr.Linkname)
+			if !install_is_local(link) || link == "." {
+				return nil, fmt.Errorf("hwaf: [%s]: refusing to link [%s] to [%s]", fname, hdr.Name, hdr.Linkname)
+			}
+			// the target must not be reached through a symlink either
+			err = install_check_parents(destdir, link)
+			if err != nil {
+				return nil, fmt.Errorf("hwaf: [%s]: %v", fname, err)
+			}
+			err = os.MkdirAll(filepath.Dir(dst), 0755)
+			if err != nil {
+				break
+			}
+			os.Remove(dst)
+			err = os.Link(filepath.Join(destdir, filepath.FromSlash(link)), dst)
+		default:
+			g_ctx.Warnf("skipping special file [%s]\n", hdr.Name)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeSymlink && hdr.Typeflag != tar.TypeDir {
+			err = os.Chtimes(dst, hdr.ModTime, hdr.ModTime)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, top := range tops {
+		err = install_relocate(top)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return tops, nil
+}
+
+// install_relocate relocates the distributions installed under the
+// directory top, reporting the files which could not be relocated.
+func install_relocate(top string) error {
+	manifests, err := filepath.Glob(filepath.Join(top, filepath.FromSlash(bdist_manifest_dir), "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, fname := range manifests {
+		n, problems, err := bdist_relocate(fname, top)
+		if err != nil {
+			return fmt.Errorf("hwaf: could not relocate [%s]: %v", top, err)
+		}
+		for _, p := range problems {
+			g_ctx.Warnf("could not relocate %s\n", p)
+		}
+		if n > 0 || len(problems) > 0 {
+			g_ctx.Infof("relocated %d file(s) to [%s] (%d problem(s))\n", n, top, len(problems))
+		}
+	}
+	return nil
+}
+
+// install_is_local returns whether the cleaned path name stays under the
+// directory it is relative to
+func install_is_local(name string) bool {
+	return !path.IsAbs(name) && name != ".." && !strings.HasPrefix(name, "../")
+}
+
+// install_path returns the path of the file name of a manifest (relative to
+// the directory root holding the distribution), after checking it can not
+// point outside of root: name must be a clean relative path which does not
+// go through a symlink (see install_check_parents).
+func install_path(root, name string) (string, error) {
+	if name == "" || path.Clean(name) != name || name == "." || !install_is_local(name) {
+		return "", fmt.Errorf("invalid file name [%s]", name)
+	}
+	err := install_check_parents(root, name)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, filepath.FromSlash(name)), nil
+}
+
+// install_check_parents makes sure none of the parent directories of name
+// (relative to destdir) is a symlink, so an archive can not write outside
+// of destdir through a symlink it installed.
+func install_check_parents(destdir, name string) error {
+	dir := destdir
+	elems := strings.Split(name, "/")
+	for _, elem := range elems[:len(elems)-1] {
+		dir = filepath.Join(dir, elem)
+		fi, err := os.Lstat(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("refusing to install [%s] through the symlink [%s]", name, dir)
+		}
+	}
+	return nil
+}
+
+// EOF
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBdistInstall(t *testing.T) {
+	test_init_context(t)
+
+	tmpdir, err := ioutil.TempDir("", "hwaf-test-install-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	type entry struct {
+		name     string
+		link     string // symlink target
+		hardlink string // hardlink target
+		data     string
+	}
+	mktar := func(name string, entries []entry) string {
+		fname := filepath.Join(tmpdir, name+".tar")
+		f, err := os.Create(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		tw := tar.NewWriter(f)
+		for _, e := range entries {
+			hdr := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.data)), Typeflag: tar.TypeReg}
+			switch {
+			case e.link != "":
+				hdr = &tar.Header{Name: e.name, Mode: 0777, Linkname: e.link, Typeflag: tar.TypeSymlink}
+			case e.hardlink != "":
+				hdr = &tar.Header{Name: e.name, Mode: 0644, Linkname: e.hardlink, Typeflag: tar.TypeLink}
+			}
+			err = tw.WriteHeader(hdr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = tw.Write([]byte(e.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err = tw.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fname
+	}
+
+	outside := filepath.Join(tmpdir, "outside")
+	err = os.MkdirAll(outside, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(outside, "passwd"), []byte("secret"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, table := range []struct {
+		name    string
+		entries []entry
+		err     string
+	}{
+		{
+			name:    "parent",
+			entries: []entry{{name: "mana-1.0/bin/mana", data: "ok"}, {name: "../pwned", data: "pwned"}},
+			err:     "outside of",
+		},
+		{
+			name:    "parent-clean",
+			entries: []entry{{name: "mana-1.0/../../pwned", data: "pwned"}},
+			err:     "outside of",
+		},
+		{
+			name:    "absolute",
+			entries: []entry{{name: filepath.ToSlash(filepath.Join(outside, "pwned")), data: "pwned"}},
+			err:     "outside of",
+		},
+		{
+			name: "symlink-parent",
+			entries: []entry{
+				{name: "mana-1.0/lib", link: outside},
+				{name: "mana-1.0/lib/pwned", data: "pwned"},
+			},
+			err: "through the symlink",
+		},
+		{
+			name: "relative-symlink-parent",
+			entries: []entry{
+				{name: "mana-1.0/lib", link: "../../outside"},
+				{name: "mana-1.0/lib/pwned", data: "pwned"},
+			},
+			err: "through the symlink",
+		},
+		{
+			name:    "hardlink-parent",
+			entries: []entry{{name: "mana-1.0/passwd", hardlink: ".."}},
+			err:     "refusing to link",
+		},
+		{
+			name:    "hardlink-outside",
+			entries: []entry{{name: "mana-1.0/passwd", hardlink: "../outside/passwd"}},
+			err:     "refusing to link",
+		},
+		{
+			name: "hardlink-symlink-parent",
+			entries: []entry{
+				{name: "mana-1.0/evil", link: outside},
+				{name: "mana-1.0/passwd", hardlink: "mana-1.0/evil/passwd"},
+			},
+			err: "through the symlink",
+		},
+	} {
+		destdir := filepath.Join(tmpdir, "install-"+table.name)
+		fname := mktar(table.name, table.entries)
+		_, err := bdist_install(fname, destdir, true)
+		if err == nil || !strings.Contains(err.Error(), table.err) {
+			t.Errorf("%s: got err=%v, want %q", table.name, err, table.err)
+		}
+		for _, pwned := range []string{
+			filepath.Join(tmpdir, "pwned"),
+			filepath.Join(outside, "pwned"),
+			filepath.Join(destdir, "mana-1.0", "passwd"),
+		} {
+			if path_exists(pwned) {
+				t.Errorf("%s: [%s] was written", table.name, pwned)
+				os.Remove(pwned)
+			}
+		}
+	}
+
+	// a well-formed distribution (without manifest: not relocated)
+	destdir := filepath.Join(tmpdir, "install")
+	fname := mktar("mana", []entry{
+		{name: "mana-1.0/bin/mana", data: "#!/bin/sh\n"},
+		{name: "mana-1.0/lib/libmana.so.1", data: "lib"},
+		{name: "mana-1.0/lib/libmana.so", link: "libmana.so.1"},
+		{name: "./mana-1.0/share/README", data: "readme"},
+	})
+	tops, err := bdist_install(fname, destdir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tops) != 1 || tops[0] != filepath.Join(destdir, "mana-1.0") {
+		t.Fatalf("invalid top directories: %v", tops)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(destdir, "mana-1.0", "lib", "libmana.so"))
+	if err != nil || string(data) != "lib" {
+		t.Fatalf("invalid symlink: %q (err=%v)", data, err)
+	}
+	if !path_exists(filepath.Join(destdir, "mana-1.0", "share", "README")) {
+		t.Fatalf("missing share/README")
+	}
+
+	// installing it again replaces the files
+	_, err = bdist_install(fname, destdir, true)
+	if err != nil {
+		t.Fatalf("could not re-install: %v", err)
+	}
+
+	// unsigned distributions are refused
+	_, err = bdist_install(fname, destdir, false)
+	if err == nil || !strings.Contains(err.Error(), "is not signed") {
+		t.Fatalf("got err=%v, expected an unsigned distribution error", err)
+	}
+}
+
+// EOF
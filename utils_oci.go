@@ -0,0 +1,447 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// media types of the OCI image specification
+const (
+	oci_media_index    = "application/vnd.oci.image.index.v1+json"
+	oci_media_manifest = "application/vnd.oci.image.manifest.v1+json"
+	oci_media_config   = "application/vnd.oci.image.config.v1+json"
+	oci_media_layer    = "application/vnd.oci.image.layer.v1.tar+gzip"
+
+	// docker images converted to OCI layouts may still use these
+	oci_media_docker_list     = "application/vnd.docker.distribution.manifest.list.v2+json"
+	oci_media_docker_manifest = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// oci_docker_layers maps the media types of docker layers to their OCI
+// equivalent
+var oci_docker_layers = map[string]string{
+	"application/vnd.docker.image.rootfs.diff.tar.gzip":         oci_media_layer,
+	"application/vnd.docker.image.rootfs.diff.tar":              "application/vnd.oci.image.layer.v1.tar",
+	"application/vnd.docker.image.rootfs.foreign.diff.tar.gzip": "application/vnd.oci.image.layer.nondistributable.v1.tar+gzip",
+}
+
+// oci_layer_media_type returns the OCI media type of a layer of media type
+// mt, so that an OCI manifest does not refer to docker layers.
+func oci_layer_media_type(mt string) string {
+	if oci, ok := oci_docker_layers[mt]; ok {
+		return oci
+	}
+	return mt
+}
+
+// oci_ref_name is the annotation holding the reference (tag) of an image
+// in the index of an image layout
+const oci_ref_name = "org.opencontainers.image.ref.name"
+
+// oci_descriptor_t describes a blob of an image layout
+type oci_descriptor_t struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *oci_platform_t   `json:"platform,omitempty"`
+}
+
+// oci_platform_t is the platform an image runs on
+type oci_platform_t struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// oci_index_t is the index of an image layout (index.json) or of a
+// multi-platform image
+type oci_index_t struct {
+	SchemaVersion int                `json:"schemaVersion"`
+	MediaType     string             `json:"mediaType,omitempty"`
+	Manifests     []oci_descriptor_t `json:"manifests"`
+}
+
+// oci_manifest_t is the manifest of an image
+type oci_manifest_t struct {
+	SchemaVersion int                `json:"schemaVersion"`
+	MediaType     string             `json:"mediaType,omitempty"`
+	Config        oci_descriptor_t   `json:"config"`
+	Layers        []oci_descriptor_t `json:"layers"`
+	Annotations   map[string]string  `json:"annotations,omitempty"`
+}
+
+// oci_image_t is the configuration of an image
+type oci_image_t struct {
+	Created      string             `json:"created,omitempty"`
+	Author       string             `json:"author,omitempty"`
+	Architecture string             `json:"architecture"`
+	OS           string             `json:"os"`
+	Config       oci_image_config_t `json:"config"`
+	RootFS       oci_rootfs_t       `json:"rootfs"`
+	History      []oci_history_t    `json:"history,omitempty"`
+}
+
+// oci_image_config_t holds the execution parameters of an image
+type oci_image_config_t struct {
+	User         string              `json:"User,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	Env          []string            `json:"Env,omitempty"`
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	Volumes      map[string]struct{} `json:"Volumes,omitempty"`
+	WorkingDir   string              `json:"WorkingDir,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+	StopSignal   string              `json:"StopSignal,omitempty"`
+}
+
+// oci_rootfs_t lists the digests of the uncompressed layers of an image
+type oci_rootfs_t struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+// oci_history_t describes how a layer of an image was created
+type oci_history_t struct {
+	Created    string `json:"created,omitempty"`
+	CreatedBy  string `json:"created_by,omitempty"`
+	Author     string `json:"author,omitempty"`
+	Comment    string `json:"comment,omitempty"`
+	EmptyLayer bool   `json:"empty_layer,omitempty"`
+}
+
+// oci_arch returns the OCI architecture of a HWAF_VARIANT architecture
+func oci_arch(arch string) (string, error) {
+	switch arch {
+	case "x86_64":
+		return "amd64", nil
+	case "i686":
+		return "386", nil
+	case "aarch64":
+		return "arm64", nil
+	case "ppc64le":
+		return "ppc64le", nil
+	}
+	return "", fmt.Errorf("hwaf: unhandled architecture [%s]", arch)
+}
+
+// oci_digest returns the digest of data
+func oci_digest(data []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
+
+// oci_blob_t is a blob of an image layout: either in memory (data) or in
+// the file fname
+type oci_blob_t struct {
+	desc  oci_descriptor_t
+	data  []byte
+	fname string
+}
+
+// oci_new_blob returns the in-memory blob holding the JSON encoding of v
+func oci_new_blob(media string, v interface{}) (oci_blob_t, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return oci_blob_t{}, err
+	}
+	return oci_blob_t{
+		desc: oci_descriptor_t{
+			MediaType: media,
+			Digest:    oci_digest(data),
+			Size:      int64(len(data)),
+		},
+		data: data,
+	}, nil
+}
+
+// oci_base_t is a base image, read from an image layout on disk
+type oci_base_t struct {
+	dir      string
+	manifest oci_manifest_t
+	config   oci_image_t
+}
+
+// oci_blob_fname returns the name of the blob digest of the image layout dir
+func oci_blob_fname(dir, digest string) (string, error) {
+	toks := strings.SplitN(digest, ":", 2)
+	if len(toks) != 2 || toks[0] == "" || toks[1] == "" || strings.ContainsAny(toks[1], "/\\.") {
+		return "", fmt.Errorf("hwaf: invalid digest [%s]", digest)
+	}
+	return filepath.Join(dir, "blobs", toks[0], toks[1]), nil
+}
+
+// oci_read_blob reads and decodes the JSON blob desc of the image layout dir,
+// checking its digest
+func oci_read_blob(dir string, desc oci_descriptor_t, v interface{}) error {
+	fname, err := oci_blob_fname(dir, desc.Digest)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(desc.Digest, "sha256:") && oci_digest(data) != desc.Digest {
+		return fmt.Errorf("hwaf: blob [%s] does not match its digest", fname)
+	}
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		return fmt.Errorf("hwaf: invalid blob [%s]: %v", fname, err)
+	}
+	return nil
+}
+
+// oci_read_base reads the base image of the image layout dir: the image
+// named ref if not empty, the only image of the layout otherwise.
+// Multi-platform images are resolved for the platform arch/os.
+func oci_read_base(dir, ref, arch, os_ string) (*oci_base_t, error) {
+	if !path_exists(filepath.Join(dir, "oci-layout")) {
+		return nil, fmt.Errorf("hwaf: [%s] is not an OCI image layout (no oci-layout file)", dir)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "index.json"))
+	if err != nil {
+		return nil, err
+	}
+	var index oci_index_t
+	err = json.Unmarshal(data, &index)
+	if err != nil {
+		return nil, fmt.Errorf("hwaf: invalid index [%s]: %v", filepath.Join(dir, "index.json"), err)
+	}
+
+	var desc *oci_descriptor_t
+	refs := []string{}
+	for i := range index.Manifests {
+		name := index.Manifests[i].Annotations[oci_ref_name]
+		refs = append(refs, name)
+		if ref == "" || name == ref {
+			if desc != nil {
+				return nil, fmt.Errorf("hwaf: [%s] holds several images (%s): choose one with -base-ref",
+					dir, strings.Join(refs, ", "),
+				)
+			}
+			desc = &index.Manifests[i]
+		}
+	}
+	if desc == nil {
+		return nil, fmt.Errorf("hwaf: no image [%s] in [%s]", ref, dir)
+	}
+
+	// resolve multi-platform images
+	for desc.MediaType == oci_media_index || desc.MediaType == oci_media_docker_list {
+		var sub oci_index_t
+		err = oci_read_blob(dir, *desc, &sub)
+		if err != nil {
+			return nil, err
+		}
+		desc = nil
+		for i := range sub.Manifests {
+			p := sub.Manifests[i].Platform
+			if p != nil && p.Architecture == arch && p.OS == os_ {
+				desc = &sub.Manifests[i]
+				break
+			}
+		}
+		if desc == nil {
+			return nil, fmt.Errorf("hwaf: no %s/%s image in [%s]", os_, arch, dir)
+		}
+	}
+	if desc.MediaType != oci_media_manifest && desc.MediaType != oci_media_docker_manifest {
+		return nil, fmt.Errorf("hwaf: unhandled media type [%s] in [%s]", desc.MediaType, dir)
+	}
+
+	base := &oci_base_t{dir: dir}
+	err = oci_read_blob(dir, *desc, &base.manifest)
+	if err != nil {
+		return nil, err
+	}
+	err = oci_read_blob(dir, base.manifest.Config, &base.config)
+	if err != nil {
+		return nil, err
+	}
+	if base.config.Architecture != arch || base.config.OS != os_ {
+		return nil, fmt.Errorf("hwaf: base image is a %s/%s image (expected %s/%s)",
+			base.config.OS, base.config.Architecture, os_, arch,
+		)
+	}
+	if len(base.config.RootFS.DiffIDs) != len(base.manifest.Layers) {
+		return nil, fmt.Errorf("hwaf: inconsistent base image in [%s] (%d layers, %d diff_ids)",
+			dir, len(base.manifest.Layers), len(base.config.RootFS.DiffIDs),
+		)
+	}
+	for _, layer := range base.manifest.Layers {
+		fname, err := oci_blob_fname(dir, layer.Digest)
+		if err != nil {
+			return nil, err
+		}
+		if !path_exists(fname) {
+			return nil, fmt.Errorf("hwaf: missing layer [%s] in [%s]", layer.Digest, dir)
+		}
+	}
+	return base, nil
+}
+
+// oci_layer creates the gzip compressed layer fname holding the directory
+// root under the directory prefix of the image, followed by the additional
+// files entries. It returns the descriptor of the layer and the digest of
+// its uncompressed content (its diff_id).
+func oci_layer(fname, root, prefix string, entries ...tar_entry_t) (oci_descriptor_t, string, error) {
+	desc := oci_descriptor_t{MediaType: oci_media_layer}
+	err := tar_create(fname, root, prefix, "gz", nil, entries...)
+	if err != nil {
+		return desc, "", err
+	}
+
+	sum, err := sha256_file(fname)
+	if err != nil {
+		return desc, "", err
+	}
+	fi, err := os.Stat(fname)
+	if err != nil {
+		return desc, "", err
+	}
+	desc.Digest = "sha256:" + sum
+	desc.Size = fi.Size()
+
+	r, err := tar_open(fname)
+	if err != nil {
+		return desc, "", err
+	}
+	defer r.Close()
+	diff := sha256.New()
+	_, err = io.Copy(diff, r)
+	if err != nil {
+		return desc, "", err
+	}
+	return desc, fmt.Sprintf("sha256:%x", diff.Sum(nil)), nil
+}
+
+// oci_write_layout writes the image layout tarball fname holding the blobs,
+// with index.json pointing at the image manifest desc.
+// Entries are sorted and their modification time set to mtime.
+func oci_write_layout(fname string, desc oci_descriptor_t, blobs []oci_blob_t, mtime time.Time) error {
+	layout := []byte(`{"imageLayoutVersion":"1.0.0"}`)
+	index, err := json.Marshal(oci_index_t{
+		SchemaVersion: 2,
+		MediaType:     oci_media_index,
+		Manifests:     []oci_descriptor_t{desc},
+	})
+	if err != nil {
+		return err
+	}
+
+	tmp := fname + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+	dir := func(name string) error {
+		return tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0755,
+			Typeflag: tar.TypeDir,
+			ModTime:  mtime,
+			Uname:    "root",
+			Gname:    "root",
+		})
+	}
+	file := func(name string, size int64, r io.Reader) error {
+		err := tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     size,
+			Typeflag: tar.TypeReg,
+			ModTime:  mtime,
+			Uname:    "root",
+			Gname:    "root",
+		})
+		if err != nil {
+			return err
+		}
+		n, err := io.Copy(tw, r)
+		if err == nil && n != size {
+			err = fmt.Errorf("hwaf: blob [%s] changed while being archived", name)
+		}
+		return err
+	}
+
+	// blobs, by name (a blob may be shared)
+	names := []string{}
+	byname := make(map[string]oci_blob_t, len(blobs))
+	for _, blob := range blobs {
+		name, err := oci_blob_fname("", blob.desc.Digest)
+		if err != nil {
+			return err
+		}
+		name = filepath.ToSlash(name)
+		if _, dup := byname[name]; !dup {
+			names = append(names, name)
+		}
+		byname[name] = blob
+	}
+	sort.Strings(names)
+
+	err = dir("blobs/")
+	if err != nil {
+		return err
+	}
+	dirs := make(map[string]bool)
+	for _, name := range names {
+		if d := path.Dir(name) + "/"; !dirs[d] {
+			dirs[d] = true
+			err = dir(d)
+			if err != nil {
+				return err
+			}
+		}
+		blob := byname[name]
+		if blob.fname == "" {
+			err = file(name, int64(len(blob.data)), bytes.NewReader(blob.data))
+		} else {
+			var r *os.File
+			r, err = os.Open(blob.fname)
+			if err != nil {
+				return err
+			}
+			err = file(name, blob.desc.Size, r)
+			r.Close()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	err = file("index.json", int64(len(index)), bytes.NewReader(index))
+	if err != nil {
+		return err
+	}
+	err = file("oci-layout", int64(len(layout)), bytes.NewReader(layout))
+	if err != nil {
+		return err
+	}
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, fname)
+}
+
+// EOF
@@ -0,0 +1,567 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pmgr_index_name is the name of the index of a binary repository
+const pmgr_index_name = "index.json"
+
+// pmgr_index_t is the index of a binary repository: a directory (served
+// as is over HTTP or not) holding binary distributions created by 'hwaf
+// bdist' (see 'hwaf pmgr index').
+type pmgr_index_t struct {
+	HwafVersion string         // version of hwaf which created the index
+	Packages    []pmgr_entry_t // binary distributions of the repository
+}
+
+// pmgr_entry_t describes a binary distribution of a repository
+type pmgr_entry_t struct {
+	Name     string            // name of the binary distribution
+	Version  string            // version of the binary distribution
+	Variant  string            // HWAF_VARIANT quadruplet
+	File     string            // path of the tarball, relative to the repository
+	Size     int64             // size of the tarball
+	Sha256   string            // hex-encoded SHA-256 of the tarball
+	Signed   bool              // whether the tarball has a detached signature
+	Projects []bdist_project_t // upstream projects
+	Repo     string            `json:"-"` // repository holding the distribution
+}
+
+// String returns the name-version (variant) of the distribution
+func (e pmgr_entry_t) String() string {
+	return e.Name + "-" + e.Version + " (" + e.Variant + ")"
+}
+
+// pmgr_entries sorts binary distributions by name, variant and version
+type pmgr_entries []pmgr_entry_t
+
+func (p pmgr_entries) Len() int      { return len(p) }
+func (p pmgr_entries) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p pmgr_entries) Less(i, j int) bool {
+	if p[i].Name != p[j].Name {
+		return p[i].Name < p[j].Name
+	}
+	if p[i].Variant != p[j].Variant {
+		return p[i].Variant < p[j].Variant
+	}
+	return pmgr_version_less(p[i].Version, p[j].Version)
+}
+
+// pmgr_version_less compares two versions, numerical components being
+// compared as numbers (1.10 is newer than 1.9)
+func pmgr_version_less(v1, v2 string) bool {
+	split := func(v string) []string {
+		return strings.FieldsFunc(v, func(r rune) bool {
+			return r == '.' || r == '-' || r == '_'
+		})
+	}
+	t1 := split(v1)
+	t2 := split(v2)
+	for i := 0; i < len(t1) && i < len(t2); i++ {
+		if t1[i] == t2[i] {
+			continue
+		}
+		n1, err1 := strconv.ParseUint(t1[i], 10, 64)
+		n2, err2 := strconv.ParseUint(t2[i], 10, 64)
+		if err1 == nil && err2 == nil {
+			return n1 < n2
+		}
+		return t1[i] < t2[i]
+	}
+	return len(t1) < len(t2)
+}
+
+// pmgr_is_bdist returns whether fname is the name of a binary distribution
+// tarball
+func pmgr_is_bdist(fname string) bool {
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar.bz2", ".tar.xz"} {
+		if strings.HasSuffix(fname, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// pmgr_index_dir creates the index of the binary distributions found
+// under the directory dir, from the manifests they embed
+func pmgr_index_dir(dir string) (*pmgr_index_t, error) {
+	idx := &pmgr_index_t{
+		HwafVersion: g_ctx.Version(),
+		Packages:    []pmgr_entry_t{},
+	}
+	err := filepath.Walk(dir, func(fname string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() || !pmgr_is_bdist(fname) {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, fname)
+		if err != nil {
+			return err
+		}
+		ar, err := bdist_read_archive(fname)
+		if err != nil {
+			return fmt.Errorf("hwaf: could not read [%s]: %v", fname, err)
+		}
+		if len(ar.manifests) == 0 {
+			g_ctx.Warnf("no manifest in [%s] (not a binary distribution ?)\n", fname)
+			return nil
+		}
+		if len(ar.manifests) > 1 {
+			g_ctx.Warnf("more than one manifest in [%s]: skipping it\n", fname)
+			return nil
+		}
+		sum, err := sha256_file(fname)
+		if err != nil {
+			return err
+		}
+		for name, data := range ar.manifests {
+			m, err := bdist_load_manifest(name, data)
+			if err != nil {
+				return err
+			}
+			idx.Packages = append(idx.Packages, pmgr_entry_t{
+				Name:     m.Name,
+				Version:  m.Version,
+				Variant:  m.Variant,
+				File:     filepath.ToSlash(rel),
+				Size:     fi.Size(),
+				Sha256:   sum,
+				Signed:   path_exists(sig_fname(fname)),
+				Projects: m.Projects,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(pmgr_entries(idx.Packages))
+	return idx, nil
+}
+
+// pmgr_is_url returns whether the repository repo is served over HTTP
+func pmgr_is_url(repo string) bool {
+	return strings.HasPrefix(repo, "http://") || strings.HasPrefix(repo, "https://")
+}
+
+// pmgr_repos returns the binary repositories: the one given on the
+// command line, or the space separated ones of the [hwaf-pmgr] repos
+// option of the configuration.
+func pmgr_repos(repo string) ([]string, error) {
+	if repo == "" {
+		repo = cfg_string("hwaf-pmgr", "repos", "")
+	}
+	repos := []string{}
+	for _, r := range strings.Fields(repo) {
+		if !pmgr_is_url(r) {
+			r = os.ExpandEnv(r)
+		}
+		repos = append(repos, strings.TrimSuffix(r, "/"))
+	}
+	if len(repos) == 0 {
+		return nil, fmt.Errorf("hwaf: no binary repository (use -repo or the [hwaf-pmgr] repos option)")
+	}
+	return repos, nil
+}
+
+// pmgr_load_index reads the index of the repository repo
+func pmgr_load_index(repo string) (*pmgr_index_t, error) {
+	var data []byte
+	var err error
+	if pmgr_is_url(repo) {
+		url := repo + "/" + pmgr_index_name
+		resp, err := http.Get(url)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("hwaf: could not download [%s] (reason: %q)", url, resp.Status)
+		}
+		data, err = ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		data, err = ioutil.ReadFile(filepath.Join(repo, pmgr_index_name))
+		if err != nil {
+			return nil, fmt.Errorf("hwaf: no index in repository [%s] (see 'hwaf pmgr index'): %v", repo, err)
+		}
+	}
+
+	idx := &pmgr_index_t{}
+	err = json.Unmarshal(data, idx)
+	if err != nil {
+		return nil, fmt.Errorf("hwaf: invalid index in repository [%s]: %v", repo, err)
+	}
+	for i := range idx.Packages {
+		idx.Packages[i].Repo = repo
+	}
+	return idx, nil
+}
+
+// pmgr_available returns the binary distributions of all the repositories
+func pmgr_available(repos []string) ([]pmgr_entry_t, error) {
+	entries := []pmgr_entry_t{}
+	for _, repo := range repos {
+		idx, err := pmgr_load_index(repo)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, idx.Packages...)
+	}
+	sort.Sort(pmgr_entries(entries))
+	return entries, nil
+}
+
+// pmgr_match returns whether the binary distribution (or installed project)
+// name-version matches the query q: either a name, a name-version or a glob
+// pattern.
+func pmgr_match(q, name, vers string) bool {
+	if q == name || q == name+"-"+vers {
+		return true
+	}
+	if ok, _ := path.Match(q, name); ok {
+		return true
+	}
+	ok, _ := path.Match(q, name+"-"+vers)
+	return ok
+}
+
+// pmgr_find returns the newest binary distribution of entries named name
+// (or name-version) for the variant variant, if any
+func pmgr_find(entries []pmgr_entry_t, name, variant string) (pmgr_entry_t, bool) {
+	found := false
+	var out pmgr_entry_t
+	for _, e := range entries {
+		if e.Variant != variant || (e.Name != name && e.Name+"-"+e.Version != name) {
+			continue
+		}
+		if !found || pmgr_version_less(out.Version, e.Version) {
+			out = e
+			found = true
+		}
+	}
+	return out, found
+}
+
+// pmgr_fetch returns the local file of the binary distribution e,
+// downloading it (and its signature) into tmpdir if the repository is
+// served over HTTP, after checking its checksum.
+func pmgr_fetch(e pmgr_entry_t, tmpdir string) (string, error) {
+	var fname string
+	var err error
+	if pmgr_is_url(e.Repo) {
+		fname, err = pmgr_download(e.Repo+"/"+e.File, tmpdir)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		fname = filepath.Join(e.Repo, filepath.FromSlash(e.File))
+	}
+	sum, err := sha256_file(fname)
+	if err != nil {
+		return "", err
+	}
+	if sum != e.Sha256 {
+		return "", fmt.Errorf("hwaf: [%s]: sha256 %s, expected %s (outdated index ?)", fname, sum, e.Sha256)
+	}
+	return fname, nil
+}
+
+// pmgr_project_t is a project installed by 'hwaf pmgr install'
+type pmgr_project_t struct {
+	Name     string            // name of the binary distribution
+	Version  string            // version of the binary distribution
+	Variant  string            // HWAF_VARIANT quadruplet
+	Dir      string            // directory where it is installed
+	Manifest string            // manifest of the installed files
+	Repo     string            // repository it was installed from
+	File     string            // path of the tarball in the repository
+	Sha256   string            // hex-encoded SHA-256 of the tarball
+	Projects []bdist_project_t // upstream projects
+	Date     string            // installation date
+}
+
+// String returns the name-version (variant) of the installed project
+func (p pmgr_project_t) String() string {
+	return p.Name + "-" + p.Version + " (" + p.Variant + ")"
+}
+
+// pmgr_db_t is the database of the projects installed under the sitedir
+type pmgr_db_t struct {
+	fname    string
+	Projects []pmgr_project_t
+}
+
+// pmgr_db_fname returns the name of the database of installed projects
+func pmgr_db_fname() string {
+	return filepath.Join(g_ctx.Sitedir(), ".hwaf", "pmgr.json")
+}
+
+// pmgr_load_db reads the database of installed projects (an empty one if
+// nothing was installed yet)
+func pmgr_load_db() (*pmgr_db_t, error) {
+	db := &pmgr_db_t{
+		fname:    pmgr_db_fname(),
+		Projects: []pmgr_project_t{},
+	}
+	data, err := ioutil.ReadFile(db.fname)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return db, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal(data, db)
+	if err != nil {
+		return nil, fmt.Errorf("hwaf: invalid database [%s]: %v", db.fname, err)
+	}
+	return db, nil
+}
+
+// save writes the database of installed projects
+func (db *pmgr_db_t) save() error {
+	sort.Sort(pmgr_projects(db.Projects))
+	data, err := json.MarshalIndent(db, "", "    ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(db.fname), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(db.fname, data, 0644)
+}
+
+// get returns the index of the installed project name-vers for variant
+func (db *pmgr_db_t) get(name, vers, variant string) int {
+	for i, p := range db.Projects {
+		if p.Name == name && p.Version == vers && p.Variant == variant {
+			return i
+		}
+	}
+	return -1
+}
+
+// find returns the installed projects matching the query q (see pmgr_match)
+// for the variant variant (any variant if empty)
+func (db *pmgr_db_t) find(q, variant string) []pmgr_project_t {
+	out := []pmgr_project_t{}
+	for _, p := range db.Projects {
+		if (variant == "" || p.Variant == variant) && pmgr_match(q, p.Name, p.Version) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// dependents returns the installed projects depending on the project p
+func (db *pmgr_db_t) dependents(p pmgr_project_t) []pmgr_project_t {
+	out := []pmgr_project_t{}
+	for _, o := range db.Projects {
+		if o.Variant != p.Variant {
+			continue
+		}
+		for _, proj := range o.Projects {
+			if proj.Name == p.Name && proj.Version == p.Version {
+				out = append(out, o)
+				break
+			}
+		}
+	}
+	return out
+}
+
+type pmgr_projects []pmgr_project_t
+
+func (p pmgr_projects) Len() int      { return len(p) }
+func (p pmgr_projects) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p pmgr_projects) Less(i, j int) bool {
+	if p[i].Name != p[j].Name {
+		return p[i].Name < p[j].Name
+	}
+	if p[i].Variant != p[j].Variant {
+		return p[i].Variant < p[j].Variant
+	}
+	return pmgr_version_less(p[i].Version, p[j].Version)
+}
+
+// pmgr_install installs the binary distribution e under destdir/<variant>
+// and records it in the database db. Its upstream projects are installed
+// first (from entries), unless nodeps is set.
+// pending holds the distributions being installed, to break cycles in the
+// declarations of the upstream projects.
+func pmgr_install(db *pmgr_db_t, entries []pmgr_entry_t, e pmgr_entry_t, destdir string, insecure, nodeps, verbose bool, pending map[string]bool) error {
+	if db.get(e.Name, e.Version, e.Variant) >= 0 {
+		if verbose {
+			fmt.Printf("hwaf-pmgr: [%s] already installed\n", e)
+		}
+		return nil
+	}
+	if pending[e.String()] {
+		g_ctx.Warnf("cyclic upstream projects: [%s] is already being installed\n", e)
+		return nil
+	}
+	pending[e.String()] = true
+	defer delete(pending, e.String())
+
+	if !nodeps {
+		for _, proj := range e.Projects {
+			if db.get(proj.Name, proj.Version, e.Variant) >= 0 {
+				continue
+			}
+			dep, ok := pmgr_find(entries, proj.Name+"-"+proj.Version, e.Variant)
+			if !ok {
+				g_ctx.Warnf("upstream project [%s-%s] of [%s] is not in any repository\n", proj.Name, proj.Version, e)
+				continue
+			}
+			err := pmgr_install(db, entries, dep, destdir, insecure, nodeps, verbose, pending)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if verbose {
+		fmt.Printf("hwaf-pmgr: installing [%s] from [%s]...\n", e, e.Repo)
+	}
+	tmpdir, err := ioutil.TempDir("", "hwaf-pmgr-install-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpdir)
+
+	fname, err := pmgr_fetch(e, tmpdir)
+	if err != nil {
+		return err
+	}
+	// the tarballs of all the variants unpack into <name>-<version>
+	tops, err := bdist_install(fname, filepath.Join(destdir, e.Variant), insecure)
+	if err != nil {
+		return err
+	}
+
+	manifest := ""
+	for _, top := range tops {
+		mname := filepath.Join(top, filepath.FromSlash(bdist_manifest_name(e.Name, e.Version)))
+		if path_exists(mname) {
+			manifest = mname
+			break
+		}
+	}
+	if manifest == "" {
+		return fmt.Errorf("hwaf: no manifest for [%s] under [%s]", e, destdir)
+	}
+
+	db.Projects = append(db.Projects, pmgr_project_t{
+		Name:     e.Name,
+		Version:  e.Version,
+		Variant:  e.Variant,
+		Dir:      bdist_manifest_top(manifest),
+		Manifest: manifest,
+		Repo:     e.Repo,
+		File:     e.File,
+		Sha256:   e.Sha256,
+		Projects: e.Projects,
+		Date:     time.Now().UTC().Format(time.RFC3339),
+	})
+	err = db.save()
+	if err != nil {
+		return err
+	}
+	if verbose {
+		fmt.Printf("hwaf-pmgr: installing [%s] from [%s]... [ok]\n", e, e.Repo)
+	}
+	return nil
+}
+
+// pmgr_remove removes the files of the installed project p (the ones
+// listed in its manifest), then the directories it leaves empty, and
+// removes it from the database db.
+func pmgr_remove(db *pmgr_db_t, p pmgr_project_t) error {
+	data, err := ioutil.ReadFile(p.Manifest)
+	if err != nil {
+		return err
+	}
+	m, err := bdist_load_manifest(p.Manifest, data)
+	if err != nil {
+		return err
+	}
+
+	// never remove files outside of the installation directory
+	fnames := make([]string, len(m.Files))
+	for i, file := range m.Files {
+		fnames[i], err = install_path(p.Dir, file.Name)
+		if err != nil {
+			return fmt.Errorf("hwaf: [%s]: %v", p.Manifest, err)
+		}
+	}
+
+	dirs := make(map[string]bool)
+	for _, fname := range fnames {
+		err = os.Remove(fname)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		for dir := filepath.Dir(fname); strings.HasPrefix(dir, p.Dir); dir = filepath.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+	err = os.Remove(p.Manifest)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for dir := filepath.Dir(p.Manifest); strings.HasPrefix(dir, p.Dir); dir = filepath.Dir(dir) {
+		dirs[dir] = true
+	}
+
+	// remove the empty directories, deepest first
+	sorted := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		sorted = append(sorted, dir)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(sorted)))
+	for _, dir := range sorted {
+		if f, err := ioutil.ReadDir(dir); err == nil && len(f) == 0 {
+			os.Remove(dir)
+		}
+	}
+
+	i := db.get(p.Name, p.Version, p.Variant)
+	if i >= 0 {
+		db.Projects = append(db.Projects[:i], db.Projects[i+1:]...)
+	}
+	return db.save()
+}
+
+// pmgr_verify checks the files of the installed project p against its
+// manifest, and returns the list of problems found
+func pmgr_verify(p pmgr_project_t) ([]string, error) {
+	data, err := ioutil.ReadFile(p.Manifest)
+	if err != nil {
+		return nil, err
+	}
+	m, err := bdist_load_manifest(p.Manifest, data)
+	if err != nil {
+		return nil, err
+	}
+	return bdist_verify(m, func(name string) (bdist_file_t, error) {
+		return bdist_stat_file(name, filepath.Join(p.Dir, filepath.FromSlash(name)))
+	}), nil
+}
+
+// EOF
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestPmgrVersionLess(t *testing.T) {
+	for _, table := range []struct {
+		v1   string
+		v2   string
+		less bool
+	}{
+		{"1.9", "1.10", true},
+		{"1.10", "1.9", false},
+		{"1.9", "1.9", false},
+		{"1.9", "1.9.1", true},
+		{"1.9.1", "1.9", false},
+		{"20130101", "20130215", true},
+		{"1.0-rc1", "1.0-rc2", true},
+		{"1_2", "1.10", true},
+		{"1.a", "1.b", true},
+		{"1.b", "1.10", false},
+	} {
+		less := pmgr_version_less(table.v1, table.v2)
+		if less != table.less {
+			t.Errorf("pmgr_version_less(%q, %q): got=%v want=%v",
+				table.v1, table.v2, less, table.less,
+			)
+		}
+	}
+}
+
+func TestPmgrFind(t *testing.T) {
+	const (
+		opt = "x86_64-linux-gcc-opt"
+		dbg = "x86_64-linux-gcc-dbg"
+	)
+	entries := []pmgr_entry_t{
+		{Name: "base", Version: "1.9", Variant: opt},
+		{Name: "base", Version: "1.10", Variant: opt},
+		{Name: "base", Version: "1.11", Variant: dbg},
+		{Name: "core", Version: "2.0", Variant: opt},
+		{Name: "core-devel", Version: "2.0", Variant: opt},
+	}
+
+	for _, table := range []struct {
+		name    string
+		variant string
+		found   bool
+		want    string
+	}{
+		{"base", opt, true, "base-1.10"},
+		{"base-1.9", opt, true, "base-1.9"},
+		{"base", dbg, true, "base-1.11"},
+		{"base-1.10", dbg, false, ""},
+		{"core", opt, true, "core-2.0"},
+		{"core-devel", opt, true, "core-devel-2.0"},
+		{"core-2.0", opt, true, "core-2.0"},
+		{"bas", opt, false, ""},
+		{"base", "i686-linux-gcc-opt", false, ""},
+	} {
+		e, found := pmgr_find(entries, table.name, table.variant)
+		if found != table.found {
+			t.Errorf("pmgr_find(%q, %q): found=%v want=%v", table.name, table.variant, found, table.found)
+			continue
+		}
+		if !found {
+			continue
+		}
+		if got := e.Name + "-" + e.Version; got != table.want || e.Variant != table.variant {
+			t.Errorf("pmgr_find(%q, %q): got=%s (%s) want=%s (%s)",
+				table.name, table.variant, got, e.Variant, table.want, table.variant,
+			)
+		}
+	}
+}
+
+// EOF
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// relocate_t rewrites the build-time locations of a binary distribution
+// (its installation prefix and staging directory) into its new location,
+// once installed.
+type relocate_t struct {
+	olds     []string // build-time locations, longest first
+	root     string   // new location
+	problems []string // files which could not be relocated
+}
+
+// bdist_relocate relocates the files of the installed distribution
+// described by the manifest fname, from its build prefix to the directory
+// root holding it: ELF RPATH/RUNPATH entries (in place, when the new value
+// is not longer than the old one), text files (setup scripts, project.info,
+// scripts and their shebang lines, ...) and absolute symlinks.
+// The manifest is updated with the new content of the files and the new
+// prefix. bdist_relocate returns the number of relocated files and the
+// list of the files it could not relocate.
+func bdist_relocate(fname, root string) (int, []string, error) {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return 0, nil, err
+	}
+	m, err := bdist_load_manifest(fname, data)
+	if err != nil {
+		return 0, nil, err
+	}
+	if m.Prefix == "" {
+		g_ctx.Debugf("hwaf: no build prefix in [%s]: not relocating\n", fname)
+		return 0, nil, nil
+	}
+
+	r := relocate_t{root: root}
+	for _, old := range []string{m.Staging, m.Prefix} {
+		if old != "" && old != root && old != "/" {
+			r.olds = append(r.olds, old)
+		}
+	}
+	if len(r.olds) == 0 {
+		return 0, nil, nil
+	}
+	sort.Sort(sort.Reverse(relocate_by_len(r.olds)))
+
+	// never rewrite files outside of root
+	fnames := make([]string, len(m.Files))
+	for i, file := range m.Files {
+		fnames[i], err = install_path(root, file.Name)
+		if err != nil {
+			return 0, nil, fmt.Errorf("hwaf: [%s]: %v", fname, err)
+		}
+	}
+
+	nfiles := 0
+	for i, file := range m.Files {
+		changed, err := r.relocate(fnames[i])
+		if err != nil {
+			r.problems = append(r.problems, fmt.Sprintf("%s: %v", file.Name, err))
+			continue
+		}
+		if !changed {
+			continue
+		}
+		nfiles++
+		m.Files[i], err = bdist_stat_file(file.Name, fnames[i])
+		if err != nil {
+			return nfiles, r.problems, err
+		}
+	}
+
+	m.Prefix = root
+	m.Staging = ""
+	data, err = m.data()
+	if err != nil {
+		return nfiles, r.problems, err
+	}
+	return nfiles, r.problems, ioutil.WriteFile(fname, data, 0644)
+}
+
+// relocate_by_len sorts paths by length
+type relocate_by_len []string
+
+func (p relocate_by_len) Len() int           { return len(p) }
+func (p relocate_by_len) Less(i, j int) bool { return len(p[i]) < len(p[j]) }
+func (p relocate_by_len) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// relocate relocates the file fname and returns whether it was modified
+func (r *relocate_t) relocate(fname string) (bool, error) {
+	fi, err := os.Lstat(fname)
+	if err != nil {
+		return false, err
+	}
+
+	if fi.Mode()&os.ModeSymlink != 0 {
+		link, err := os.Readlink(fname)
+		if err != nil {
+			return false, err
+		}
+		if !filepath.IsAbs(link) {
+			return false, nil
+		}
+		dst, n := r.replace([]byte(link))
+		if n == 0 {
+			return false, nil
+		}
+		err = os.Remove(fname)
+		if err != nil {
+			return false, err
+		}
+		return true, os.Symlink(string(dst), fname)
+	}
+
+	if !fi.Mode().IsRegular() {
+		return false, nil
+	}
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return false, err
+	}
+
+	switch {
+	case bytes.HasPrefix(data, []byte(elf.ELFMAG)):
+		return r.relocate_elf(fname)
+
+	case bytes.IndexByte(data, 0) >= 0:
+		// other binary files can not be safely rewritten
+		for _, old := range r.olds {
+			if bytes.Contains(data, []byte(old)) {
+				return false, fmt.Errorf("binary file refers to the build prefix [%s]", old)
+			}
+		}
+		return false, nil
+	}
+
+	// text files, including scripts and their shebang line
+	out, n := r.replace(data)
+	if n == 0 {
+		return false, nil
+	}
+	err = ioutil.WriteFile(fname, out, fi.Mode().Perm())
+	if err != nil {
+		return false, err
+	}
+	return true, os.Chtimes(fname, fi.ModTime(), fi.ModTime())
+}
+
+// replace replaces the build-time locations in data by the new location,
+// in a single pass (the new location is never replaced again, even if it
+// is under a build-time location). Only whole paths match: /opt/foo does
+// not match /opt/foobar nor /x/opt/foo. At each position, the longest
+// build-time location is tried first.
+// It returns the new data and the number of replacements.
+func (r *relocate_t) replace(data []byte) ([]byte, int) {
+	n := 0
+	out := make([]byte, 0, len(data))
+	for i := 0; i < len(data); {
+		matched := false
+		if i == 0 || !relocate_path_char(data[i-1]) && data[i-1] != '/' {
+			for _, old := range r.olds {
+				end := i + len(old)
+				if !bytes.HasPrefix(data[i:], []byte(old)) ||
+					(end < len(data) && relocate_path_char(data[end])) {
+					continue
+				}
+				out = append(out, r.root...)
+				i = end
+				n++
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			out = append(out, data[i])
+			i++
+		}
+	}
+	return out, n
+}
+
+// relocate_path_char returns whether c may be part of a path component
+func relocate_path_char(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return strings.IndexByte("._+-~", c) >= 0
+}
+
+// relocate_elf rewrites the RPATH and RUNPATH entries of the ELF file fname
+// referring to the build-time locations. The dynamic string table is
+// patched in place: a new value longer than the old one is an error.
+func (r *relocate_t) relocate_elf(fname string) (bool, error) {
+	f, err := os.OpenFile(fname, os.O_RDWR, 0)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	ef, err := elf.NewFile(f)
+	if err != nil {
+		return false, err
+	}
+	dyn := ef.SectionByType(elf.SHT_DYNAMIC)
+	if dyn == nil || int(dyn.Link) >= len(ef.Sections) {
+		// statically linked
+		return false, nil
+	}
+	strtab := ef.Sections[dyn.Link]
+	strs, err := strtab.Data()
+	if err != nil {
+		return false, err
+	}
+	data, err := dyn.Data()
+	if err != nil {
+		return false, err
+	}
+
+	// the offsets of the RPATH and RUNPATH strings in the string table
+	offsets := []uint64{}
+	size := 16
+	if ef.Class == elf.ELFCLASS32 {
+		size = 8
+	}
+	for ; len(data) >= size; data = data[size:] {
+		var tag elf.DynTag
+		var val uint64
+		if size == 8 {
+			tag = elf.DynTag(int32(ef.ByteOrder.Uint32(data[0:4])))
+			val = uint64(ef.ByteOrder.Uint32(data[4:8]))
+		} else {
+			tag = elf.DynTag(int64(ef.ByteOrder.Uint64(data[0:8])))
+			val = ef.ByteOrder.Uint64(data[8:16])
+		}
+		if tag == elf.DT_NULL {
+			break
+		}
+		if tag == elf.DT_RPATH || tag == elf.DT_RUNPATH {
+			offsets = append(offsets, val)
+		}
+	}
+
+	changed := false
+	for _, off := range offsets {
+		if off >= uint64(len(strs)) {
+			return changed, fmt.Errorf("invalid RPATH offset (%d)", off)
+		}
+		old := strs[off:]
+		if i := bytes.IndexByte(old, 0); i >= 0 {
+			old = old[:i]
+		}
+
+		// relocate each directory of the search path
+		dirs := strings.Split(string(old), ":")
+		for i, dir := range dirs {
+			for _, prefix := range r.olds {
+				if dir == prefix || strings.HasPrefix(dir, prefix+"/") {
+					dirs[i] = r.root + dir[len(prefix):]
+					break
+				}
+			}
+		}
+		rpath := strings.Join(dirs, ":")
+		if rpath == string(old) {
+			continue
+		}
+		if len(rpath) > len(old) {
+			return changed, fmt.Errorf("no room to relocate RPATH %q (%d bytes) to %q (%d bytes)",
+				old, len(old), rpath, len(rpath),
+			)
+		}
+		buf := make([]byte, len(old))
+		copy(buf, rpath)
+		_, err = f.WriteAt(buf, int64(strtab.Offset+off))
+		if err != nil {
+			return changed, err
+		}
+		changed = true
+	}
+	if !changed {
+		return false, nil
+	}
+	err = f.Close()
+	if err != nil {
+		return true, err
+	}
+	return true, os.Chtimes(fname, fi.ModTime(), fi.ModTime())
+}
+
+// EOF
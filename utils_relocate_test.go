@@ -0,0 +1,185 @@
+package main
+
+import (
+	"debug/elf"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRelocateReplace(t *testing.T) {
+	for _, table := range []struct {
+		olds []string
+		root string
+		data string
+		want string
+		n    int
+	}{
+		{
+			olds: []string{"/opt/sw"},
+			root: "/site/mana-1",
+			data: "PATH=/opt/sw/bin:/usr/bin",
+			want: "PATH=/site/mana-1/bin:/usr/bin",
+			n:    1,
+		},
+		{
+			// whole path only
+			olds: []string{"/opt/sw"},
+			root: "/site",
+			data: "/opt/swx /opt/sw.d /x/opt/sw/lib opt/sw /opt/sw",
+			want: "/opt/swx /opt/sw.d /x/opt/sw/lib opt/sw /site",
+			n:    1,
+		},
+		{
+			// the new root is under the old prefix: no double relocation
+			olds: []string{"/opt/sw"},
+			root: "/opt/sw/mana-1",
+			data: "#!/opt/sw/bin/python\n/opt/sw/lib:/opt/sw",
+			want: "#!/opt/sw/mana-1/bin/python\n/opt/sw/mana-1/lib:/opt/sw/mana-1",
+			n:    3,
+		},
+		{
+			// staging directory and prefix, longest first
+			olds: []string{"/tmp/stage/opt/sw", "/opt/sw"},
+			root: "/opt/sw/mana-1",
+			data: "'/tmp/stage/opt/sw/lib' \"/opt/sw/include\"",
+			want: "'/opt/sw/mana-1/lib' \"/opt/sw/mana-1/include\"",
+			n:    2,
+		},
+		{
+			olds: []string{"/opt/sw"},
+			root: "/site",
+			data: "nothing to do",
+			want: "nothing to do",
+			n:    0,
+		},
+	} {
+		r := relocate_t{olds: table.olds, root: table.root}
+		out, n := r.replace([]byte(table.data))
+		if string(out) != table.want || n != table.n {
+			t.Errorf("replace(%q, %v -> %q):\ngot=  %q (%d)\nwant= %q (%d)",
+				table.data, table.olds, table.root, out, n, table.want, table.n,
+			)
+		}
+	}
+}
+
+func TestRelocateManifestNames(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "hwaf-test-relocate-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	root := filepath.Join(tmpdir, "mana-1.0")
+	outside := filepath.Join(tmpdir, "outside")
+	for _, dir := range []string{root, outside} {
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	const content = "prefix=/opt/sw\n"
+	err = ioutil.WriteFile(filepath.Join(outside, "setup.sh"), []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Symlink(outside, filepath.Join(root, "evil"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{
+		"../outside/setup.sh",
+		"evil/setup.sh",
+		"/" + filepath.ToSlash(filepath.Join(outside, "setup.sh")),
+		"./evil/../../outside/setup.sh",
+		"..",
+	} {
+		m := &bdist_manifest_t{
+			Name:    "mana",
+			Version: "1.0",
+			Prefix:  "/opt/sw",
+			Files:   []bdist_file_t{{Name: name}},
+		}
+		data, err := m.data()
+		if err != nil {
+			t.Fatal(err)
+		}
+		fname := filepath.Join(root, "manifest.json")
+		err = ioutil.WriteFile(fname, data, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = bdist_relocate(fname, root)
+		if err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+		got, err := ioutil.ReadFile(filepath.Join(outside, "setup.sh"))
+		if err != nil || string(got) != content {
+			t.Fatalf("%s: file outside of the distribution modified: %q (err=%v)", name, got, err)
+		}
+	}
+}
+
+func TestRelocateElf(t *testing.T) {
+	gcc, err := exec.LookPath("gcc")
+	if err != nil {
+		t.Skip("no gcc")
+	}
+	tmpdir, err := ioutil.TempDir("", "hwaf-test-relocate-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	src := filepath.Join(tmpdir, "main.c")
+	err = ioutil.WriteFile(src, []byte("int main() { return 0; }\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exe := filepath.Join(tmpdir, "main")
+	out, err := exec.Command(gcc, "-o", exe, src, "-Wl,-rpath,/opt/sw/lib:/usr/lib").CombinedOutput()
+	if err != nil {
+		t.Skipf("could not compile: %v\n%s", err, out)
+	}
+
+	rpath := func() string {
+		f, err := elf.Open(exe)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		for _, tag := range []elf.DynTag{elf.DT_RUNPATH, elf.DT_RPATH} {
+			if v, err := f.DynString(tag); err == nil && len(v) > 0 {
+				return v[0]
+			}
+		}
+		return ""
+	}
+
+	// no room for a longer path
+	r := relocate_t{olds: []string{"/opt/sw"}, root: "/a/much/longer/installation/directory"}
+	changed, err := r.relocate_elf(exe)
+	if err == nil || changed || !strings.Contains(err.Error(), "no room") {
+		t.Fatalf("expected a 'no room' error. got changed=%v err=%v", changed, err)
+	}
+	if got := rpath(); got != "/opt/sw/lib:/usr/lib" {
+		t.Fatalf("RPATH modified: %q", got)
+	}
+
+	// in place
+	r = relocate_t{olds: []string{"/opt/sw"}, root: "/site"}
+	changed, err = r.relocate_elf(exe)
+	if err != nil || !changed {
+		t.Fatalf("could not relocate: changed=%v err=%v", changed, err)
+	}
+	if got := rpath(); got != "/site/lib:/usr/lib" {
+		t.Fatalf("RPATH not relocated: %q", got)
+	}
+}
+
+// EOF
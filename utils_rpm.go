@@ -0,0 +1,708 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RPM header tag types
+const (
+	rpm_int16        = 3
+	rpm_int32        = 4
+	rpm_string       = 6
+	rpm_bin          = 7
+	rpm_string_array = 8
+	rpm_i18nstring   = 9
+)
+
+// RPM dependency flags
+const (
+	rpmsense_less    = 1 << 1
+	rpmsense_greater = 1 << 2
+	rpmsense_equal   = 1 << 3
+	rpmsense_rpmlib  = 1 << 24
+)
+
+// rpm_entry is an entry of a RPM header
+type rpm_entry struct {
+	tag   int32
+	typ   int32
+	count int32
+	data  []byte
+}
+
+// rpm_header is a RPM header structure (signature or main header)
+type rpm_header struct {
+	entries []rpm_entry
+}
+
+func (h *rpm_header) add(tag, typ int32, count int, data []byte) {
+	h.entries = append(h.entries, rpm_entry{tag, typ, int32(count), data})
+}
+
+func (h *rpm_header) add_string(tag int32, v string) {
+	h.add(tag, rpm_string, 1, []byte(v+"\x00"))
+}
+
+func (h *rpm_header) add_i18n(tag int32, v string) {
+	h.add(tag, rpm_i18nstring, 1, []byte(v+"\x00"))
+}
+
+func (h *rpm_header) add_strings(tag int32, v []string) {
+	buf := new(bytes.Buffer)
+	for _, s := range v {
+		buf.WriteString(s)
+		buf.WriteByte(0)
+	}
+	h.add(tag, rpm_string_array, len(v), buf.Bytes())
+}
+
+func (h *rpm_header) add_int32(tag int32, v ...int32) {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, v)
+	h.add(tag, rpm_int32, len(v), buf.Bytes())
+}
+
+func (h *rpm_header) add_int16(tag int32, v ...int16) {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, v)
+	h.add(tag, rpm_int16, len(v), buf.Bytes())
+}
+
+func (h *rpm_header) add_bin(tag int32, v []byte) {
+	h.add(tag, rpm_bin, len(v), v)
+}
+
+// bytes returns the binary representation of the header, with all its
+// entries in an immutable region identified by the region tag.
+func (h *rpm_header) bytes(region int32) []byte {
+	entries := append([]rpm_entry{}, h.entries...)
+	sort.Sort(rpm_entries(entries))
+
+	type index struct {
+		Tag, Typ, Offset, Count int32
+	}
+	nentries := int32(len(entries) + 1)
+	idx := make([]index, 0, nentries)
+	store := new(bytes.Buffer)
+	for _, e := range entries {
+		align := map[int32]int{rpm_int16: 2, rpm_int32: 4}[e.typ]
+		for align > 0 && store.Len()%align != 0 {
+			store.WriteByte(0)
+		}
+		idx = append(idx, index{e.tag, e.typ, int32(store.Len()), e.count})
+		store.Write(e.data)
+	}
+	// the region trailer points back at the whole index
+	trailer := index{region, rpm_bin, -nentries * 16, 16}
+	idx = append([]index{{region, rpm_bin, int32(store.Len()), 16}}, idx...)
+	binary.Write(store, binary.BigEndian, trailer)
+
+	buf := new(bytes.Buffer)
+	buf.Write([]byte{0x8e, 0xad, 0xe8, 0x01, 0, 0, 0, 0})
+	binary.Write(buf, binary.BigEndian, []int32{nentries, int32(store.Len())})
+	binary.Write(buf, binary.BigEndian, idx)
+	buf.Write(store.Bytes())
+	return buf.Bytes()
+}
+
+type rpm_entries []rpm_entry
+
+func (p rpm_entries) Len() int           { return len(p) }
+func (p rpm_entries) Less(i, j int) bool { return p[i].tag < p[j].tag }
+func (p rpm_entries) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// rpm_file_t describes a file of the payload of a RPM
+type rpm_file_t struct {
+	name  string      // absolute path once installed
+	src   string      // path to the file on disk
+	mode  os.FileMode // file mode
+	size  int64       // file size
+	mtime int64       // modification time
+	link  string      // symlink target
+	md5   string      // hex-encoded md5 digest (regular files)
+	data  []byte      // content of the file, when not read from src
+}
+
+// rpm_dep_t is a RPM dependency (Requires/Provides)
+type rpm_dep_t struct {
+	name  string
+	flags int32
+	vers  string
+}
+
+// rpm_infos_t holds the metadata of a RPM
+type rpm_infos_t struct {
+	Name     string
+	Vers     string
+	Release  string
+	Arch     string
+	Url      string
+	Summary  string
+	Requires []rpm_dep_t
+	Provides []rpm_dep_t
+}
+
+// rpm_archnums maps RPM architectures to their number in the RPM lead
+var rpm_archnums = map[string]int16{
+	"i386":    1,
+	"x86_64":  1,
+	"ppc64le": 16,
+	"aarch64": 19,
+}
+
+// rpm_collect_files walks the directory root and returns the files (and
+// symlinks) to install under prefix, sorted by name.
+func rpm_collect_files(root, prefix string) ([]rpm_file_t, error) {
+	files := []rpm_file_t{}
+	err := filepath.Walk(root, func(fname string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, fname)
+		if err != nil {
+			return err
+		}
+		file := rpm_file_t{
+			name:  path.Join("/", prefix, filepath.ToSlash(rel)),
+			src:   fname,
+			mode:  fi.Mode(),
+			size:  fi.Size(),
+			mtime: fi.ModTime().Unix(),
+		}
+		switch {
+		case fi.Mode()&os.ModeSymlink != 0:
+			file.link, err = os.Readlink(fname)
+			if err != nil {
+				return err
+			}
+			file.size = int64(len(file.link))
+		case fi.Mode().IsRegular():
+			f, err := os.Open(fname)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			sum := md5.New()
+			_, err = io.Copy(sum, f)
+			if err != nil {
+				return err
+			}
+			file.md5 = fmt.Sprintf("%x", sum.Sum(nil))
+		default:
+			g_ctx.Warnf("skipping special file [%s]\n", fname)
+			return nil
+		}
+		files = append(files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(rpm_files(files))
+	return files, nil
+}
+
+type rpm_files []rpm_file_t
+
+func (p rpm_files) Len() int           { return len(p) }
+func (p rpm_files) Less(i, j int) bool { return p[i].name < p[j].name }
+func (p rpm_files) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// rpm_elf_deps returns the sonames provided and needed by the ELF shared
+// libraries and executables among files, in the format used by rpmbuild
+// (eg: libfoo.so.1()(64bit)). Sonames provided by the files themselves are
+// not required.
+func rpm_elf_deps(files []rpm_file_t) (provides, requires []string) {
+	provided := make(map[string]bool)
+	needed := make(map[string]bool)
+	for _, file := range files {
+		if !file.mode.IsRegular() || file.src == "" {
+			continue
+		}
+		f, err := elf.Open(file.src)
+		if err != nil {
+			continue
+		}
+		suffix := "()"
+		if f.Class == elf.ELFCLASS64 {
+			suffix = "()(64bit)"
+		}
+		if sonames, err := f.DynString(elf.DT_SONAME); err == nil {
+			for _, soname := range sonames {
+				provided[soname+suffix] = true
+			}
+		}
+		if libs, err := f.ImportedLibraries(); err == nil {
+			for _, lib := range libs {
+				needed[lib+suffix] = true
+			}
+		}
+		f.Close()
+	}
+	for k := range provided {
+		provides = append(provides, k)
+	}
+	for k := range needed {
+		if !provided[k] {
+			requires = append(requires, k)
+		}
+	}
+	sort.Strings(provides)
+	sort.Strings(requires)
+	return provides, requires
+}
+
+// rpm_write_cpio writes the files as a cpio archive (SVR4 'newc' format,
+// with names relative to '.' as expected by RPM) and returns its size.
+func rpm_write_cpio(w io.Writer, files []rpm_file_t) (int64, error) {
+	var n int64
+	write := func(ino int, mode uint32, mtime, size int64, name string, r io.Reader) error {
+		hdr := fmt.Sprintf("070701%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x",
+			ino, mode, 0, 0, 1, mtime, size, 0, 0, 0, 0, len(name)+1, 0,
+		)
+		buf := new(bytes.Buffer)
+		buf.WriteString(hdr)
+		buf.WriteString(name)
+		buf.WriteByte(0)
+		for buf.Len()%4 != 0 {
+			buf.WriteByte(0)
+		}
+		nn, err := w.Write(buf.Bytes())
+		n += int64(nn)
+		if err != nil {
+			return err
+		}
+		if r != nil {
+			nc, err := io.Copy(w, r)
+			n += nc
+			if err != nil {
+				return err
+			}
+			if nc != size {
+				return fmt.Errorf("hwaf: cpio: [%s] changed while archiving", name)
+			}
+		}
+		if pad := (4 - size%4) % 4; pad != 0 {
+			nn, err = w.Write(make([]byte, pad))
+			n += int64(nn)
+		}
+		return err
+	}
+
+	for i, file := range files {
+		if file.mode.IsDir() {
+			err := write(i+1, rpm_file_mode(file), file.mtime, 0, "."+file.name, nil)
+			if err != nil {
+				return n, err
+			}
+			continue
+		}
+		if file.link != "" {
+			err := write(i+1, rpm_file_mode(file), file.mtime, file.size, "."+file.name, strings.NewReader(file.link))
+			if err != nil {
+				return n, err
+			}
+			continue
+		}
+		if file.data != nil {
+			err := write(i+1, rpm_file_mode(file), file.mtime, file.size, "."+file.name, bytes.NewReader(file.data))
+			if err != nil {
+				return n, err
+			}
+			continue
+		}
+		f, err := os.Open(file.src)
+		if err != nil {
+			return n, err
+		}
+		err = write(i+1, rpm_file_mode(file), file.mtime, file.size, "."+file.name, f)
+		f.Close()
+		if err != nil {
+			return n, err
+		}
+	}
+	err := write(0, 0, 0, 0, "TRAILER!!!", nil)
+	return n, err
+}
+
+// rpm_file_mode returns the unix mode (file type and permissions) of a file
+func rpm_file_mode(file rpm_file_t) uint32 {
+	switch {
+	case file.link != "":
+		return 0120777
+	case file.mode.IsDir():
+		return 0040000 | uint32(file.mode.Perm())
+	}
+	return 0100000 | uint32(file.mode.Perm())
+}
+
+// rpm_add_dirs returns files together with entries for the directories
+// holding them under prefix (prefix itself excluded), so that they are
+// owned by the RPM and removed with it, sorted by name.
+func rpm_add_dirs(files []rpm_file_t, prefix string) []rpm_file_t {
+	prefix = path.Join("/", prefix)
+	under := strings.TrimSuffix(prefix, "/") + "/"
+	dirs := make(map[string]bool)
+	out := make([]rpm_file_t, 0, len(files))
+	for _, file := range files {
+		if file.mode.IsDir() {
+			dirs[file.name] = true
+		}
+	}
+	for _, file := range files {
+		out = append(out, file)
+		for dir := path.Dir(file.name); dir != prefix && strings.HasPrefix(dir, under); dir = path.Dir(dir) {
+			if dirs[dir] {
+				break
+			}
+			dirs[dir] = true
+			out = append(out, rpm_file_t{
+				name:  dir,
+				mode:  os.ModeDir | 0755,
+				mtime: file.mtime,
+			})
+		}
+	}
+	sort.Sort(rpm_files(out))
+	return out
+}
+
+// rpm_build_time returns the build time recorded in a RPM: the value of
+// $SOURCE_DATE_EPOCH, or the modification time of the newest file.
+func rpm_build_time(files []rpm_file_t) int64 {
+	if t, ok := source_date_epoch(); ok {
+		return t
+	}
+	var t int64
+	for _, file := range files {
+		if file.mtime > t {
+			t = file.mtime
+		}
+	}
+	if t == 0 {
+		t = time.Now().Unix()
+	}
+	return t
+}
+
+// rpm_buildhost is the build host recorded in the RPMs, so that the same
+// install area always yields the same RPM.
+const rpm_buildhost = "localhost"
+
+// rpm_write writes a binary RPM (lead, signature, header and gzip-compressed
+// cpio payload) holding files.
+// As for tarballs, all the files are recorded with the build time of the
+// RPM as modification time (see rpm_build_time).
+func rpm_write(w io.Writer, infos rpm_infos_t, files []rpm_file_t) error {
+	btime := rpm_build_time(files)
+	files = append([]rpm_file_t(nil), files...)
+	for i := range files {
+		files[i].mtime = btime
+	}
+
+	// payload
+	payload := new(bytes.Buffer)
+	zw, err := gzip.NewWriterLevel(payload, gzip.BestCompression)
+	if err != nil {
+		return err
+	}
+	payload_size, err := rpm_write_cpio(zw, files)
+	if err != nil {
+		return err
+	}
+	err = zw.Close()
+	if err != nil {
+		return err
+	}
+
+	// main header
+	hdr := &rpm_header{}
+	hdr.add_strings(100, []string{"C"}) // HEADERI18NTABLE
+	hdr.add_string(1000, infos.Name)
+	hdr.add_string(1001, infos.Vers)
+	hdr.add_string(1002, infos.Release)
+	hdr.add_i18n(1004, infos.Summary)
+	hdr.add_i18n(1005, infos.Summary)
+	hdr.add_int32(1006, int32(btime))
+	hdr.add_string(1007, rpm_buildhost)
+	var size int64
+	for _, file := range files {
+		size += file.size
+	}
+	hdr.add_int32(1009, int32(size))
+	hdr.add_string(1014, "Unknown")
+	hdr.add_i18n(1016, "Development/Tools")
+	hdr.add_string(1020, infos.Url)
+	hdr.add_string(1021, "linux")
+	hdr.add_string(1022, infos.Arch)
+	hdr.add_string(1064, "4.4.2")                        // RPMVERSION
+	hdr.add_string(1124, "cpio")                         // PAYLOADFORMAT
+	hdr.add_string(1125, "gzip")                         // PAYLOADCOMPRESSOR
+	hdr.add_string(1126, "9")                            // PAYLOADFLAGS
+	hdr.add_int32(5011, 1)                               // FILEDIGESTALGO: md5
+	hdr.add_string(1132, infos.Arch+"-redhat-linux-gnu") // PLATFORM
+
+	if len(files) > 0 {
+		var (
+			sizes    []int32
+			modes    []int16
+			rdevs    []int16
+			mtimes   []int32
+			digests  []string
+			links    []string
+			flags    []int32
+			users    []string
+			groups   []string
+			verify   []int32
+			devices  []int32
+			inodes   []int32
+			langs    []string
+			dirindex []int32
+			basename []string
+			dirnames []string
+		)
+		dirs := make(map[string]int32)
+		for i, file := range files {
+			sizes = append(sizes, int32(file.size))
+			modes = append(modes, int16(rpm_file_mode(file)))
+			rdevs = append(rdevs, 0)
+			mtimes = append(mtimes, int32(file.mtime))
+			digests = append(digests, file.md5)
+			links = append(links, file.link)
+			flags = append(flags, 0)
+			users = append(users, "root")
+			groups = append(groups, "root")
+			verify = append(verify, -1)
+			devices = append(devices, 1)
+			inodes = append(inodes, int32(i+1))
+			langs = append(langs, "")
+			dir := path.Dir(file.name)
+			if dir != "/" {
+				dir += "/"
+			}
+			idx, ok := dirs[dir]
+			if !ok {
+				idx = int32(len(dirnames))
+				dirs[dir] = idx
+				dirnames = append(dirnames, dir)
+			}
+			dirindex = append(dirindex, idx)
+			basename = append(basename, path.Base(file.name))
+		}
+		hdr.add_int32(1028, sizes...)
+		hdr.add_int16(1030, modes...)
+		hdr.add_int16(1033, rdevs...)
+		hdr.add_int32(1034, mtimes...)
+		hdr.add_strings(1035, digests)
+		hdr.add_strings(1036, links)
+		hdr.add_int32(1037, flags...)
+		hdr.add_strings(1039, users)
+		hdr.add_strings(1040, groups)
+		hdr.add_int32(1045, verify...)
+		hdr.add_int32(1095, devices...)
+		hdr.add_int32(1096, inodes...)
+		hdr.add_strings(1097, langs)
+		hdr.add_int32(1116, dirindex...)
+		hdr.add_strings(1117, basename)
+		hdr.add_strings(1118, dirnames)
+	}
+
+	provides := append([]rpm_dep_t{
+		{infos.Name, rpmsense_equal, infos.Vers + "-" + infos.Release},
+	}, infos.Provides...)
+	requires := append([]rpm_dep_t{
+		{"rpmlib(CompressedFileNames)", rpmsense_less | rpmsense_equal | rpmsense_rpmlib, "3.0.4-1"},
+		{"rpmlib(PayloadFilesHavePrefix)", rpmsense_less | rpmsense_equal | rpmsense_rpmlib, "4.0-1"},
+	}, infos.Requires...)
+	for _, deps := range []struct {
+		name, flags, vers int32
+		deps              []rpm_dep_t
+	}{
+		{1047, 1112, 1113, provides},
+		{1049, 1048, 1050, requires},
+	} {
+		var (
+			names []string
+			flags []int32
+			vers  []string
+		)
+		for _, dep := range deps.deps {
+			names = append(names, dep.name)
+			flags = append(flags, dep.flags)
+			vers = append(vers, dep.vers)
+		}
+		hdr.add_strings(deps.name, names)
+		hdr.add_int32(deps.flags, flags...)
+		hdr.add_strings(deps.vers, vers)
+	}
+	hdrbuf := hdr.bytes(63) // HEADERIMMUTABLE
+
+	// signature
+	sig := &rpm_header{}
+	sig.add_int32(1000, int32(len(hdrbuf)+payload.Len())) // SIZE
+	sum := md5.New()
+	sum.Write(hdrbuf)
+	sum.Write(payload.Bytes())
+	sig.add_bin(1004, sum.Sum(nil))          // MD5
+	sig.add_int32(1007, int32(payload_size)) // PAYLOADSIZE
+	sig.add_string(269, fmt.Sprintf("%x", sha1.Sum(hdrbuf)))
+	sig.add_string(273, fmt.Sprintf("%x", sha256.Sum256(hdrbuf)))
+	sigbuf := sig.bytes(62) // HEADERSIGNATURES
+	for len(sigbuf)%8 != 0 {
+		sigbuf = append(sigbuf, 0)
+	}
+
+	// lead
+	lead := new(bytes.Buffer)
+	lead.Write([]byte{0xed, 0xab, 0xee, 0xdb, 3, 0})
+	binary.Write(lead, binary.BigEndian, []int16{0, rpm_archnums[infos.Arch]})
+	name := make([]byte, 66)
+	copy(name[:65], infos.Name+"-"+infos.Vers+"-"+infos.Release)
+	lead.Write(name)
+	binary.Write(lead, binary.BigEndian, []int16{1, 5})
+	lead.Write(make([]byte, 16))
+
+	for _, buf := range [][]byte{lead.Bytes(), sigbuf, hdrbuf, payload.Bytes()} {
+		_, err = w.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rpm_skip_headers reads the lead, the signature and the header of the RPM
+// r, leaving r at the start of the (compressed) payload.
+func rpm_skip_headers(r io.Reader) error {
+	lead := make([]byte, 96)
+	_, err := io.ReadFull(r, lead)
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(lead, []byte{0xed, 0xab, 0xee, 0xdb}) {
+		return fmt.Errorf("hwaf: not a RPM file (invalid lead)")
+	}
+	for i, name := range []string{"signature", "header"} {
+		preamble := make([]byte, 16)
+		_, err = io.ReadFull(r, preamble)
+		if err != nil {
+			return err
+		}
+		if !bytes.HasPrefix(preamble, []byte{0x8e, 0xad, 0xe8, 0x01}) {
+			return fmt.Errorf("hwaf: invalid RPM %s", name)
+		}
+		nentries := int64(binary.BigEndian.Uint32(preamble[8:12]))
+		size := nentries*16 + int64(binary.BigEndian.Uint32(preamble[12:16]))
+		if i == 0 {
+			// the signature is padded to a multiple of 8 bytes
+			size += (8 - size%8) % 8
+		}
+		_, err = io.CopyN(ioutil.Discard, r, size)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rpm_read_cpio reads the (uncompressed) cpio payload of a RPM, calling fct
+// for each of its entries.
+func rpm_read_cpio(r io.Reader, fct func(name string, mode os.FileMode, size int64, r io.Reader) error) error {
+	var pos int64
+	skip := func(n int64) error {
+		_, err := io.CopyN(ioutil.Discard, r, n)
+		pos += n
+		return err
+	}
+	align := func() error {
+		return skip((4 - pos%4) % 4)
+	}
+	for {
+		hdr := make([]byte, 110)
+		_, err := io.ReadFull(r, hdr)
+		if err != nil {
+			return err
+		}
+		pos += 110
+		if string(hdr[:6]) != "070701" {
+			return fmt.Errorf("hwaf: invalid cpio entry (only the newc format is supported)")
+		}
+		field := func(i int) (int64, error) {
+			return strconv.ParseInt(string(hdr[6+8*i:14+8*i]), 16, 64)
+		}
+		mode, err := field(1)
+		if err != nil {
+			return err
+		}
+		size, err := field(6)
+		if err != nil {
+			return err
+		}
+		namesize, err := field(11)
+		if err != nil {
+			return err
+		}
+		name := make([]byte, namesize)
+		_, err = io.ReadFull(r, name)
+		if err != nil {
+			return err
+		}
+		pos += namesize
+		err = align()
+		if err != nil {
+			return err
+		}
+		fname := string(bytes.TrimRight(name, "\x00"))
+		if fname == "TRAILER!!!" {
+			return nil
+		}
+
+		fmode := os.FileMode(mode & 0777)
+		switch mode & 0170000 {
+		case 0040000:
+			fmode |= os.ModeDir
+		case 0120000:
+			fmode |= os.ModeSymlink
+		case 0100000:
+		default:
+			// special file
+			fmode |= os.ModeDevice
+		}
+		data := io.LimitReader(r, size)
+		err = fct(fname, fmode, size, data)
+		if err != nil {
+			return err
+		}
+		// skip what fct did not read
+		_, err = io.Copy(ioutil.Discard, data)
+		if err != nil {
+			return err
+		}
+		pos += size
+		err = align()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// EOF
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRpmHeaderBytes(t *testing.T) {
+	hdr := &rpm_header{}
+	hdr.add_int16(1030, 0644, 0755)
+	hdr.add_string(1000, "mana")
+	hdr.add_int32(1006, 1357000000)
+	hdr.add_strings(1117, []string{"a", "bc"})
+
+	buf := hdr.bytes(63)
+	if !bytes.HasPrefix(buf, []byte{0x8e, 0xad, 0xe8, 0x01, 0, 0, 0, 0}) {
+		t.Fatalf("invalid header magic: %x", buf[:8])
+	}
+	nentries := int32(binary.BigEndian.Uint32(buf[8:12]))
+	size := int32(binary.BigEndian.Uint32(buf[12:16]))
+	if nentries != 5 {
+		t.Fatalf("invalid number of entries: got=%d want=%d", nentries, 5)
+	}
+	if int(16+16*nentries+size) != len(buf) {
+		t.Fatalf("invalid header size: got=%d want=%d", 16+16*nentries+size, len(buf))
+	}
+
+	type index struct {
+		Tag, Typ, Offset, Count int32
+	}
+	idx := make([]index, nentries)
+	err := binary.Read(bytes.NewReader(buf[16:]), binary.BigEndian, idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := buf[16+16*nentries:]
+
+	// the region tag comes first and points at the trailer
+	if idx[0] != (index{63, rpm_bin, size - 16, 16}) {
+		t.Fatalf("invalid region index: %+v", idx[0])
+	}
+	var trailer index
+	binary.Read(bytes.NewReader(store[size-16:]), binary.BigEndian, &trailer)
+	if trailer != (index{63, rpm_bin, -nentries * 16, 16}) {
+		t.Fatalf("invalid region trailer: %+v", trailer)
+	}
+
+	// then the entries, sorted by tag, with aligned numbers
+	for i, want := range []struct {
+		tag, typ, count int32
+		data            []byte
+	}{
+		{1000, rpm_string, 1, []byte("mana\x00")},
+		{1006, rpm_int32, 1, []byte{0x50, 0xe2, 0x2d, 0x40}},
+		{1030, rpm_int16, 2, []byte{0x01, 0xa4, 0x01, 0xed}},
+		{1117, rpm_string_array, 2, []byte("a\x00bc\x00")},
+	} {
+		got := idx[i+1]
+		if got.Tag != want.tag || got.Typ != want.typ || got.Count != want.count {
+			t.Errorf("entry #%d: got=%+v want=%+v", i, got, want)
+			continue
+		}
+		switch got.Typ {
+		case rpm_int16:
+			if got.Offset%2 != 0 {
+				t.Errorf("entry #%d: misaligned int16 (offset=%d)", i, got.Offset)
+			}
+		case rpm_int32:
+			if got.Offset%4 != 0 {
+				t.Errorf("entry #%d: misaligned int32 (offset=%d)", i, got.Offset)
+			}
+		}
+		data := store[got.Offset : int(got.Offset)+len(want.data)]
+		if !bytes.Equal(data, want.data) {
+			t.Errorf("entry #%d: got=%q want=%q", i, data, want.data)
+		}
+	}
+}
+
+func TestRpmWriteCpio(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "hwaf-test-rpm-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	src := filepath.Join(tmpdir, "libmana.so")
+	err = ioutil.WriteFile(src, []byte("libmana"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := rpm_add_dirs([]rpm_file_t{
+		{name: "/lib/libmana.so", src: src, mode: 0755, size: 7},
+		{name: "/lib/libmana.so.1", mode: os.ModeSymlink | 0777, size: 10, link: "libmana.so"},
+		{name: "/share/mana/doc/README", mode: 0644, size: 5, data: []byte("hello")},
+	}, "/")
+
+	buf := new(bytes.Buffer)
+	n, err := rpm_write_cpio(buf, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) || n%4 != 0 {
+		t.Fatalf("invalid cpio size: n=%d len=%d", n, buf.Len())
+	}
+
+	got := []string{}
+	err = rpm_read_cpio(buf, func(name string, mode os.FileMode, size int64, r io.Reader) error {
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		got = append(got, fmt.Sprintf("%s %v %q", name, mode, data))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`./lib drwxr-xr-x ""`,
+		`./lib/libmana.so -rwxr-xr-x "libmana"`,
+		`./lib/libmana.so.1 Lrwxrwxrwx "libmana.so"`,
+		`./share drwxr-xr-x ""`,
+		`./share/mana drwxr-xr-x ""`,
+		`./share/mana/doc drwxr-xr-x ""`,
+		`./share/mana/doc/README -rw-r--r-- "hello"`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid cpio content:\ngot=  %q\nwant= %q", got, want)
+	}
+}
+
+func TestRpmAddDirs(t *testing.T) {
+	for _, table := range []struct {
+		prefix string
+		files  []string
+		want   []string
+	}{
+		{
+			prefix: "/",
+			files:  []string{"/bin/mana", "/lib/libmana.so"},
+			want:   []string{"/bin", "/bin/mana", "/lib", "/lib/libmana.so"},
+		},
+		{
+			prefix: "/opt/mana",
+			files:  []string{"/opt/mana/bin/mana", "/opt/mana/README"},
+			want:   []string{"/opt/mana/README", "/opt/mana/bin", "/opt/mana/bin/mana"},
+		},
+		{
+			// directories outside the prefix are not owned
+			prefix: "/opt/mana",
+			files:  []string{"/opt/mana-devel/include/mana.h"},
+			want:   []string{"/opt/mana-devel/include/mana.h"},
+		},
+		{
+			prefix: "/",
+			files:  []string{"/a/b/c/d"},
+			want:   []string{"/a", "/a/b", "/a/b/c", "/a/b/c/d"},
+		},
+	} {
+		files := []rpm_file_t{}
+		for _, name := range table.files {
+			files = append(files, rpm_file_t{name: name, mode: 0644})
+		}
+		got := []string{}
+		for _, file := range rpm_add_dirs(files, table.prefix) {
+			got = append(got, file.name)
+			if file.mode.IsDir() && rpm_file_mode(file) != 040755 {
+				t.Errorf("%s: invalid directory mode %o", file.name, rpm_file_mode(file))
+			}
+		}
+		if !reflect.DeepEqual(got, table.want) {
+			t.Errorf("rpm_add_dirs(%v, %q):\ngot=  %v\nwant= %v", table.files, table.prefix, got, table.want)
+		}
+	}
+}
+
+func TestRpmWrite(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "hwaf-test-rpm-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	infos := rpm_infos_t{
+		Name:    "mana",
+		Vers:    "1.0",
+		Release: "1",
+		Arch:    "x86_64",
+		Summary: "hwaf generated RPM for mana",
+	}
+	mkfiles := func(mtime int64) []rpm_file_t {
+		data := []byte("#!/bin/sh\n")
+		return rpm_add_dirs([]rpm_file_t{{
+			name:  "/bin/mana",
+			mode:  0755,
+			size:  int64(len(data)),
+			mtime: mtime,
+			md5:   fmt.Sprintf("%x", md5.Sum(data)),
+			data:  data,
+		}}, "/")
+	}
+
+	// the RPM only depends on $SOURCE_DATE_EPOCH and the files
+	defer os.Setenv("SOURCE_DATE_EPOCH", os.Getenv("SOURCE_DATE_EPOCH"))
+	os.Setenv("SOURCE_DATE_EPOCH", "1357000000")
+	rpms := [][]byte{}
+	for _, mtime := range []int64{1300000000, 1400000000} {
+		buf := new(bytes.Buffer)
+		err = rpm_write(buf, infos, mkfiles(mtime))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rpms = append(rpms, buf.Bytes())
+	}
+	if !bytes.Equal(rpms[0], rpms[1]) {
+		t.Fatalf("RPM depends on the modification times of the files")
+	}
+
+	r := bytes.NewReader(rpms[0])
+	err = rpm_skip_headers(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	err = rpm_read_cpio(zr, func(name string, mode os.FileMode, size int64, r io.Reader) error {
+		names = append(names, name)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"./bin", "./bin/mana"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("invalid payload: got=%v want=%v", names, want)
+	}
+
+	// golden check against rpm itself, when available
+	rpm, err := exec.LookPath("rpm")
+	if err != nil {
+		t.Skip("no rpm")
+	}
+	fname := filepath.Join(tmpdir, "mana-1.0-1.x86_64.rpm")
+	err = ioutil.WriteFile(fname, rpms[0], 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command(rpm, "-qp", "--nosignature",
+		"--queryformat", "%{NAME}-%{VERSION}-%{RELEASE}.%{ARCH} %{BUILDHOST} %{BUILDTIME}\n",
+		fname,
+	).CombinedOutput()
+	if err != nil {
+		t.Fatalf("rpm -qp: %v\n%s", err, out)
+	}
+	if got, want := string(out), "mana-1.0-1.x86_64 localhost 1357000000\n"; got != want {
+		t.Fatalf("rpm -qp:\ngot=  %q\nwant= %q", got, want)
+	}
+	out, err = exec.Command(rpm, "-qp", "--nosignature", "--dump", fname).CombinedOutput()
+	if err != nil {
+		t.Fatalf("rpm -qp --dump: %v\n%s", err, out)
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) != 2 ||
+		!strings.HasPrefix(lines[0], "/bin 0 1357000000 ") || !strings.Contains(lines[0], " 040755 ") ||
+		!strings.HasPrefix(lines[1], "/bin/mana 10 1357000000 ") || !strings.Contains(lines[1], " 0100755 ") {
+		t.Fatalf("rpm -qp --dump:\n%s", out)
+	}
+}
+
+// EOF
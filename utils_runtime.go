@@ -0,0 +1,380 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hwaf/hwaf/hwaflib"
+)
+
+// runtime_env_t is the runtime environment of the local project, computed
+// from the waf configuration cache and cached in the build directory so
+// 'hwaf run' and 'hwaf shell' do not need to start waf.
+type runtime_env_t struct {
+	Format  int        `json:"format"`  // format of the cached environment
+	Version string     `json:"version"` // version of hwaf which computed the environment
+	ModTime int64      `json:"mtime"`   // modification time of the waf cache
+	Size    int64      `json:"size"`    // size of the waf cache
+	Ops     []env_op_t `json:"ops"`     // operations setting up the runtime environment
+}
+
+// runtime_env_format is the current format of the cached runtime environment
+// (1: operations computed from the runtime variables of the project,
+// 2: with absolute directories)
+const runtime_env_format = 2
+
+// runtime_env_files returns the name of the waf configuration cache and the
+// name of the cached runtime environment derived from it.
+func runtime_env_files() (string, string, error) {
+	workdir, err := g_ctx.Workarea()
+	if err != nil {
+		return "", "", err
+	}
+	bldir := filepath.Join(workdir, "__build__")
+	cache := filepath.Join(bldir, "c4che", "_cache.py")
+	fname := filepath.Join(bldir, "hwaf-runtime-env.json")
+	return cache, fname, nil
+}
+
+// hwaf_update_runtime_env computes the runtime environment of the local
+// project and caches it in the build directory.
+func hwaf_update_runtime_env() ([]env_op_t, error) {
+	cache, fname, err := runtime_env_files()
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := os.Stat(cache)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"no such file [%s]. did you run \"hwaf configure\" ?",
+			cache,
+		)
+	}
+
+	pinfos, err := g_ctx.ProjectInfos()
+	if err != nil {
+		return nil, err
+	}
+	workdir, err := g_ctx.Workarea()
+	if err != nil {
+		return nil, err
+	}
+	ops, err := runtime_env_ops(pinfos, workdir)
+	if err != nil {
+		return nil, err
+	}
+
+	renv := runtime_env_t{
+		Format:  runtime_env_format,
+		Version: g_ctx.Version() + "-" + g_ctx.Revision(),
+		ModTime: fi.ModTime().UnixNano(),
+		Size:    fi.Size(),
+		Ops:     ops,
+	}
+	buf, err := json.MarshalIndent(renv, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	err = ioutil.WriteFile(fname, buf, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return runtime_env_clean(ops), nil
+}
+
+// runtime_env_cleaned_vars are the path lists from which the runtime
+// environment drops the directories which do not exist (see
+// runtime_env_clean)
+var runtime_env_cleaned_vars = map[string]bool{
+	"PATH":              true,
+	"LD_LIBRARY_PATH":   true,
+	"DYLD_LIBRARY_PATH": true,
+	"PYTHONPATH":        true,
+}
+
+// runtime_env_ops returns the operations setting up the runtime environment
+// of the project described by pinfos: the runtime variables
+// (HWAF_RUNTIME_ENVVARS) ending with PATH are prepended with their value in
+// the waf configuration, the other ones are set to it. (as waf's 'run' does)
+// The install area is prepended to PATH and (DY)LD_LIBRARY_PATH.
+// As with waf, the directories of the path lists are made absolute
+// (relative to the top directory topdir of the workarea).
+// The operations do not depend on the environment hwaf runs in: they are
+// only compared to it when applied. (see also runtime_env_clean)
+func runtime_env_ops(pinfos *hwaflib.ProjectInfos, topdir string) ([]env_op_t, error) {
+	keys, err := pinfos.GetList("HWAF_RUNTIME_ENVVARS")
+	if err != nil {
+		g_ctx.Debugf("hwaf: no runtime variables (%v)\n", err)
+	}
+	sort.Strings(keys)
+
+	// entries added to the path-like variables by the install area
+	prepend := map[string][]string{}
+	if v, err := pinfos.Get("HWAF_ENABLE_INSTALL_AREA"); err == nil && py_is_true(v) {
+		install_area, err := project_install_area(pinfos)
+		if err != nil {
+			return nil, err
+		}
+		bindir := filepath.Join(install_area, "bin")
+		libdir := filepath.Join(install_area, "lib")
+		prepend["PATH"] = []string{bindir}
+		prepend["LD_LIBRARY_PATH"] = []string{libdir}
+		prepend["DYLD_LIBRARY_PATH"] = []string{libdir}
+	}
+
+	ops := make([]env_op_t, 0, len(keys))
+	seen := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		if seen[k] || env_session_vars[k] {
+			continue
+		}
+		seen[k] = true
+
+		var values []string
+		if v, err := pinfos.GetValue(k); err == nil {
+			switch v := v.(type) {
+			case string:
+				values = []string{v}
+			case []interface{}:
+				for _, elem := range v {
+					if str, ok := elem.(string); ok {
+						values = append(values, str)
+					}
+				}
+			}
+		}
+
+		if !strings.HasSuffix(k, "PATH") {
+			if len(values) > 0 {
+				ops = append(ops, env_op_t{Action: env_set, Key: k, Value: strings.Join(values, " ")})
+			}
+			continue
+		}
+
+		dirs := make([]string, 0, len(values)+1)
+		uniq := make(map[string]bool, len(values)+1)
+		for _, list := range [][]string{prepend[k], values} {
+			for _, v := range list {
+				for _, dir := range filepath.SplitList(v) {
+					if dir == "" {
+						continue
+					}
+					if !filepath.IsAbs(dir) {
+						dir = filepath.Join(topdir, dir)
+					}
+					dir = filepath.Clean(dir)
+					if uniq[dir] {
+						continue
+					}
+					uniq[dir] = true
+					dirs = append(dirs, dir)
+				}
+			}
+		}
+		if len(dirs) > 0 {
+			ops = append(ops, env_op_t{Action: env_prepend, Key: k, Value: strings.Join(dirs, env_pathsep)})
+		}
+	}
+	return ops, nil
+}
+
+// runtime_env_clean drops the directories which do not exist (yet) from
+// the operations on runtime_env_cleaned_vars, as waf's 'run' does.
+// This is done each time the runtime environment is used, not when it is
+// cached: the build and the installation create directories.
+func runtime_env_clean(ops []env_op_t) []env_op_t {
+	out := make([]env_op_t, 0, len(ops))
+	for _, op := range ops {
+		if runtime_env_cleaned_vars[op.Key] && (op.Action == env_prepend || op.Action == env_append) {
+			dirs := []string{}
+			for _, dir := range filepath.SplitList(op.Value) {
+				if path_exists(dir) {
+					dirs = append(dirs, dir)
+				}
+			}
+			if len(dirs) == 0 {
+				continue
+			}
+			op.Value = strings.Join(dirs, env_pathsep)
+		}
+		out = append(out, op)
+	}
+	return out
+}
+
+// py_is_true returns whether the python representation v is a true value
+func py_is_true(v string) bool {
+	switch strings.TrimSpace(v) {
+	case "", "0", "False", "None", "[]", "()", "{}", "''", `""`:
+		return false
+	}
+	return true
+}
+
+// hwaf_runtime_env returns the runtime environment of the local project,
+// from the build directory if it is still up-to-date with the waf
+// configuration cache and this version of hwaf.
+func hwaf_runtime_env() ([]env_op_t, error) {
+	cache, fname, err := runtime_env_files()
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := os.Stat(cache)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"no such file [%s]. did you run \"hwaf configure\" ?",
+			cache,
+		)
+	}
+
+	buf, err := ioutil.ReadFile(fname)
+	if err == nil {
+		var renv runtime_env_t
+		err = json.Unmarshal(buf, &renv)
+		if err == nil &&
+			renv.Format == runtime_env_format &&
+			renv.Version == g_ctx.Version()+"-"+g_ctx.Revision() &&
+			renv.ModTime == fi.ModTime().UnixNano() &&
+			renv.Size == fi.Size() {
+			return runtime_env_clean(renv.Ops), nil
+		}
+		g_ctx.Debugf("hwaf: stale runtime environment [%s]\n", fname)
+	}
+
+	return hwaf_update_runtime_env()
+}
+
+// hwaf_runtime_environ returns the current environment, modified by the
+// runtime environment of the local project and then by the .hwaf/env.d
+// snippets of the workarea (so they take precedence over the runtime
+// environment). It also returns the names of the variables they modify.
+func hwaf_runtime_environ() (map[string]string, []string, error) {
+	ops, err := hwaf_runtime_env()
+	if err != nil {
+		return nil, nil, err
+	}
+	snippets, err := g_ctx.EnvSnippets()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the snippets were already applied to the environment at startup:
+	// start again from the value the variables they modify had when hwaf
+	// was invoked, so they are applied only once.
+	env := environ_map(os.Environ())
+	initial := environ_map(g_ctx.InitialEnviron())
+	keys := make([]string, 0, len(ops)+len(snippets))
+	for _, op := range ops {
+		keys = append(keys, op.Key)
+	}
+	for _, op := range snippets {
+		keys = append(keys, op.Key)
+		if v, ok := initial[op.Key]; ok {
+			env[op.Key] = v
+		} else {
+			delete(env, op.Key)
+		}
+	}
+
+	env_apply(env, ops)
+	for _, op := range hwaflib.ApplyEnvOps(env, snippets) {
+		g_ctx.Warnf("%v: empty value, ignored\n", op)
+	}
+	return env, keys, nil
+}
+
+// runtime_aliases returns the runtime aliases (HWAF_RUNTIME_ALIASES)
+// declared by the local project, as (alias, command) pairs.
+func runtime_aliases() ([][2]string, error) {
+	pinfos, err := g_ctx.ProjectInfos()
+	if err != nil {
+		return nil, err
+	}
+	v, err := pinfos.GetValue("HWAF_RUNTIME_ALIASES")
+	if err != nil {
+		// no alias declared
+		return nil, nil
+	}
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("hwaf: HWAF_RUNTIME_ALIASES is not a list (%v)", v)
+	}
+	aliases := make([][2]string, 0, len(list))
+	for _, elem := range list {
+		alias, ok := elem.([]interface{})
+		if !ok || len(alias) != 2 {
+			return nil, fmt.Errorf("hwaf: invalid runtime alias (%v)", elem)
+		}
+		dst, ok1 := alias[0].(string)
+		src, ok2 := alias[1].(string)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("hwaf: invalid runtime alias (%v)", elem)
+		}
+		aliases = append(aliases, [2]string{dst, src})
+	}
+	return aliases, nil
+}
+
+// env_lookpath searches for an executable named name in the directories
+// of the $PATH of the environment env.
+func env_lookpath(name string, env map[string]string) (string, error) {
+	if strings.Contains(name, "/") {
+		return name, nil
+	}
+	for _, dir := range filepath.SplitList(env["PATH"]) {
+		if dir == "" {
+			dir = "."
+		}
+		exe := filepath.Join(dir, name)
+		fi, err := os.Stat(exe)
+		if err == nil && fi.Mode().IsRegular() && fi.Mode().Perm()&0111 != 0 {
+			return exe, nil
+		}
+	}
+	return "", fmt.Errorf("hwaf: executable file [%s] not found in $PATH", name)
+}
+
+// re_env_expand matches the placeholders of python's string.Template: $$,
+// $var and ${var}
+var re_env_expand = regexp.MustCompile(`\$(?:\$|[_A-Za-z][_A-Za-z0-9]*|\{[_A-Za-z][_A-Za-z0-9]*\})`)
+
+// env_expand replaces $var and ${var} in s by the value of var in env and
+// $$ by $, as python's string.Template.safe_substitute does (and waf's
+// 'run' did): unknown variables and any other $ (eg: a${b, ${}, $1) are
+// left untouched.
+func env_expand(s string, env map[string]string) string {
+	return re_env_expand.ReplaceAllStringFunc(s, func(m string) string {
+		if m == "$$" {
+			return "$"
+		}
+		if v, ok := env[strings.Trim(m[1:], "{}")]; ok {
+			return v
+		}
+		return m
+	})
+}
+
+// hwaf_post_install refreshes the cached runtime environment and the setup
+// scripts of the install area, after a successful installation.
+func hwaf_post_install() {
+	ops, err := hwaf_update_runtime_env()
+	if err != nil {
+		g_ctx.Warnf("could not compute the runtime environment: %v\n", err)
+		return
+	}
+
+	err = hwaf_write_setup_scripts(ops)
+	if err != nil {
+		g_ctx.Warnf("could not write setup scripts: %v\n", err)
+	}
+}
+
+// EOF
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hwaf/hwaf/hwaflib"
+)
+
+// py_runtime_env is a driver running the _hwaf_get_runtime_env function of
+// py-hwaftools (outside of waf) on a waf configuration cache.
+const py_runtime_env = `
+import ast, json, os, sys
+fname, cache, environ = sys.argv[1], sys.argv[2], json.loads(sys.argv[3])
+
+mod = ast.parse(open(fname).read(), fname)
+fct = [n for n in mod.body if isinstance(n, ast.FunctionDef) and n.name == '_hwaf_get_runtime_env'][0]
+fct.decorator_list = []
+class msg:
+    debug = warning = info = staticmethod(lambda *args: None)
+ns = {'os': os, 'osp': os.path, 'msg': msg}
+exec(compile(ast.Module(body=[fct], type_ignores=[]), fname, 'exec'), ns)
+
+class ConfigSet(dict):
+    def __getattr__(self, k):
+        return self.get(k, [])
+class Ctx(object):
+    env = ConfigSet()
+    is_linux = lambda self: True
+    is_darwin = lambda self: False
+    is_windows = lambda self: False
+ctx = Ctx()
+for line in open(cache):
+    k, sep, v = line.partition(' = ')
+    if sep:
+        ctx.env[k.strip()] = eval(v)
+
+os.environ.clear()
+os.environ.update(environ)
+print(json.dumps(ns['_hwaf_get_runtime_env'](ctx)))
+`
+
+// py_safe_substitute expands strings as waf's 'run' does
+const py_safe_substitute = `
+import json, sys
+from string import Template
+strs, env = json.loads(sys.argv[1]), json.loads(sys.argv[2])
+print(json.dumps([Template(s).safe_substitute(env) for s in strs]))
+`
+
+// py_run runs the python script and decodes its JSON output into v
+func py_run(t *testing.T, python, script string, v interface{}, args ...string) {
+	cmd := exec.Command(python, append([]string{"-c", script}, args...)...)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("python: %v", err)
+	}
+	err = json.Unmarshal(out, v)
+	if err != nil {
+		t.Fatalf("python: %v\n%s", err, out)
+	}
+}
+
+func py_json(t *testing.T, v interface{}) string {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf)
+}
+
+func TestEnvExpand(t *testing.T) {
+	env := map[string]string{"A": "/opt/a", "B_2": "b", "EMPTY": ""}
+	for _, table := range []struct {
+		in   string
+		want string
+	}{
+		{"$A/bin", "/opt/a/bin"},
+		{"${A}bin", "/opt/abin"},
+		{"$B_2-$B_2", "b-b"},
+		{"x${EMPTY}y", "xy"},
+		{"$UNSET ${UNSET}", "$UNSET ${UNSET}"},
+		{"$$A", "$A"},
+		{"$$$A", "$/opt/a"},
+		{"a${b", "a${b"},
+		{"${}", "${}"},
+		{"${A", "${A"},
+		{"$", "$"},
+		{"a$", "a$"},
+		{"$1 $-", "$1 $-"},
+		{"${A B}", "${A B}"},
+		{"cost: 5$", "cost: 5$"},
+	} {
+		got := env_expand(table.in, env)
+		if got != table.want {
+			t.Errorf("env_expand(%q): got=%q want=%q", table.in, got, table.want)
+		}
+	}
+}
+
+func TestEnvExpandPython(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("no python3")
+	}
+	env := map[string]string{"A": "/opt/a", "B_2": "b", "EMPTY": ""}
+	strs := []string{
+		"$A/bin", "${A}bin", "$B_2-$B_2", "x${EMPTY}y", "$UNSET ${UNSET}",
+		"$$A", "$$$A", "a${b", "${}", "${A", "$", "a$", "$1 $-", "${A B}",
+	}
+	want := []string{}
+	py_run(t, python, py_safe_substitute, &want, py_json(t, strs), py_json(t, env))
+	for i, s := range strs {
+		if got := env_expand(s, env); got != want[i] {
+			t.Errorf("env_expand(%q): got=%q, python=%q", s, got, want[i])
+		}
+	}
+}
+
+func TestRuntimeEnvPython(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("no python3")
+	}
+	test_init_context(t)
+
+	tmpdir, err := ioutil.TempDir("", "hwaf-test-runtime-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	tmpdir, err = filepath.EvalSymlinks(tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	topdir := filepath.Join(tmpdir, "work")
+	for _, dir := range []string{
+		"work/lib", "install", "opt/bin", "opt/sbin", "opt/python",
+		"sys/bin", "sys/python", "sys/mana",
+	} {
+		err = os.MkdirAll(filepath.Join(tmpdir, dir), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cache := filepath.Join(tmpdir, "_cache.py")
+	err = ioutil.WriteFile(cache, []byte(strings.Replace(strings.Join([]string{
+		`CXX = ['g++']`,
+		`DESTDIR = ''`,
+		`HWAF_ENABLE_INSTALL_AREA = ''`,
+		`HWAF_RUNTIME_ENVVARS = ['PATH', 'LD_LIBRARY_PATH', 'PYTHONPATH', 'MANA_PATH', 'MANA_DATA', 'MANA_OPT']`,
+		`INSTALL_AREA = '@T@/install'`,
+		`LD_LIBRARY_PATH = 'lib'`,
+		`MANA_DATA = ['a', 'b']`,
+		`MANA_OPT = '-O2'`,
+		`MANA_PATH = ['@T@/nonexistent/mana']`,
+		`PATH = ['@T@/opt/bin', '@T@/opt/../opt/sbin', '@T@/nonexistent/bin']`,
+		`PREFIX = '@T@/install'`,
+		`PYTHONPATH = ['@T@/opt/python', '@T@/nonexistent/python']`,
+		"",
+	}, "\n"), "@T@", tmpdir, -1)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	environ := map[string]string{
+		"PATH":       filepath.Join(tmpdir, "sys", "bin"),
+		"PYTHONPATH": filepath.Join(tmpdir, "sys", "python"),
+		"MANA_PATH":  filepath.Join(tmpdir, "sys", "mana"),
+		"MANA_OPT":   "-O0",
+	}
+
+	// hwaf
+	pinfos, err := hwaflib.NewProjectInfos(cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops, err := runtime_env_ops(pinfos, topdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := make(map[string]string, len(environ))
+	for k, v := range environ {
+		env[k] = v
+	}
+	env_apply(env, runtime_env_clean(ops))
+
+	// waf (from the top of the workarea, as waf runs)
+	pwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fname := filepath.Join(pwd, "py-hwaftools", "hwaf-runtime.py")
+	err = os.Chdir(topdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(pwd)
+	pyenv := map[string]string{}
+	py_run(t, python, py_runtime_env, &pyenv, fname, cache, py_json(t, environ))
+
+	for _, k := range []string{
+		"PATH", "LD_LIBRARY_PATH", "DYLD_LIBRARY_PATH", "PYTHONPATH",
+		"MANA_PATH", "MANA_DATA", "MANA_OPT", "CXX",
+	} {
+		if env[k] != pyenv[k] {
+			t.Errorf("%s: got=%q, python=%q", k, env[k], pyenv[k])
+		}
+	}
+
+	// the cached operations keep the directories which do not exist yet
+	want := map[string]string{
+		"PATH": strings.Join([]string{
+			filepath.Join(tmpdir, "opt", "bin"),
+			filepath.Join(tmpdir, "opt", "sbin"),
+			filepath.Join(tmpdir, "nonexistent", "bin"),
+		}, env_pathsep),
+		"LD_LIBRARY_PATH": filepath.Join(topdir, "lib"),
+	}
+	got := map[string]string{}
+	for _, op := range ops {
+		if want[op.Key] != "" {
+			got[op.Key] = op.Value
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("runtime_env_ops:\ngot=  %q\nwant= %q", got, want)
+	}
+}
+
+// EOF
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/hwaf/hwaf/hwaflib"
+)
+
+// setup_scripts lists the relocatable setup scripts written into the
+// install area, together with the code locating the install area at runtime.
+// Headers are format strings: %[1]s is the (quoted) install area at build
+// time, used as a fallback, %[2]s the project name and %[3]s the hwaf version.
+var setup_scripts = []struct {
+	fname  string
+	format string
+	header string
+	footer string
+}{
+	{
+		fname:  "setup.sh",
+		format: "sh",
+		header: `# setup script for %[2]s, generated by hwaf-%[3]s
+# usage:
+#  $ . /path/to/%[2]s/setup.sh
+# bash and zsh locate the install area from this file, other POSIX shells
+# use the install area at build time.
+` + env_root_var + `=''
+if [ -n "${BASH_VERSION:-}" ]; then
+  eval '` + env_root_var + `="${BASH_SOURCE[0]}"'
+elif [ -n "${ZSH_VERSION:-}" ]; then
+  eval '` + env_root_var + `="${(%%):-%%x}"'
+fi
+if [ -n "${` + env_root_var + `}" ]; then
+  ` + env_root_var + `="$(cd "$(dirname "${` + env_root_var + `}")" && pwd)"
+fi
+if [ -z "${` + env_root_var + `}" ] || [ ! -f "${` + env_root_var + `}/setup.sh" ]; then
+  ` + env_root_var + `=%[1]s
+fi
+`,
+		footer: "unset " + env_root_var + "\n",
+	},
+	{
+		fname:  "setup.csh",
+		format: "csh",
+		header: `# setup script for %[2]s, generated by hwaf-%[3]s
+# usage (csh, tcsh):
+#  $ source /path/to/%[2]s/setup.csh
+set ` + env_root_var + ` = ""
+set _hwaf_setup_src = ($_)
+if ( $#_hwaf_setup_src >= 2 ) then
+  set ` + env_root_var + ` = ` + "`dirname \"$_hwaf_setup_src[2]\"`" + `
+  set ` + env_root_var + ` = ` + "`cd \"$" + env_root_var + "\" && pwd`" + `
+endif
+if ( "$` + env_root_var + `" == "" || ! -f "$` + env_root_var + `/setup.csh" ) then
+  set ` + env_root_var + ` = %[1]s
+endif
+unset _hwaf_setup_src
+`,
+		footer: "unset " + env_root_var + "\n",
+	},
+	{
+		fname:  "setup.fish",
+		format: "fish",
+		header: `# setup script for %[2]s, generated by hwaf-%[3]s
+# usage:
+#  $ source /path/to/%[2]s/setup.fish
+set -l ` + env_root_var + ` (realpath (dirname (status --current-filename)))
+if not test -f "$` + env_root_var + `/setup.fish"
+    set ` + env_root_var + ` %[1]s
+end
+`,
+		footer: "set -e " + env_root_var + "\n",
+	},
+}
+
+// hwaf_write_setup_scripts writes relocatable setup scripts for the
+// current project into its install area (see hwaf_setup_scripts).
+func hwaf_write_setup_scripts(ops []env_op_t) error {
+	install_area, scripts, err := hwaf_setup_scripts(ops)
+	if err != nil {
+		return err
+	}
+	for _, script := range scripts {
+		fname := filepath.Join(install_area, script.name)
+		err = ioutil.WriteFile(fname, script.data, 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hwaf_setup_scripts returns the install area of the current project and
+// its relocatable setup scripts, with their path relative to it.
+// The scripts apply the runtime environment ops of the project (see
+// hwaf_runtime_env), with paths relative to their own location.
+func hwaf_setup_scripts(ops []env_op_t) (string, []tar_entry_t, error) {
+	pinfos, err := g_ctx.ProjectInfos()
+	if err != nil {
+		return "", nil, err
+	}
+
+	name, err := pinfos.Get("HWAF_PROJECT_NAME")
+	if err != nil {
+		return "", nil, err
+	}
+	if vers, err := pinfos.Get("HWAF_PROJECT_VERSION"); err == nil && vers != "" {
+		name += "-" + vers
+	}
+
+	install_area, err := project_install_area(pinfos)
+	if err != nil {
+		return "", nil, err
+	}
+	if !path_exists(install_area) {
+		return "", nil, fmt.Errorf(
+			"no such directory [%s]. did you run \"hwaf install\" ?",
+			install_area,
+		)
+	}
+
+	ops = setup_env_ops(pinfos, ops, install_area)
+	scripts := make([]tar_entry_t, 0, len(setup_scripts))
+	for _, script := range setup_scripts {
+		write_env, err := env_writer_for(script.format)
+		if err != nil {
+			return "", nil, err
+		}
+		quote := map[string]func(string) string{
+			"sh":   sh_quote,
+			"csh":  csh_quote,
+			"fish": fish_quote,
+		}[script.format]
+
+		buf := new(bytes.Buffer)
+		fmt.Fprintf(buf, script.header, quote(install_area), name, g_ctx.Version())
+		err = write_env(buf, ops)
+		if err != nil {
+			return "", nil, err
+		}
+		buf.WriteString(script.footer)
+		scripts = append(scripts, tar_entry_t{name: script.fname, data: buf.Bytes()})
+	}
+	return install_area, scripts, nil
+}
+
+// project_install_area returns the absolute path to the install area of
+// the project described by pinfos.
+func project_install_area(pinfos *hwaflib.ProjectInfos) (string, error) {
+	install_area, err := pinfos.Get("INSTALL_AREA")
+	if err != nil || install_area == "" {
+		install_area, err = pinfos.Get("PREFIX")
+		if err != nil {
+			return "", err
+		}
+	}
+	if destdir, err := pinfos.Get("DESTDIR"); err == nil && destdir != "" {
+		install_area = filepath.Join(destdir, install_area)
+	}
+	return filepath.Abs(install_area)
+}
+
+// upstream_projects returns the (name, version) pairs of the upstream
+// projects listed in the local configuration (local.conf's projects).
+func upstream_projects() ([][2]string, error) {
+	projs := [][2]string{}
+	projects := cfg_string("hwaf-cfg", "projects", "")
+	for _, projdir := range strings.Split(projects, env_pathsep) {
+		if projdir == "" {
+			continue
+		}
+		pinfo, err := hwaflib.NewProjectInfos(filepath.Join(projdir, "project.info"))
+		if err != nil {
+			return nil, err
+		}
+		name, err := pinfo.Get("HWAF_PROJECT_NAME")
+		if err != nil {
+			return nil, fmt.Errorf("hwaf: project [%s]: %v", projdir, err)
+		}
+		vers, _ := pinfo.Get("HWAF_PROJECT_VERSION")
+		projs = append(projs, [2]string{name, vers})
+	}
+	return projs, nil
+}
+
+// setup_env_ops returns the runtime environment operations ops of a project
+// installed under root, restricted to the variables declared as runtime
+// variables (HWAF_RUNTIME_ENVVARS). Unset operations are dropped: setup
+// scripts only add to the user's environment.
+func setup_env_ops(pinfos *hwaflib.ProjectInfos, ops []env_op_t, root string) []env_op_t {
+	keys, err := pinfos.GetList("HWAF_RUNTIME_ENVVARS")
+	if err != nil {
+		g_ctx.Debugf("hwaf: no runtime variables (%v). using the whole environment\n", err)
+	}
+	runtime := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		runtime[k] = true
+	}
+
+	// the runtime environment may refer to the install area w/ symlinks resolved
+	real, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		real = root
+	}
+
+	out := make([]env_op_t, 0, len(ops))
+	for _, op := range ops {
+		if op.Action == env_unset || (len(runtime) > 0 && !runtime[op.Key]) {
+			continue
+		}
+		if real != root {
+			op.Value = strings.Replace(op.Value, real, root, -1)
+		}
+		op.Root = root
+		out = append(out, op)
+	}
+	return out
+}
+
+// EOF
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// err_unsigned is returned when a binary distribution has no signature
+var err_unsigned = errors.New("hwaf: binary distribution is not signed")
+
+// key_t is a ed25519 key used to sign binary distributions.
+//
+// Keys are stored as one line of text:
+//
+//	hwaf-ed25519-pub <id> <name> <base64 public key>
+//	hwaf-ed25519-key <id> <name> <base64 private key seed>
+//
+// where id is derived from the public key.
+type key_t struct {
+	Id   string
+	Name string
+	Pub  ed25519.PublicKey
+	Priv ed25519.PrivateKey // nil for public keys
+}
+
+// key_id returns the identifier of a public key
+func key_id(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return fmt.Sprintf("%x", sum[:8])
+}
+
+// key_generate creates a new key pair
+func key_generate(name string) (key_t, error) {
+	if name == "" || strings.ContainsAny(name, " \t\n/") {
+		return key_t{}, fmt.Errorf("hwaf: invalid key name [%s]", name)
+	}
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return key_t{}, err
+	}
+	return key_t{Id: key_id(pub), Name: name, Pub: pub, Priv: priv}, nil
+}
+
+// PublicString returns the public key line of k
+func (k key_t) PublicString() string {
+	return fmt.Sprintf("hwaf-ed25519-pub %s %s %s",
+		k.Id, k.Name, base64.StdEncoding.EncodeToString(k.Pub),
+	)
+}
+
+// PrivateString returns the private key line of k
+func (k key_t) PrivateString() string {
+	return fmt.Sprintf("hwaf-ed25519-key %s %s %s",
+		k.Id, k.Name, base64.StdEncoding.EncodeToString(k.Priv.Seed()),
+	)
+}
+
+// key_parse decodes a public or private key line
+func key_parse(line string) (key_t, error) {
+	var k key_t
+	fields := strings.Fields(line)
+	if len(fields) != 4 {
+		return k, fmt.Errorf("hwaf: invalid key [%s]", line)
+	}
+	data, err := base64.StdEncoding.DecodeString(fields[3])
+	if err != nil {
+		return k, fmt.Errorf("hwaf: invalid key [%s]: %v", fields[2], err)
+	}
+	switch fields[0] {
+	case "hwaf-ed25519-pub":
+		if len(data) != ed25519.PublicKeySize {
+			return k, fmt.Errorf("hwaf: invalid public key [%s]", fields[2])
+		}
+		k.Pub = ed25519.PublicKey(data)
+	case "hwaf-ed25519-key":
+		if len(data) != ed25519.SeedSize {
+			return k, fmt.Errorf("hwaf: invalid private key [%s]", fields[2])
+		}
+		k.Priv = ed25519.NewKeyFromSeed(data)
+		k.Pub = k.Priv.Public().(ed25519.PublicKey)
+	default:
+		return k, fmt.Errorf("hwaf: unknown key type [%s]", fields[0])
+	}
+	k.Id = key_id(k.Pub)
+	k.Name = fields[2]
+	if k.Id != fields[1] {
+		return k, fmt.Errorf("hwaf: key [%s]: id mismatch (%s, expected %s)", k.Name, fields[1], k.Id)
+	}
+	return k, nil
+}
+
+// keys_dir returns the directory holding the keys of the user
+func keys_dir() string {
+	dir := cfg_string("hwaf-keys", "dir", "${HOME}/.config/hwaf/keys")
+	return os.ExpandEnv(dir)
+}
+
+// trusted_keys_files returns the files listing the trusted public keys.
+// The first one is the one of the user, which 'hwaf keys trust' modifies.
+func trusted_keys_files() []string {
+	user := "${HOME}/.config/hwaf/trusted-keys"
+	files := cfg_string("hwaf-keys", "trusted", user+env_pathsep+"/etc/hwaf/trusted-keys")
+	if strings.TrimSpace(files) == "" {
+		files = user
+	}
+	out := []string{}
+	for _, fname := range strings.Split(files, env_pathsep) {
+		if fname != "" {
+			out = append(out, os.ExpandEnv(fname))
+		}
+	}
+	return out
+}
+
+// keys_read reads the keys stored (one per line) in fname
+func keys_read(fname string) ([]key_t, error) {
+	keys := []key_t{}
+	f, err := os.Open(fname)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return keys, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	scan := bufio.NewScanner(f)
+	iline := 0
+	for scan.Scan() {
+		iline++
+		line := strings.TrimSpace(scan.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		k, err := key_parse(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", fname, iline, err)
+		}
+		keys = append(keys, k)
+	}
+	return keys, scan.Err()
+}
+
+// keys_trusted returns the trusted public keys
+func keys_trusted() ([]key_t, error) {
+	keys := []key_t{}
+	for _, fname := range trusted_keys_files() {
+		fkeys, err := keys_read(fname)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, fkeys...)
+	}
+	return keys, nil
+}
+
+// keys_private returns the private keys of the user, sorted by name
+func keys_private() ([]key_t, error) {
+	files, err := filepath.Glob(filepath.Join(keys_dir(), "*.key"))
+	if err != nil {
+		return nil, err
+	}
+	keys := []key_t{}
+	for _, fname := range files {
+		fkeys, err := keys_read(fname)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, fkeys...)
+	}
+	return keys, nil
+}
+
+// key_load returns the private key name: the name of a key of the keys
+// directory or the path to a key file.
+func key_load(name string) (key_t, error) {
+	fname := os.ExpandEnv(name)
+	if !path_exists(fname) {
+		fname = filepath.Join(keys_dir(), name+".key")
+	}
+	if !path_exists(fname) {
+		return key_t{}, fmt.Errorf("hwaf: no such key [%s] (see 'hwaf keys gen')", name)
+	}
+	keys, err := keys_read(fname)
+	if err != nil {
+		return key_t{}, err
+	}
+	if len(keys) != 1 || keys[0].Priv == nil {
+		return key_t{}, fmt.Errorf("hwaf: [%s] does not hold a private key", fname)
+	}
+	return keys[0], nil
+}
+
+// key_save writes the key pair k in the keys directory (<name>.key, with
+// restricted permissions, and <name>.pub).
+func key_save(k key_t) error {
+	dir := keys_dir()
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+	fname := filepath.Join(dir, k.Name+".key")
+	if path_exists(fname) {
+		return fmt.Errorf("hwaf: key [%s] already exists (%s)", k.Name, fname)
+	}
+	err = ioutil.WriteFile(fname, []byte(k.PrivateString()+"\n"), 0600)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(
+		filepath.Join(dir, k.Name+".pub"),
+		[]byte(k.PublicString()+"\n"),
+		0644,
+	)
+}
+
+// keys_trust adds the public keys to the trusted keys of the user (the
+// first trusted keys file) and returns the keys which were not already
+// trusted.
+func keys_trust(keys []key_t) ([]key_t, error) {
+	fname := trusted_keys_files()[0]
+	trusted, err := keys_trusted()
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool, len(trusted))
+	for _, k := range trusted {
+		known[k.Id] = true
+	}
+
+	added := []key_t{}
+	lines := ""
+	for _, k := range keys {
+		if known[k.Id] {
+			continue
+		}
+		known[k.Id] = true
+		added = append(added, k)
+		lines += k.PublicString() + "\n"
+	}
+	if len(added) == 0 {
+		return added, nil
+	}
+
+	err = os.MkdirAll(filepath.Dir(fname), 0755)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(fname, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	_, err = f.WriteString(lines)
+	if err != nil {
+		return nil, err
+	}
+	return added, f.Close()
+}
+
+// keys_untrust removes the keys with the given name or id from the trusted
+// keys of the user (the first trusted keys file) and returns them.
+func keys_untrust(id string) ([]key_t, error) {
+	fname := trusted_keys_files()[0]
+	keys, err := keys_read(fname)
+	if err != nil {
+		return nil, err
+	}
+	removed := []key_t{}
+	lines := ""
+	for _, k := range keys {
+		if k.Id == id || k.Name == id {
+			removed = append(removed, k)
+			continue
+		}
+		lines += k.PublicString() + "\n"
+	}
+	if len(removed) == 0 {
+		return removed, nil
+	}
+	return removed, ioutil.WriteFile(fname, []byte(lines), 0644)
+}
+
+// sig_message returns the message signed for a file with the given SHA-256
+func sig_message(sum string) []byte {
+	return []byte("hwaf-bdist-signature-v1\n" + sum + "\n")
+}
+
+// sig_fname returns the name of the detached signature of fname
+func sig_fname(fname string) string {
+	return fname + ".sig"
+}
+
+// sig_sign writes the detached signature of the file fname with the key k.
+//
+// The signature file holds:
+//
+//	hwaf-ed25519-sig <key id> <key name>
+//	sha256 <hex-encoded SHA-256 of the file>
+//	<base64 signature>
+func sig_sign(fname string, k key_t) error {
+	sum, err := sha256_file(fname)
+	if err != nil {
+		return err
+	}
+	sig := ed25519.Sign(k.Priv, sig_message(sum))
+	data := fmt.Sprintf("hwaf-ed25519-sig %s %s\nsha256 %s\n%s\n",
+		k.Id, k.Name, sum, base64.StdEncoding.EncodeToString(sig),
+	)
+	return ioutil.WriteFile(sig_fname(fname), []byte(data), 0644)
+}
+
+// sig_verify checks the detached signature of the file fname against the
+// trusted keys and returns the key which signed it.
+// It returns err_unsigned if fname has no signature.
+func sig_verify(fname string) (key_t, error) {
+	var k key_t
+	data, err := ioutil.ReadFile(sig_fname(fname))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return k, err_unsigned
+		}
+		return k, err
+	}
+	lines := strings.Split(string(bytes.TrimSpace(data)), "\n")
+	if len(lines) != 3 {
+		return k, fmt.Errorf("hwaf: invalid signature file [%s]", sig_fname(fname))
+	}
+	hdr := strings.Fields(lines[0])
+	digest := strings.Fields(lines[1])
+	if len(hdr) != 3 || hdr[0] != "hwaf-ed25519-sig" || len(digest) != 2 || digest[0] != "sha256" {
+		return k, fmt.Errorf("hwaf: invalid signature file [%s]", sig_fname(fname))
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[2]))
+	if err != nil {
+		return k, fmt.Errorf("hwaf: invalid signature file [%s]: %v", sig_fname(fname), err)
+	}
+
+	sum, err := sha256_file(fname)
+	if err != nil {
+		return k, err
+	}
+	if sum != digest[1] {
+		return k, fmt.Errorf("hwaf: [%s] does not match its signature (sha256 %s, expected %s)", fname, sum, digest[1])
+	}
+
+	trusted, err := keys_trusted()
+	if err != nil {
+		return k, err
+	}
+	for _, k = range trusted {
+		if k.Id != hdr[1] {
+			continue
+		}
+		if !ed25519.Verify(k.Pub, sig_message(sum), sig) {
+			return k, fmt.Errorf("hwaf: invalid signature of [%s] by key [%s] (%s)", fname, k.Name, k.Id)
+		}
+		return k, nil
+	}
+	return key_t{}, fmt.Errorf("hwaf: [%s] is signed by an untrusted key [%s] (%s)", fname, hdr[2], hdr[1])
+}
+
+// EOF
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hwaf/hwaf/hwaflib"
+)
+
+// test_init_context initializes g_ctx for the tests needing logging or the
+// configuration. The context is usable even outside of a hwaf
+// installation (without local or global configuration).
+func test_init_context(t *testing.T) {
+	if g_ctx != nil {
+		return
+	}
+	ctx, err := hwaflib.NewContext()
+	if ctx == nil {
+		t.Skipf("could not create hwaf context: %v", err)
+	}
+	g_ctx = ctx
+}
+
+func TestKeyParse(t *testing.T) {
+	k, err := key_generate("my-site")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := key_generate("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := strings.Fields(k.PublicString())
+	priv := strings.Fields(k.PrivateString())
+
+	for _, table := range []struct {
+		line string
+		priv bool
+		err  string
+	}{
+		{line: k.PublicString()},
+		{line: k.PrivateString(), priv: true},
+		{line: "  " + k.PublicString() + "\n"},
+		{
+			line: strings.Join(pub[:3], " "),
+			err:  "invalid key",
+		},
+		{
+			line: k.PublicString() + " extra",
+			err:  "invalid key",
+		},
+		{
+			line: strings.Join([]string{pub[0], pub[1], pub[2], "not-base64!"}, " "),
+			err:  "invalid key [my-site]",
+		},
+		{
+			// a private key seed is not a public key
+			line: strings.Join([]string{pub[0], pub[1], pub[2], priv[3][:len(priv[3])-4]}, " "),
+			err:  "invalid public key",
+		},
+		{
+			line: strings.Join([]string{priv[0], priv[1], priv[2], pub[3][:len(pub[3])-4]}, " "),
+			err:  "invalid private key",
+		},
+		{
+			line: strings.Join([]string{"hwaf-rsa-pub", pub[1], pub[2], pub[3]}, " "),
+			err:  "unknown key type",
+		},
+		{
+			line: strings.Join([]string{pub[0], other.Id, pub[2], pub[3]}, " "),
+			err:  "id mismatch",
+		},
+	} {
+		got, err := key_parse(table.line)
+		if table.err != "" {
+			if err == nil || !strings.Contains(err.Error(), table.err) {
+				t.Errorf("key_parse(%q): got err=%v, want %q", table.line, err, table.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("key_parse(%q): %v", table.line, err)
+			continue
+		}
+		if got.Id != k.Id || got.Name != k.Name || string(got.Pub) != string(k.Pub) {
+			t.Errorf("key_parse(%q): got=%s (%s) want=%s (%s)", table.line, got.Name, got.Id, k.Name, k.Id)
+		}
+		if (got.Priv != nil) != table.priv {
+			t.Errorf("key_parse(%q): private key: got=%v want=%v", table.line, got.Priv != nil, table.priv)
+		}
+	}
+}
+
+func TestSigVerify(t *testing.T) {
+	test_init_context(t)
+
+	tmpdir, err := ioutil.TempDir("", "hwaf-test-sign-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	// the trusted keys of the user
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", tmpdir)
+	trusted, err := key_generate("my-site")
+	if err != nil {
+		t.Fatal(err)
+	}
+	untrusted, err := key_generate("elsewhere")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.MkdirAll(filepath.Join(tmpdir, ".config", "hwaf"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(
+		filepath.Join(tmpdir, ".config", "hwaf", "trusted-keys"),
+		[]byte(trusted.PublicString()+"\n"),
+		0644,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write := func(name, content string) string {
+		fname := filepath.Join(tmpdir, name)
+		err := ioutil.WriteFile(fname, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fname
+	}
+
+	// signed by a trusted key
+	fname := write("mana.tar.gz", "mana")
+	err = sig_sign(fname, trusted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := sig_verify(fname)
+	if err != nil {
+		t.Fatalf("could not verify signature: %v", err)
+	}
+	if k.Id != trusted.Id {
+		t.Fatalf("signed by [%s], expected [%s]", k.Id, trusted.Id)
+	}
+
+	for _, table := range []struct {
+		name string
+		prep func(fname string)
+		err  string
+	}{
+		{
+			name: "tampered",
+			prep: func(fname string) {
+				sig_sign(fname, trusted)
+				write(filepath.Base(fname), "tampered")
+			},
+			err: "does not match its signature",
+		},
+		{
+			// the checksum of the signature file is updated, not the signature
+			name: "forged",
+			prep: func(fname string) {
+				sig_sign(fname, trusted)
+				old, _ := sha256_file(fname)
+				write(filepath.Base(fname), "forged")
+				sum, _ := sha256_file(fname)
+				data, _ := ioutil.ReadFile(sig_fname(fname))
+				write(filepath.Base(sig_fname(fname)), strings.Replace(string(data), old, sum, 1))
+			},
+			err: "invalid signature",
+		},
+		{
+			name: "untrusted",
+			prep: func(fname string) {
+				sig_sign(fname, untrusted)
+			},
+			err: "untrusted key [elsewhere]",
+		},
+		{
+			name: "truncated",
+			prep: func(fname string) {
+				sig_sign(fname, trusted)
+				data, _ := ioutil.ReadFile(sig_fname(fname))
+				lines := strings.Split(string(data), "\n")
+				write(filepath.Base(sig_fname(fname)), strings.Join(lines[:2], "\n"))
+			},
+			err: "invalid signature file",
+		},
+		{
+			name: "unsigned",
+			prep: func(fname string) {},
+			err:  err_unsigned.Error(),
+		},
+	} {
+		fname := write(table.name+".tar.gz", "original")
+		table.prep(fname)
+		_, err := sig_verify(fname)
+		if err == nil || !strings.Contains(err.Error(), table.err) {
+			t.Errorf("%s: got err=%v, want %q", table.name, err, table.err)
+		}
+	}
+}
+
+// EOF
@@ -2,70 +2,298 @@ package main
 
 import (
 	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
 	"compress/gzip"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
-func _tar_gz(targ, workdir string) error {
+// tar_compressors lists the compression formats of (binary) tarballs, with
+// the suffix of the corresponding files.
+var tar_compressors = map[string]string{
+	"gz":  ".tar.gz",
+	"bz2": ".tar.bz2",
+	"xz":  ".tar.xz",
+}
+
+// tar_formats returns the names of the supported compression formats
+func tar_formats() []string {
+	formats := make([]string, 0, len(tar_compressors))
+	for k := range tar_compressors {
+		formats = append(formats, k)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// cmd_writer is an io.WriteCloser feeding an external (filter) command
+type cmd_writer struct {
+	io.WriteCloser
+	cmd *exec.Cmd
+}
+
+func (w *cmd_writer) Close() error {
+	err := w.WriteCloser.Close()
+	if err != nil {
+		return err
+	}
+	return w.cmd.Wait()
+}
+
+// cmd_reader is an io.ReadCloser reading from an external (filter) command
+type cmd_reader struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
 
-	// FIXME: use archive/tar instead (once go-1.1 is out)
-	{
-		matches, err := filepath.Glob(filepath.Join(workdir, "*"))
+func (r *cmd_reader) Close() error {
+	r.ReadCloser.Close()
+	return r.cmd.Wait()
+}
+
+// tar_filter_cmd returns the external command (de)compressing the given
+// format, as the standard library only provides a gzip writer.
+func tar_filter_cmd(format string, args ...string) (*exec.Cmd, error) {
+	name := map[string]string{"bz2": "bzip2", "xz": "xz"}[format]
+	bin, err := exec.LookPath(name)
+	if err != nil {
+		return nil, fmt.Errorf("hwaf: %s compression needs the %s command (%v)", format, name, err)
+	}
+	cmd := exec.Command(bin, args...)
+	cmd.Stderr = os.Stderr
+	return cmd, nil
+}
+
+// tar_compress returns a writer compressing into w with the given format.
+// bzip2 and xz compression need the bzip2 and xz commands.
+func tar_compress(w io.Writer, format string) (io.WriteCloser, error) {
+	switch format {
+	case "gz":
+		// a zero gzip header (no name, no mtime) keeps the output reproducible
+		return gzip.NewWriterLevel(w, gzip.BestCompression)
+	case "bz2", "xz":
+		level := map[string]string{"bz2": "-9", "xz": "-6"}[format]
+		cmd, err := tar_filter_cmd(format, "-c", level)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		for i, m := range matches {
-			matches[i] = m[len(workdir)+1:]
+		cmd.Stdout = w
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return nil, err
+		}
+		err = cmd.Start()
+		if err != nil {
+			return nil, err
 		}
-		args := []string{"-zcf", targ}
-		args = append(args, matches...)
-		//fmt.Printf(">> %v\n", args)
-		cmd := exec.Command("tar", args...)
-		cmd.Dir = workdir
-		cmd.Stdin = os.Stdin
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		return cmd.Run()
+		return &cmd_writer{stdin, cmd}, nil
 	}
+	return nil, fmt.Errorf(
+		"hwaf: unknown compression format [%s] (expected one of %s)",
+		format, strings.Join(tar_formats(), ", "),
+	)
+}
 
-	f, err := os.Create(targ)
+// tar_decompress returns a reader decompressing r, the compression format
+// (gzip, bzip2, xz or none) being detected from its first bytes.
+// xz decompression needs the xz command.
+func tar_decompress(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(6)
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		return ioutil.NopCloser(bzip2.NewReader(br)), nil
+	case bytes.HasPrefix(magic, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}):
+		cmd, err := tar_filter_cmd("xz", "-d", "-c")
+		if err != nil {
+			return nil, err
+		}
+		cmd.Stdin = br
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		err = cmd.Start()
+		if err != nil {
+			return nil, err
+		}
+		return &cmd_reader{stdout, cmd}, nil
+	}
+	return ioutil.NopCloser(br), nil
+}
+
+// tar_open opens the (compressed) tarball fname
+func tar_open(fname string) (io.ReadCloser, error) {
+	f, err := os.Open(fname)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	zout := gzip.NewWriter(f)
-	tw := tar.NewWriter(zout)
+	r, err := tar_decompress(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{r, multi_closer{r, f}}, nil
+}
+
+// multi_closer closes all its io.Closers, returning the first error
+type multi_closer []io.Closer
 
-	err = filepath.Walk(workdir, func(path string, fi os.FileInfo, err error) error {
-		//fmt.Printf("::> [%s]...\n", path)
-		if !strings.HasPrefix(path, workdir) {
-			err = fmt.Errorf("walked filename %q doesn't begin with workdir %q", path, workdir)
+func (mc multi_closer) Close() error {
+	var err error
+	for _, c := range mc {
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// source_date_epoch returns the time set by $SOURCE_DATE_EPOCH, if any.
+func source_date_epoch() (int64, bool) {
+	v := os.Getenv("SOURCE_DATE_EPOCH")
+	if v == "" {
+		return 0, false
+	}
+	t, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return t, true
+}
+
+// tar_mtime returns the modification time recorded for all the entries of a
+// tarball of root: $SOURCE_DATE_EPOCH or the most recent modification time
+// of the tree, so that the same tree always yields the same tarball.
+func tar_mtime(root string) (time.Time, error) {
+	if t, ok := source_date_epoch(); ok {
+		return time.Unix(t, 0), nil
+	}
+	var mtime time.Time
+	err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
 			return err
+		}
+		if fi.ModTime().After(mtime) {
+			mtime = fi.ModTime()
+		}
+		return nil
+	})
+	if err != nil {
+		return mtime, err
+	}
+	return mtime.Truncate(time.Second), nil
+}
+
+// tar_write_tree writes the content of the directory root into tw, under the
+// virtual directory prefix (at the top of the archive if prefix is empty).
+// Entries are written in lexical order, owned by root, with permissions
+// forced to 0755 (executables and directories) or 0644 and their
+// modification time set to mtime. root itself is only read.
+//
+// If keep is not nil, only the files and directories (by their path
+// relative to root) for which it returns true are written, together with
+// their parent directories. tar_write_tree returns the set of directories
+// it wrote.
+func tar_write_tree(tw *tar.Writer, root, prefix string, mtime time.Time, keep func(name string) bool) (map[string]bool, error) {
+	root = filepath.Clean(root)
+	prefix = strings.Trim(path.Clean("/"+filepath.ToSlash(prefix)), "/")
+	dirs := make(map[string]bool)
 
+	// mkdir writes the entries of dir (relative to root, "." being root
+	// itself) and of its parents, if not written yet
+	mkdir := func(dir string) error {
+		parents := []string{}
+		for d := dir; !dirs[d]; d = path.Dir(d) {
+			parents = append([]string{d}, parents...)
+			if d == "." {
+				break
+			}
 		}
-		name := path[len(workdir):] //path
+		for _, d := range parents {
+			dirs[d] = true
+			names := []string{path.Join(prefix, d) + "/"}
+			if d == "." {
+				// the top directory is the virtual prefix, with its parents
+				names = names[:0]
+				for dir := prefix; dir != "" && dir != "."; dir = path.Dir(dir) {
+					names = append([]string{dir + "/"}, names...)
+				}
+			}
+			for _, name := range names {
+				err := tw.WriteHeader(&tar.Header{
+					Name:     name,
+					Mode:     0755,
+					Typeflag: tar.TypeDir,
+					ModTime:  mtime,
+					Uname:    "root",
+					Gname:    "root",
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
 
-		// make name "relative"
-		if strings.HasPrefix(name, "/") {
-			name = name[1:]
+	// filepath.Walk visits the entries in lexical order
+	err := filepath.Walk(root, func(fname string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
 		}
-		target, _ := os.Readlink(path)
+		rel, err := filepath.Rel(root, fname)
 		if err != nil {
 			return err
 		}
-		hdr, err := tar.FileInfoHeader(fi, target)
+		rel = filepath.ToSlash(rel)
+		if rel != "." && keep != nil && !keep(rel) {
+			return nil
+		}
+		if fi.IsDir() {
+			return mkdir(rel)
+		}
+		err = mkdir(path.Dir(rel))
 		if err != nil {
 			return err
 		}
+		name := path.Join(prefix, rel)
+
+		target := ""
+		if fi.Mode()&os.ModeSymlink != 0 {
+			target, err = os.Readlink(fname)
+			if err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(fi, target)
+		if err != nil {
+			return fmt.Errorf("hwaf: could not archive [%s]: %v", fname, err)
+		}
 		hdr.Name = name
 		hdr.Uname = "root"
 		hdr.Gname = "root"
 		hdr.Uid = 0
 		hdr.Gid = 0
+		hdr.ModTime = mtime
+		hdr.AccessTime = time.Time{}
+		hdr.ChangeTime = time.Time{}
 
 		// Force permissions to 0755 for executables, 0644 for everything else.
 		if fi.Mode().Perm()&0111 != 0 {
@@ -76,30 +304,140 @@ func _tar_gz(targ, workdir string) error {
 
 		err = tw.WriteHeader(hdr)
 		if err != nil {
-			return fmt.Errorf("Error writing file %q: %v", name, err)
+			return fmt.Errorf("hwaf: error writing file %q: %v", name, err)
 		}
-		// handle directories and symlinks
-		if hdr.Size <= 0 {
+		if !fi.Mode().IsRegular() {
 			return nil
 		}
-		r, err := os.Open(path)
+		r, err := os.Open(fname)
 		if err != nil {
 			return err
 		}
 		defer r.Close()
-		_, err = io.Copy(tw, r)
+		n, err := io.Copy(tw, r)
+		if err != nil {
+			return err
+		}
+		if n != hdr.Size {
+			return fmt.Errorf("hwaf: file [%s] changed while being archived", fname)
+		}
+		return nil
+	})
+	return dirs, err
+}
+
+// tar_entry_t is a file added to a tarball on top of a directory tree
+type tar_entry_t struct {
+	name string // path of the file, relative to the tree
+	data []byte // content of the file
+}
+
+// tar_write_entries writes the files entries into tw, under the virtual
+// directory prefix, after a tree was written (dirs being the set of the
+// directories it wrote). Missing parent directories are added.
+func tar_write_entries(tw *tar.Writer, prefix string, mtime time.Time, dirs map[string]bool, entries []tar_entry_t) error {
+	prefix = strings.Trim(path.Clean("/"+filepath.ToSlash(prefix)), "/")
+	for _, entry := range entries {
+		name := strings.Trim(path.Clean("/"+entry.name), "/")
+		parents := []string{}
+		for dir := path.Dir(name); dir != "." && !dirs[dir]; dir = path.Dir(dir) {
+			parents = append([]string{dir}, parents...)
+		}
+		for _, dir := range parents {
+			dirs[dir] = true
+			err := tw.WriteHeader(&tar.Header{
+				Name:     path.Join(prefix, dir) + "/",
+				Mode:     0755,
+				Typeflag: tar.TypeDir,
+				ModTime:  mtime,
+				Uname:    "root",
+				Gname:    "root",
+			})
+			if err != nil {
+				return err
+			}
+		}
+		err := tw.WriteHeader(&tar.Header{
+			Name:     path.Join(prefix, name),
+			Mode:     0644,
+			Size:     int64(len(entry.data)),
+			Typeflag: tar.TypeReg,
+			ModTime:  mtime,
+			Uname:    "root",
+			Gname:    "root",
+		})
+		if err != nil {
+			return err
+		}
+		_, err = tw.Write(entry.data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tar_create writes the tarball targ holding the content of the directory
+// root under the virtual directory prefix, compressed with format (gz, bz2
+// or xz), followed by the additional files entries. If keep is not nil,
+// only the files for which it returns true are written (see
+// tar_write_tree).
+// The tarball is first written to a temporary file, so an interruption
+// never leaves a truncated targ behind.
+func tar_create(targ, root, prefix, format string, keep func(name string) bool, entries ...tar_entry_t) error {
+	mtime, err := tar_mtime(root)
+	if err != nil {
 		return err
+	}
+
+	tmp := targ + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	defer f.Close()
+
+	zw, err := tar_compress(f, format)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(zw)
+	// the additional files replace the ones of the tree
+	skip := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		skip[strings.Trim(path.Clean("/"+entry.name), "/")] = true
+	}
+	dirs, err := tar_write_tree(tw, root, prefix, mtime, func(name string) bool {
+		return !skip[name] && (keep == nil || keep(name))
 	})
+	if err == nil {
+		err = tar_write_entries(tw, prefix, mtime, dirs, entries)
+	}
 	if err != nil {
+		zw.Close()
 		return err
 	}
-	if err := tw.Close(); err != nil {
+	err = tw.Close()
+	if err != nil {
+		zw.Close()
 		return err
 	}
-	if err := zout.Close(); err != nil {
+	err = zw.Close()
+	if err != nil {
 		return err
 	}
-	return f.Close()
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, targ)
+}
+
+// _tar_gz writes the gzip-compressed tarball targ holding the content of
+// the directory workdir.
+func _tar_gz(targ, workdir string) error {
+	return tar_create(targ, workdir, "", "gz", nil)
 }
 
 // EOF